@@ -0,0 +1,104 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"slices"
+
+	"github.com/DanyPops/logues/domain/auth"
+	"github.com/DanyPops/logues/domain/user"
+)
+
+// requireAdmin lets through the configured admins and API keys with the admin
+// scope.
+func (s *Server) requireAdmin(r *http.Request, u user.User) error {
+	token, _ := auth.TokenFromContext(r.Context())
+	key, ok, err := s.apiKeys.Lookup(token)
+	if ok {
+		if err != nil {
+			return err
+		}
+
+		if !key.Allows(auth.ScopeAdmin) {
+			return fmt.Errorf("API key %s lacks the %s scope", key.ID, auth.ScopeAdmin)
+		}
+
+		return nil
+	}
+
+	// The admins are local users, whatever an IdP calls its own.
+	if auth.IsOIDCName(u.Name) || !slices.Contains(s.admins, u.Name) {
+		return fmt.Errorf("%s isn't an admin", u.Name)
+	}
+
+	return nil
+}
+
+type apiKeyRequest struct {
+	Name    string       `json:"name"`
+	Service string       `json:"service"`
+	Scopes  []auth.Scope `json:"scopes"`
+}
+
+type apiKeyResponse struct {
+	auth.APIKey
+	Secret string `json:"secret"`
+}
+
+func (s *Server) apiKeyCreateHandler(w http.ResponseWriter, r *http.Request) {
+	var req apiKeyRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		auth.WriteError(w, http.StatusBadRequest, "bad_request", "malformed API key request")
+		return
+	}
+
+	key, secret, err := s.apiKeys.Create(req.Name, req.Service, req.Scopes)
+	var policyErr auth.PolicyError
+	if errors.As(err, &policyErr) {
+		auth.WriteError(w, http.StatusBadRequest, policyErr.Field+"_policy", policyErr.Error())
+		return
+	}
+	if err != nil {
+		slog.Error("API key creation failed", "err", err)
+		auth.WriteError(w, http.StatusInternalServerError, "internal", "API key creation failed")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	if err := json.NewEncoder(w).Encode(apiKeyResponse{APIKey: key, Secret: secret}); err != nil {
+		slog.Error("API key encoding failed", "err", err)
+	}
+}
+
+func (s *Server) apiKeyListHandler(w http.ResponseWriter, r *http.Request) {
+	keys, err := s.apiKeys.List()
+	if err != nil {
+		slog.Error("API key listing failed", "err", err)
+		auth.WriteError(w, http.StatusInternalServerError, "internal", "API key listing failed")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(keys); err != nil {
+		slog.Error("API key encoding failed", "err", err)
+	}
+}
+
+func (s *Server) apiKeyRevokeHandler(w http.ResponseWriter, r *http.Request) {
+	err := s.apiKeys.Revoke(r.PathValue("id"))
+	if errors.Is(err, auth.ErrAPIKeyNotFound) {
+		auth.WriteError(w, http.StatusNotFound, "not_found", err.Error())
+		return
+	}
+	if err != nil {
+		slog.Error("API key revocation failed", "err", err)
+		auth.WriteError(w, http.StatusInternalServerError, "internal", "API key revocation failed")
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,248 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"fmt"
+	"log/slog"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/DanyPops/logues/domain/audit"
+	"github.com/DanyPops/logues/domain/auth"
+	"github.com/DanyPops/logues/domain/message"
+)
+
+const (
+	defaultPort = "7331"
+
+	TokenAuthOTP    = "otp"
+	TokenAuthSigned = "signed"
+)
+
+type Config struct {
+	Port string
+	// UserFile keeps registered accounts across restarts; accounts live in
+	// memory only when it's empty.
+	UserFile string
+	// HtpasswdFile replaces registered accounts with a static user list,
+	// see auth.HtpasswdAuth.
+	HtpasswdFile string
+	// PasswordCost is the bcrypt cost new passwords are hashed with.
+	PasswordCost int
+	// TokenAuth selects the TokenAuthenticator, TokenAuthOTP keeps tokens in
+	// this instance's memory while TokenAuthSigned lets any instance holding
+	// the keys verify them. OTPs are used up by the first request, which
+	// only suits clients that just open a WebSocket.
+	TokenAuth string
+	// TokenKeys are auth.ParseSigningKey specs, the first one signs and the
+	// rest only verify. Without them a random key signs, which only this
+	// instance knows until it restarts.
+	TokenKeys   []string
+	TokenTTL    time.Duration
+	TokenIssuer string
+	// SessionTTL bounds how long a login can be refreshed.
+	SessionTTL time.Duration
+	Lockout    auth.LockoutConfig
+	// Admins are the usernames allowed to manage API keys.
+	Admins []string
+	// Moderators are the usernames allowed to delete anyone's messages,
+	// admins included.
+	Moderators []string
+	// IdleTimeout is how long connected users stay online without activity
+	// before they're away.
+	IdleTimeout time.Duration
+	// HistorySize is how many messages the channel keeps for replays.
+	HistorySize int
+	// Inbound is what the content of messages users send must be like.
+	Inbound message.InboundConfig
+	// AuditFile receives the audit log as JSON lines, rotated once it
+	// reaches AuditMaxSize bytes.
+	AuditFile       string
+	AuditMaxSize    int64
+	AuditMaxBackups int
+	// AuditSinks receive the audit log besides AuditFile.
+	AuditSinks []audit.Sink
+	// OIDC enables logging in with an OpenID Connect provider when its
+	// Issuer is set.
+	OIDC auth.OIDCConfig
+}
+
+func DefaultConfig() Config {
+	return Config{
+		Port:         defaultPort,
+		PasswordCost: auth.DefaultPasswordCost,
+		TokenAuth:    TokenAuthSigned,
+		TokenIssuer:  "logues",
+		Lockout:      auth.NewLockoutConfig(),
+		Inbound:      message.NewInboundConfig(),
+		OIDC:         auth.NewOIDCConfig("", "", "", ""),
+	}
+}
+
+// ConfigFromEnv overrides the defaults with the LOGUES_* environment variables.
+func ConfigFromEnv() (Config, error) {
+	c := DefaultConfig()
+
+	if v, ok := os.LookupEnv("LOGUES_PORT"); ok {
+		c.Port = v
+	}
+
+	if v, ok := os.LookupEnv("LOGUES_USER_FILE"); ok {
+		c.UserFile = v
+	}
+
+	if v, ok := os.LookupEnv("LOGUES_HTPASSWD_FILE"); ok {
+		c.HtpasswdFile = v
+	}
+
+	if v, ok := os.LookupEnv("LOGUES_PASSWORD_COST"); ok {
+		cost, err := strconv.Atoi(v)
+		if err != nil {
+			return c, fmt.Errorf("LOGUES_PASSWORD_COST: %w", err)
+		}
+		if cost > auth.MaxPasswordCost {
+			return c, fmt.Errorf("LOGUES_PASSWORD_COST: %d exceeds %d", cost, auth.MaxPasswordCost)
+		}
+		c.PasswordCost = cost
+	}
+
+	if v, ok := os.LookupEnv("LOGUES_TOKEN_AUTH"); ok {
+		c.TokenAuth = v
+	}
+
+	if v, ok := os.LookupEnv("LOGUES_TOKEN_KEYS"); ok {
+		c.TokenKeys = strings.Split(v, ",")
+	}
+
+	if v, ok := os.LookupEnv("LOGUES_TOKEN_TTL"); ok {
+		ttl, err := time.ParseDuration(v)
+		if err != nil {
+			return c, fmt.Errorf("LOGUES_TOKEN_TTL: %w", err)
+		}
+		c.TokenTTL = ttl
+	}
+
+	if v, ok := os.LookupEnv("LOGUES_TOKEN_ISSUER"); ok {
+		c.TokenIssuer = v
+	}
+
+	if v, ok := os.LookupEnv("LOGUES_ADMINS"); ok {
+		c.Admins = strings.Split(v, ",")
+	}
+
+	if v, ok := os.LookupEnv("LOGUES_MODERATORS"); ok {
+		c.Moderators = strings.Split(v, ",")
+	}
+
+	if v, ok := os.LookupEnv("LOGUES_SESSION_TTL"); ok {
+		ttl, err := time.ParseDuration(v)
+		if err != nil {
+			return c, fmt.Errorf("LOGUES_SESSION_TTL: %w", err)
+		}
+		c.SessionTTL = ttl
+	}
+
+	if v, ok := os.LookupEnv("LOGUES_IDLE_TIMEOUT"); ok {
+		idle, err := time.ParseDuration(v)
+		if err != nil {
+			return c, fmt.Errorf("LOGUES_IDLE_TIMEOUT: %w", err)
+		}
+		c.IdleTimeout = idle
+	}
+
+	if v, ok := os.LookupEnv("LOGUES_HISTORY_SIZE"); ok {
+		size, err := strconv.Atoi(v)
+		if err != nil {
+			return c, fmt.Errorf("LOGUES_HISTORY_SIZE: %w", err)
+		}
+		c.HistorySize = size
+	}
+
+	if v, ok := os.LookupEnv("LOGUES_MAX_MESSAGE_LENGTH"); ok {
+		length, err := strconv.Atoi(v)
+		if err != nil {
+			return c, fmt.Errorf("LOGUES_MAX_MESSAGE_LENGTH: %w", err)
+		}
+		c.Inbound.MaxLength = length
+	}
+
+	if v, ok := os.LookupEnv("LOGUES_NORMALIZATION_FORM"); ok {
+		c.Inbound.Form = v
+	}
+
+	if v, ok := os.LookupEnv("LOGUES_AUDIT_FILE"); ok {
+		c.AuditFile = v
+	}
+
+	if v, ok := os.LookupEnv("LOGUES_AUDIT_MAX_SIZE"); ok {
+		size, err := strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			return c, fmt.Errorf("LOGUES_AUDIT_MAX_SIZE: %w", err)
+		}
+		c.AuditMaxSize = size
+	}
+
+	if v, ok := os.LookupEnv("LOGUES_AUDIT_MAX_BACKUPS"); ok {
+		backups, err := strconv.Atoi(v)
+		if err != nil {
+			return c, fmt.Errorf("LOGUES_AUDIT_MAX_BACKUPS: %w", err)
+		}
+		c.AuditMaxBackups = backups
+	}
+
+	if v, ok := os.LookupEnv("LOGUES_OIDC_ISSUER"); ok {
+		c.OIDC.Issuer = v
+	}
+
+	if v, ok := os.LookupEnv("LOGUES_OIDC_CLIENT_ID"); ok {
+		c.OIDC.ClientID = v
+	}
+
+	if v, ok := os.LookupEnv("LOGUES_OIDC_CLIENT_SECRET"); ok {
+		c.OIDC.ClientSecret = v
+	}
+
+	if v, ok := os.LookupEnv("LOGUES_OIDC_REDIRECT_URL"); ok {
+		c.OIDC.RedirectURL = v
+	}
+
+	return c, nil
+}
+
+func newTokenAuthenticator(ctx context.Context, c Config) (auth.TokenAuthenticator, error) {
+	switch c.TokenAuth {
+	case TokenAuthOTP:
+		return auth.NewOTPRetentionMap(ctx, auth.NewOTPConfig()), nil
+
+	case TokenAuthSigned:
+		if len(c.TokenKeys) == 0 {
+			secret := make([]byte, 32)
+			if _, err := rand.Read(secret); err != nil {
+				return nil, fmt.Errorf("generating token key: %w", err)
+			}
+			key, err := auth.NewHMACKey("local", secret)
+			if err != nil {
+				return nil, err
+			}
+			slog.Warn("signing tokens with a random key, set LOGUES_TOKEN_KEYS to share them between instances")
+			return auth.NewSignedTokenAuth(auth.NewKeyRing(key), c.TokenTTL, c.TokenIssuer), nil
+		}
+
+		keys := make([]auth.SigningKey, len(c.TokenKeys))
+		for i, spec := range c.TokenKeys {
+			key, err := auth.ParseSigningKey(spec)
+			if err != nil {
+				return nil, err
+			}
+			keys[i] = key
+		}
+
+		ring := auth.NewKeyRing(keys[0], keys[1:]...)
+		return auth.NewSignedTokenAuth(ring, c.TokenTTL, c.TokenIssuer), nil
+	}
+
+	return nil, fmt.Errorf("unknown token authentication %q", c.TokenAuth)
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+
+	"github.com/DanyPops/logues/domain/auth"
+	"github.com/DanyPops/logues/domain/contact"
+	"github.com/DanyPops/logues/domain/presence"
+	"github.com/DanyPops/logues/domain/relation"
+	"github.com/DanyPops/logues/domain/user"
+)
+
+type contactRequest struct {
+	UserID string `json:"user_id"`
+}
+
+// contactsHandler lists the user's contacts with their presence.
+func (s *Server) contactsHandler(w http.ResponseWriter, r *http.Request) {
+	u, _ := auth.UserFromContext(r.Context())
+
+	ids, err := s.contacts.Contacts(u.ID)
+	if err != nil {
+		slog.Error("contact lookup failed", "err", err)
+		auth.WriteError(w, http.StatusInternalServerError, "internal", "contact lookup failed")
+		return
+	}
+
+	contacts := make([]presence.Presence, 0, len(ids))
+	for _, id := range ids {
+		p := s.presence.Get(id)
+		if profile, err := s.profiles.Get(id); err == nil {
+			p.User = profile.User
+		}
+		contacts = append(contacts, p)
+	}
+
+	writeJSON(w, http.StatusOK, contacts)
+}
+
+// contactRequestsHandler lists the requests waiting for the user's answer.
+func (s *Server) contactRequestsHandler(w http.ResponseWriter, r *http.Request) {
+	u, _ := auth.UserFromContext(r.Context())
+
+	pending, err := s.contacts.Pending(u.ID)
+	if err != nil {
+		slog.Error("contact request lookup failed", "err", err)
+		auth.WriteError(w, http.StatusInternalServerError, "internal", "contact request lookup failed")
+		return
+	}
+
+	writeJSON(w, http.StatusOK, pending)
+}
+
+// sendContactRequestHandler asks another user to become a contact and tells
+// every connection of theirs right away.
+func (s *Server) sendContactRequestHandler(w http.ResponseWriter, r *http.Request) {
+	from, _ := auth.UserFromContext(r.Context())
+
+	var req contactRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.UserID == "" {
+		auth.WriteError(w, http.StatusBadRequest, "bad_request", "contact request needs a user_id")
+		return
+	}
+
+	_, err := s.profiles.Get(req.UserID)
+	if err == nil {
+		err = relation.CheckDirect(s.relations, from.ID, req.UserID)
+	}
+	var request contact.Request
+	if err == nil {
+		request, err = s.contacts.Request(from.ID, req.UserID)
+	}
+
+	switch {
+	case errors.Is(err, user.ErrNotFound):
+		auth.WriteError(w, http.StatusNotFound, "user_not_found", "user not found")
+
+	case errors.Is(err, relation.ErrBlocked):
+		auth.WriteError(w, http.StatusForbidden, "blocked", "contact requests between these users are blocked")
+
+	case errors.Is(err, contact.ErrSelf):
+		auth.WriteError(w, http.StatusBadRequest, "self_contact", err.Error())
+
+	case errors.Is(err, contact.ErrRequestExists):
+		auth.WriteError(w, http.StatusConflict, "request_exists", err.Error())
+
+	case errors.Is(err, contact.ErrAlreadyContacts):
+		auth.WriteError(w, http.StatusConflict, "already_contacts", err.Error())
+
+	case err != nil:
+		slog.Error("contact request failed", "err", err)
+		auth.WriteError(w, http.StatusInternalServerError, "internal", "contact request failed")
+
+	default:
+		s.clientServer.SendToUser(request.To, encode(contact.Event{Type: contact.RequestEventType, User: from, Request: request}))
+		writeJSON(w, http.StatusCreated, request)
+	}
+}
+
+// answerContactRequestHandler accepts or declines the request sent by the
+// user with the ID in the path, the sender learns about acceptance only.
+func (s *Server) answerContactRequestHandler(accept bool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		u, _ := auth.UserFromContext(r.Context())
+		from := r.PathValue("id")
+
+		answer := s.contacts.Decline
+		if accept {
+			answer = s.contacts.Accept
+		}
+
+		err := answer(from, u.ID)
+		if errors.Is(err, contact.ErrNoRequest) {
+			auth.WriteError(w, http.StatusNotFound, "request_not_found", "no pending request from this user")
+			return
+		}
+		if err != nil {
+			slog.Error("contact request answer failed", "err", err)
+			auth.WriteError(w, http.StatusInternalServerError, "internal", "contact request answer failed")
+			return
+		}
+
+		if accept {
+			e := contact.Event{Type: contact.AcceptedEventType, User: u, Request: contact.Request{From: from, To: u.ID}}
+			s.clientServer.SendToUser(from, encode(e))
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
@@ -0,0 +1,348 @@
+package main
+
+import (
+	"errors"
+	"log/slog"
+	"net/http"
+	"slices"
+	"strconv"
+	"time"
+
+	"github.com/DanyPops/logues/domain/auth"
+	"github.com/DanyPops/logues/domain/channel"
+	"github.com/DanyPops/logues/domain/message"
+	"github.com/DanyPops/logues/domain/presence"
+	"github.com/DanyPops/logues/domain/relation"
+	"github.com/DanyPops/logues/domain/user"
+)
+
+// replayResponse holds the replayed messages the user may see, Last is the
+// sequence number to continue after as some may have been left out.
+type replayResponse struct {
+	Messages []message.Message `json:"messages"`
+	Last     uint64            `json:"last"`
+}
+
+// replayHandler returns the channel's messages following the after
+// parameter, so clients can fill the gaps they noticed in the sequence.
+func (s *Server) replayHandler(w http.ResponseWriter, r *http.Request) {
+	after, limit, ok := replayPage(w, r)
+	if !ok {
+		return
+	}
+
+	messages, err := s.messages.After(after, limit)
+	if err != nil {
+		slog.Error("message replay failed", "err", err)
+		auth.WriteError(w, http.StatusInternalServerError, "internal", "message replay failed")
+		return
+	}
+
+	u, _ := auth.UserFromContext(r.Context())
+	writeJSON(w, http.StatusOK, s.replay(u, messages, after))
+}
+
+// repliesHandler pages through the replies to the message with the ID in
+// the path, after and Last count within the thread.
+func (s *Server) repliesHandler(w http.ResponseWriter, r *http.Request) {
+	after, limit, ok := replayPage(w, r)
+	if !ok {
+		return
+	}
+
+	replies, err := s.messages.Replies(r.PathValue("id"), after, limit)
+	if errors.Is(err, message.ErrNotFound) {
+		auth.WriteError(w, http.StatusNotFound, "message_not_found", "message not found")
+		return
+	}
+	if err != nil {
+		slog.Error("reply lookup failed", "err", err)
+		auth.WriteError(w, http.StatusInternalServerError, "internal", "reply lookup failed")
+		return
+	}
+
+	u, _ := auth.UserFromContext(r.Context())
+	writeJSON(w, http.StatusOK, s.replay(u, replies, after))
+}
+
+// replayPage parses the after and limit parameters, writing the error when
+// either is invalid.
+func replayPage(w http.ResponseWriter, r *http.Request) (uint64, int, bool) {
+	query := r.URL.Query()
+
+	var after uint64
+	if raw := query.Get("after"); raw != "" {
+		var err error
+		if after, err = strconv.ParseUint(raw, 10, 64); err != nil {
+			auth.WriteError(w, http.StatusBadRequest, "after_invalid", "after must be a sequence number")
+			return 0, 0, false
+		}
+	}
+
+	limit := message.DefaultReplayLimit
+	if raw := query.Get("limit"); raw != "" {
+		var err error
+		if limit, err = strconv.Atoi(raw); err != nil || limit <= 0 || limit > message.MaxReplayLimit {
+			auth.WriteError(w, http.StatusBadRequest, "limit_invalid", "limit must be between 1 and "+strconv.Itoa(message.MaxReplayLimit))
+			return 0, 0, false
+		}
+	}
+
+	return after, limit, true
+}
+
+// replay keeps the messages u may see, flagging those of users u muted.
+func (s *Server) replay(u user.User, messages []message.Message, after uint64) replayResponse {
+	resp := replayResponse{Messages: make([]message.Message, 0, len(messages)), Last: after}
+	filter := relation.Filter(s.relations)
+	for _, msg := range messages {
+		resp.Last = msg.Seq
+		switch filter(u.ID, msg.SenderID) {
+		case channel.Skip:
+			continue
+		case channel.Flag:
+			msg.Muted = true
+		}
+		resp.Messages = append(resp.Messages, msg)
+	}
+
+	return resp
+}
+
+// Time the channel has to take a message posted over HTTP.
+const postTimeout = 5 * time.Second
+
+// post hands msg to the channel and waits for how it took it, writing the
+// error when it refused it or didn't answer in time.
+func (s *Server) post(w http.ResponseWriter, r *http.Request, msg message.Message) (channel.PostResult, bool) {
+	results := make(chan channel.PostResult, 1)
+	timeout := time.NewTimer(postTimeout)
+	defer timeout.Stop()
+
+	var result channel.PostResult
+	select {
+	case s.channel.PostMessage <- channel.Post{Message: msg, Result: results}:
+		select {
+		case result = <-results:
+		case <-timeout.C:
+			auth.WriteError(w, http.StatusServiceUnavailable, "timeout", "channel didn't take the message in time")
+			return result, false
+		}
+
+	case <-timeout.C:
+		auth.WriteError(w, http.StatusServiceUnavailable, "timeout", "channel didn't take the message in time")
+		return result, false
+
+	case <-r.Context().Done():
+		return result, false
+	}
+
+	switch {
+	case errors.Is(result.Err, message.ErrNotFound):
+		auth.WriteError(w, http.StatusNotFound, "message_not_found", "parent message not found")
+	case errors.Is(result.Err, message.ErrNestedThread):
+		auth.WriteError(w, http.StatusBadRequest, "nested_thread", message.ErrNestedThread.Error())
+	case errors.Is(result.Err, message.ErrDeleted):
+		auth.WriteError(w, http.StatusConflict, "message_deleted", message.ErrDeleted.Error())
+	case result.Err != nil:
+		slog.Error("posting message failed", "err", result.Err)
+		auth.WriteError(w, http.StatusInternalServerError, "internal", "posting message failed")
+	default:
+		return result, true
+	}
+
+	return result, false
+}
+
+// mentioned lists who msg notifies: the users it names, everyone connected
+// for @channel and those active for @here. The sender and those who blocked
+// or muted them are left out.
+func (s *Server) mentioned(msg message.Message) []string {
+	ids := slices.Clone(msg.Mentions.Users)
+	if msg.Mentions.Channel || msg.Mentions.Here {
+		for _, p := range s.presence.Present() {
+			if msg.Mentions.Channel || p.Status == presence.Online {
+				ids = append(ids, p.User.ID)
+			}
+		}
+	}
+	slices.Sort(ids)
+
+	filter := relation.Filter(s.relations)
+	return slices.DeleteFunc(slices.Compact(ids), func(id string) bool {
+		return id == msg.SenderID || filter(id, msg.SenderID) != channel.Deliver
+	})
+}
+
+// notifyMentions sends the mention event of msg to every connection of the
+// users it notifies. It mustn't run in the channel's loop, which the presence
+// tracker may be waiting on.
+func (s *Server) notifyMentions(msg message.Message) {
+	ids := s.mentioned(msg)
+	if len(ids) == 0 {
+		return
+	}
+
+	data := encode(msg.MentionEvent())
+	for _, id := range ids {
+		s.clientServer.SendToUser(id, data)
+	}
+}
+
+// isModerator tells if u may delete anyone's messages.
+func (s *Server) isModerator(r *http.Request, u user.User) bool {
+	return !auth.IsOIDCName(u.Name) && slices.Contains(s.moderators, u.Name) || s.requireAdmin(r, u) == nil
+}
+
+// errNotSender refuses changes to someone else's message.
+var errNotSender = errors.New("not the sender")
+
+// changeMessage changes the message with the ID in the path to what f makes
+// of it, in one go so reactions and replies landing meanwhile aren't lost.
+// It writes the error when there's one.
+func (s *Server) changeMessage(w http.ResponseWriter, r *http.Request, f func(message.Message) (message.Message, error), forbidden string) (message.Message, bool) {
+	msg, err := s.messages.Change(r.PathValue("id"), f)
+	switch {
+	case errors.Is(err, message.ErrNotFound):
+		auth.WriteError(w, http.StatusNotFound, "message_not_found", "message not found")
+		return msg, false
+
+	case errors.Is(err, errNotSender):
+		auth.WriteError(w, http.StatusForbidden, "forbidden", forbidden)
+		return msg, false
+
+	case errors.Is(err, message.ErrDeleted):
+		auth.WriteError(w, http.StatusConflict, "message_deleted", err.Error())
+		return msg, false
+
+	case err != nil:
+		slog.Error("message update failed", "err", err)
+		auth.WriteError(w, http.StatusInternalServerError, "internal", "message update failed")
+		return msg, false
+	}
+
+	return msg, true
+}
+
+func (s *Server) editMessageHandler(w http.ResponseWriter, r *http.Request) {
+	u, _ := auth.UserFromContext(r.Context())
+
+	req, ok := decodeMessageRequest(w, r)
+	if !ok {
+		return
+	}
+
+	edit, err := s.inbound.Clean(message.Message{Content: req.Content})
+	if err != nil {
+		writeContentError(w, err)
+		return
+	}
+	if edit.Content == "" {
+		auth.WriteError(w, http.StatusBadRequest, "bad_request", "message needs content")
+		return
+	}
+
+	msg, ok := s.changeMessage(w, r, func(m message.Message) (message.Message, error) {
+		if m.SenderID != u.ID {
+			return m, errNotSender
+		}
+
+		m, err := m.Edit(edit.Content, time.Now())
+		if err != nil {
+			return m, err
+		}
+
+		return m.WithMentions(s.profiles), nil
+	}, "only the sender can edit a message")
+	if !ok {
+		return
+	}
+
+	s.channel.BroadcastEvent <- msg.Event()
+	writeJSON(w, http.StatusOK, msg)
+}
+
+// deleteMessageHandler leaves a tombstone of the sender's message, or anyone's
+// when a moderator deletes it.
+func (s *Server) deleteMessageHandler(w http.ResponseWriter, r *http.Request) {
+	u, _ := auth.UserFromContext(r.Context())
+	moderator := s.isModerator(r, u)
+
+	deleted := false
+	msg, ok := s.changeMessage(w, r, func(m message.Message) (message.Message, error) {
+		if m.SenderID != u.ID && !moderator {
+			return m, errNotSender
+		}
+
+		deleted = !m.Deleted
+		return m.Delete(), nil
+	}, "only the sender or a moderator can delete a message")
+	if !ok {
+		return
+	}
+
+	if deleted {
+		s.channel.BroadcastEvent <- msg.Event()
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// messageVersionsHandler lists what a message said before its edits.
+func (s *Server) messageVersionsHandler(w http.ResponseWriter, r *http.Request) {
+	versions, err := s.messages.Versions(r.PathValue("id"))
+	if errors.Is(err, message.ErrNotFound) {
+		auth.WriteError(w, http.StatusNotFound, "message_not_found", "message not found")
+		return
+	}
+	if err != nil {
+		slog.Error("message versions lookup failed", "err", err)
+		auth.WriteError(w, http.StatusInternalServerError, "internal", "message versions lookup failed")
+		return
+	}
+
+	writeJSON(w, http.StatusOK, versions)
+}
+
+// reactionHandler adds or removes the user's reaction with the emoji in the
+// path, telling the channel only about actual changes.
+func (s *Server) reactionHandler(add bool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		u, _ := auth.UserFromContext(r.Context())
+		emoji := r.PathValue("emoji")
+		if !message.ValidEmoji(emoji) {
+			auth.WriteError(w, http.StatusBadRequest, "emoji_invalid", "reactions must be a single emoji")
+			return
+		}
+
+		changed := false
+		msg, err := s.messages.Change(r.PathValue("id"), func(m message.Message) (message.Message, error) {
+			var err error
+			if add {
+				m, changed, err = m.React(emoji, u.ID)
+			} else {
+				m, changed = m.Unreact(emoji, u.ID)
+			}
+			return m, err
+		})
+
+		switch {
+		case errors.Is(err, message.ErrNotFound):
+			auth.WriteError(w, http.StatusNotFound, "message_not_found", "message not found")
+			return
+
+		case errors.Is(err, message.ErrDeleted):
+			auth.WriteError(w, http.StatusConflict, "message_deleted", err.Error())
+			return
+
+		case err != nil:
+			slog.Error("reaction failed", "err", err)
+			auth.WriteError(w, http.StatusInternalServerError, "internal", "reaction failed")
+			return
+		}
+
+		if changed {
+			s.channel.BroadcastEvent <- msg.ReactionEvent(emoji, u.ID, add)
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
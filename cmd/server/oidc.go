@@ -0,0 +1,88 @@
+package main
+
+import (
+	"errors"
+	"log/slog"
+	"net/http"
+
+	"github.com/DanyPops/logues/domain/audit"
+	"github.com/DanyPops/logues/domain/auth"
+	"github.com/DanyPops/logues/domain/user"
+)
+
+// oidcLoginHandler sends the browser to the provider. The state is also kept
+// in a cookie so a callback can't be completed in someone else's browser.
+func (s *Server) oidcLoginHandler(w http.ResponseWriter, r *http.Request) {
+	loginURL, state, err := s.oidc.LoginURL()
+	if err != nil {
+		slog.Error("OIDC login failed", "err", err)
+		auth.WriteError(w, http.StatusInternalServerError, "internal", "OIDC login failed")
+		return
+	}
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     auth.OIDCStateCookieName,
+		Value:    state,
+		Path:     "/auth/oidc",
+		HttpOnly: true,
+		Secure:   r.TLS != nil,
+		// The provider redirects back cross site.
+		SameSite: http.SameSiteLaxMode,
+	})
+	http.Redirect(w, r, loginURL, http.StatusFound)
+}
+
+// oidcCallbackHandler starts a session like a password login would and sends
+// the browser home, which picks it up through the refresh cookie.
+func (s *Server) oidcCallbackHandler(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	if query.Has("error") {
+		auth.WriteError(w, http.StatusUnauthorized, "oidc_"+query.Get("error"), query.Get("error_description"))
+		return
+	}
+
+	cookie, err := r.Cookie(auth.OIDCStateCookieName)
+	if err != nil || cookie.Value != query.Get("state") {
+		auth.WriteError(w, http.StatusBadRequest, "invalid_state", "login was started elsewhere")
+		return
+	}
+	http.SetCookie(w, &http.Cookie{Name: auth.OIDCStateCookieName, Path: "/auth/oidc", MaxAge: -1})
+
+	profile, err := s.oidc.Exchange(r.Context(), query.Get("code"), cookie.Value)
+	if err != nil {
+		e := audit.RequestEvent(audit.LoginFailed, r)
+		e.Reason = "oidc"
+		s.audit.Log(e)
+	}
+	if errors.Is(err, auth.ErrOIDCState) {
+		auth.WriteError(w, http.StatusBadRequest, "invalid_state", err.Error())
+		return
+	}
+	if err != nil {
+		slog.Warn("OIDC login failed", "err", err)
+		auth.WriteError(w, http.StatusUnauthorized, "invalid_credentials", "OIDC login failed")
+		return
+	}
+
+	if profile, err = user.ProvisionProfile(s.profiles, profile); err != nil {
+		slog.Error("provisioning user failed", "err", err)
+		auth.WriteError(w, http.StatusInternalServerError, "internal", "OIDC login failed")
+		return
+	}
+
+	e := audit.RequestEvent(audit.LoginSucceeded, r)
+	e.User, e.Reason = profile.Name, "oidc"
+	s.audit.Log(e)
+
+	grant, err := s.sessions.Start(profile.User)
+	if err != nil {
+		slog.Error("session creation failed", "err", err)
+		auth.WriteError(w, http.StatusInternalServerError, "internal", "session creation failed")
+		return
+	}
+
+	s.logGrant(r, grant, "oidc")
+	http.SetCookie(w, auth.TokenCookie(r, grant.Token))
+	http.SetCookie(w, auth.RefreshCookie(r, grant))
+	http.Redirect(w, r, "/", http.StatusFound)
+}
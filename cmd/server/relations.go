@@ -0,0 +1,121 @@
+package main
+
+import (
+	"errors"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/DanyPops/logues/domain/auth"
+	"github.com/DanyPops/logues/domain/message"
+	"github.com/DanyPops/logues/domain/relation"
+	"github.com/DanyPops/logues/domain/user"
+)
+
+// relationListHandler lists the IDs on the user's list of kind.
+func (s *Server) relationListHandler(kind relation.Kind) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		u, _ := auth.UserFromContext(r.Context())
+
+		targets, err := s.relations.List(u.ID, kind)
+		if err != nil {
+			slog.Error("relation lookup failed", "kind", kind, "err", err)
+			auth.WriteError(w, http.StatusInternalServerError, "internal", "relation lookup failed")
+			return
+		}
+
+		writeJSON(w, http.StatusOK, targets)
+	}
+}
+
+func (s *Server) relationAddHandler(kind relation.Kind) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		u, _ := auth.UserFromContext(r.Context())
+		target := r.PathValue("id")
+
+		_, err := s.profiles.Get(target)
+		if err == nil {
+			err = s.relations.Add(u.ID, kind, target)
+		}
+
+		switch {
+		case errors.Is(err, user.ErrNotFound):
+			auth.WriteError(w, http.StatusNotFound, "user_not_found", "user not found")
+
+		case errors.Is(err, relation.ErrSelf):
+			auth.WriteError(w, http.StatusBadRequest, "self_relation", err.Error())
+
+		case err != nil:
+			slog.Error("relation update failed", "kind", kind, "err", err)
+			auth.WriteError(w, http.StatusInternalServerError, "internal", "relation update failed")
+
+		default:
+			w.WriteHeader(http.StatusNoContent)
+		}
+	}
+}
+
+func (s *Server) relationRemoveHandler(kind relation.Kind) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		u, _ := auth.UserFromContext(r.Context())
+
+		if err := s.relations.Remove(u.ID, kind, r.PathValue("id")); err != nil {
+			slog.Error("relation update failed", "kind", kind, "err", err)
+			auth.WriteError(w, http.StatusInternalServerError, "internal", "relation update failed")
+			return
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// directMessageHandler sends a message to every connection of the user with
+// the ID in the path and the sender's own, unless either blocked the other.
+func (s *Server) directMessageHandler(w http.ResponseWriter, r *http.Request) {
+	from, _ := auth.UserFromContext(r.Context())
+	to := r.PathValue("id")
+
+	req, ok := decodeMessageRequest(w, r)
+	if !ok {
+		return
+	}
+
+	msg, err := req.message(s.inbound)
+	if err != nil {
+		writeContentError(w, err)
+		return
+	}
+
+	_, err = s.profiles.Get(to)
+	if err == nil {
+		err = relation.CheckDirect(s.relations, from.ID, to)
+	}
+
+	switch {
+	case errors.Is(err, user.ErrNotFound):
+		auth.WriteError(w, http.StatusNotFound, "user_not_found", "user not found")
+		return
+
+	case errors.Is(err, relation.ErrBlocked):
+		auth.WriteError(w, http.StatusForbidden, "blocked", "direct messages between these users are blocked")
+		return
+
+	case err != nil:
+		slog.Error("relation lookup failed", "err", err)
+		auth.WriteError(w, http.StatusInternalServerError, "internal", "relation lookup failed")
+		return
+	}
+
+	now := time.Now()
+	msg.ID, msg.Time, msg.To = message.NewID(now), now, to
+	msg = msg.From(from, s.profiles)
+	s.clientServer.SendToUser(from.ID, encode(msg))
+	if muted, _ := s.relations.Has(to, relation.Mute, from.ID); muted {
+		msg.Muted = true
+	}
+	if from.ID != to {
+		s.clientServer.SendToUser(to, encode(msg))
+	}
+
+	w.WriteHeader(http.StatusAccepted)
+}
@@ -1,119 +1,641 @@
 package main
 
 import (
+	"bytes"
+	"context"
 	"embed"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"log/slog"
+	"math"
+	"net"
 	"net/http"
+	"strconv"
 	"time"
 
+	"github.com/DanyPops/logues/domain/audit"
 	"github.com/DanyPops/logues/domain/auth"
 	"github.com/DanyPops/logues/domain/channel"
 	"github.com/DanyPops/logues/domain/client"
 	"github.com/DanyPops/logues/domain/connection"
+	"github.com/DanyPops/logues/domain/contact"
+	"github.com/DanyPops/logues/domain/message"
+	"github.com/DanyPops/logues/domain/presence"
+	"github.com/DanyPops/logues/domain/relation"
+	"github.com/DanyPops/logues/domain/user"
 )
 
 var (
-  //go:embed "main.html"
-  mainHtml embed.FS
+	//go:embed "main.html"
+	mainHtml embed.FS
 )
 
 type Server struct {
 	http.Handler
 	clientServer       *client.ClientServer
 	authenticator      auth.Authenticator
+	registrar          auth.UserRegistrar
+	totp               *auth.TOTPAuth
+	sessions           *auth.Sessions
+	apiKeys            *auth.APIKeys
+	oidc               *auth.OIDCProvider
+	profiles           user.Store
+	presence           *presence.Tracker
+	relations          relation.Store
+	contacts           contact.Store
+	messages           message.Store
+	inbound            message.Inbound
+	audit              *audit.Logger
+	auditFile          *audit.FileSink
+	admins             []string
+	moderators         []string
 	connectionUpgrader connection.ConnectionUpgrader
 	channel            *channel.Channel
+	cancel             context.CancelFunc
 }
 
 func New() *Server {
+	l, err := NewWithConfig(DefaultConfig())
+	if err != nil {
+		panic(err)
+	}
+
+	return l
+}
+
+func NewWithConfig(config Config) (*Server, error) {
+	var store auth.UserStore = auth.NewInMemoryUserStore()
+	if config.UserFile != "" {
+		fileStore, err := auth.NewFileUserStore(config.UserFile)
+		if err != nil {
+			return nil, err
+		}
+		store = fileStore
+	}
+	passwordAuth := auth.NewPasswordAuth(store, auth.NewPasswordPolicy(), config.PasswordCost)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	tokenAuth, err := newTokenAuthenticator(ctx, config)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+
 	l := new(Server)
+	l.cancel = cancel
+	sinks := config.AuditSinks
+	if config.AuditFile != "" {
+		l.auditFile, err = audit.NewFileSink(config.AuditFile, config.AuditMaxSize, config.AuditMaxBackups)
+		if err != nil {
+			cancel()
+			return nil, err
+		}
+		sinks = append(sinks, l.auditFile)
+	}
+	l.audit = audit.NewLogger(sinks...)
 	l.clientServer = client.NewClientServer()
+	l.profiles = user.NewInMemoryStore()
+	l.relations = relation.NewInMemoryStore()
+	l.contacts = contact.NewInMemoryStore()
+	l.sessions = auth.NewSessions(ctx, auth.NewInMemorySessionStore(), tokenAuth, config.SessionTTL)
+	l.apiKeys = auth.NewAPIKeys(l.sessions, auth.NewInMemoryAPIKeyStore())
+	l.admins = config.Admins
+	l.moderators = config.Moderators
+	var userAuth auth.UserAuthenticator = passwordAuth
+	l.registrar = passwordAuth
+	if config.HtpasswdFile != "" {
+		htpasswd, err := auth.NewHtpasswdAuth(ctx, config.HtpasswdFile, 0)
+		if err != nil {
+			cancel()
+			return nil, err
+		}
+		userAuth, l.registrar = htpasswd, nil
+	}
+	l.totp = auth.NewTOTPAuth(userAuth, auth.NewInMemoryTOTPStore(), auth.NewTOTPConfig(config.TokenIssuer))
 	l.authenticator = auth.Authenticator{
-		UserAuthenticator:  auth.EchoUserAuth{},
-		TokenAuthenticator: auth.NewOTPRetentionMap(time.Second * 5),
+		UserAuthenticator:  auth.NewLockoutAuth(ctx, l.totp, config.Lockout),
+		TokenAuthenticator: l.apiKeys,
+	}
+	if config.OIDC.Issuer != "" {
+		l.oidc, err = auth.NewOIDCProvider(ctx, config.OIDC, nil)
+		if err != nil {
+			cancel()
+			return nil, err
+		}
 	}
-  l.connectionUpgrader = connection.NewGorillaUpgrader()
-  l.channel = channel.NewDefaultChannel()
-  go l.channel.Start()
+	if l.inbound, err = message.NewInbound(config.Inbound); err != nil {
+		cancel()
+		return nil, err
+	}
+	l.connectionUpgrader = connection.NewGorillaUpgrader(auth.Subprotocol).WithReadLimit(l.inbound.FrameLimit())
+	l.channel = channel.NewDefaultChannel(channel.WithFilter(relation.Filter(l.relations)))
+	l.messages = message.NewInMemoryStore(config.HistorySize)
+	l.channel.History = l.messages
+	l.channel.OnAccept = func(msg message.Message) {
+		go l.notifyMentions(msg)
+	}
+	l.channel.OnEvict = l.evicted
+	go l.channel.Start()
+	l.presence = presence.NewTracker(ctx, config.IdleTimeout, func(e presence.Event) {
+		select {
+		case l.channel.BroadcastEvent <- e:
+		case <-ctx.Done():
+		}
+	})
 
 	m := http.NewServeMux()
 	m.HandleFunc("GET /", l.homeHandler)
+	// Users of a static file can't register.
+	if l.registrar != nil {
+		m.HandleFunc("POST /register", l.registrationHandler)
+	}
 	m.HandleFunc("POST /auth", l.authHandler)
-	m.HandleFunc("GET /ws", l.wsHandler)
-	l.Handler = m
+	m.HandleFunc("POST /auth/refresh", l.refreshHandler)
+	m.HandleFunc("POST /auth/logout", l.logoutHandler)
+	if l.oidc != nil {
+		m.HandleFunc("GET /auth/oidc/login", l.oidcLoginHandler)
+		m.HandleFunc("GET /auth/oidc/callback", l.oidcCallbackHandler)
+	}
+	// API keys need the post scope for anything that changes state.
+	read, post := l.apiKeys.RequireScope(auth.ScopeRead), l.apiKeys.RequireScope(auth.ScopePost)
+	m.Handle("POST /auth/totp", l.protect(l.totpEnrollHandler, post))
+	m.Handle("POST /auth/totp/confirm", l.protect(l.totpConfirmHandler, post))
+	m.Handle("GET /ws", l.protect(l.wsHandler, read))
+	m.Handle("POST /messages", l.protect(l.messageHandler, post))
+	m.Handle("GET /messages", l.protect(l.replayHandler, read))
+	m.Handle("PATCH /messages/{id}", l.protect(l.editMessageHandler, post))
+	m.Handle("DELETE /messages/{id}", l.protect(l.deleteMessageHandler, post))
+	m.Handle("PUT /messages/{id}/reactions/{emoji}", l.protect(l.reactionHandler(true), post))
+	m.Handle("DELETE /messages/{id}/reactions/{emoji}", l.protect(l.reactionHandler(false), post))
+	m.Handle("GET /messages/{id}/versions", l.protect(l.messageVersionsHandler, read))
+	m.Handle("GET /messages/{id}/replies", l.protect(l.repliesHandler, read))
+	m.Handle("POST /apikeys", l.protect(l.apiKeyCreateHandler, l.requireAdmin))
+	m.Handle("GET /apikeys", l.protect(l.apiKeyListHandler, l.requireAdmin))
+	m.Handle("DELETE /apikeys/{id}", l.protect(l.apiKeyRevokeHandler, l.requireAdmin))
+	m.Handle("GET /users", l.protect(l.searchUsersHandler, read))
+	m.Handle("GET /users/me", l.protect(l.meHandler, read))
+	m.Handle("PATCH /users/me", l.protect(l.updateMeHandler, post))
+	m.Handle("GET /users/{id}", l.protect(l.userHandler, read))
+	m.Handle("POST /users/{id}/messages", l.protect(l.directMessageHandler, post))
+	for path, kind := range map[string]relation.Kind{"blocks": relation.Block, "mutes": relation.Mute} {
+		m.Handle("GET /users/me/"+path, l.protect(l.relationListHandler(kind), read))
+		m.Handle("PUT /users/me/"+path+"/{id}", l.protect(l.relationAddHandler(kind), post))
+		m.Handle("DELETE /users/me/"+path+"/{id}", l.protect(l.relationRemoveHandler(kind), post))
+	}
+	m.Handle("GET /contacts", l.protect(l.contactsHandler, read))
+	m.Handle("GET /contacts/requests", l.protect(l.contactRequestsHandler, read))
+	m.Handle("POST /contacts/requests", l.protect(l.sendContactRequestHandler, post))
+	m.Handle("POST /contacts/requests/{id}/accept", l.protect(l.answerContactRequestHandler(true), post))
+	m.Handle("POST /contacts/requests/{id}/decline", l.protect(l.answerContactRequestHandler(false), post))
+	m.Handle("GET /presence", l.protect(l.presenceHandler, read))
+	l.Handler = l.audit.Middleware(m)
 
-	return l
+	return l, nil
+}
+
+// protect lets only authenticated users through to h, see auth.Authorize.
+func (s *Server) protect(h http.HandlerFunc, policies ...auth.Policy) http.Handler {
+	return auth.Authorize(s.authenticator, policies...)(s.provisioned(h))
 }
 
 func (s *Server) Serve(port string) error {
-  slog.Info("starting logues", "port", port)
-  return http.ListenAndServe(fmt.Sprintf(":%s", port), s.Handler)
+	slog.Info("starting logues", "port", port)
+	return http.ListenAndServe(fmt.Sprintf(":%s", port), s.Handler)
+}
+
+// Close stops the background work started by New.
+func (s *Server) Close() {
+	s.cancel()
+	if s.auditFile != nil {
+		s.auditFile.Close()
+	}
 }
 
 func (s *Server) homeHandler(w http.ResponseWriter, r *http.Request) {
-  b, err := mainHtml.ReadFile("main.html")
-  if err != nil {
-    w.WriteHeader(http.StatusInternalServerError)
-    slog.Error("failed to read static file", err)
-    return
-  }
-  w.Write(b)
+	b, err := mainHtml.ReadFile("main.html")
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		slog.Error("failed to read static file", "err", err)
+		return
+	}
+	w.Write(b)
 }
 
-// func (s *Server) registrationHandler(w http.ResponseWriter, r *http.Request) {}
+func (s *Server) registrationHandler(w http.ResponseWriter, r *http.Request) {
+	var cred auth.Credentials
+	if err := json.NewDecoder(r.Body).Decode(&cred); err != nil {
+		auth.WriteError(w, http.StatusBadRequest, "bad_request", "malformed credentials")
+		return
+	}
+
+	user, err := s.registrar.Register(cred)
+	var policyErr auth.PolicyError
+	switch {
+	case errors.Is(err, auth.ErrUserExists):
+		auth.WriteError(w, http.StatusConflict, "username_taken", "username already taken")
+		return
+
+	case errors.As(err, &policyErr):
+		auth.WriteError(w, http.StatusBadRequest, policyErr.Field+"_policy", policyErr.Error())
+		return
+
+	case err != nil:
+		slog.Error("registration failed", "err", err)
+		auth.WriteError(w, http.StatusInternalServerError, "internal", "registration failed")
+		return
+	}
+
+	if user, err = s.provision(user); err != nil {
+		slog.Error("provisioning user failed", "err", err)
+		auth.WriteError(w, http.StatusInternalServerError, "internal", "registration failed")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	if err := json.NewEncoder(w).Encode(user); err != nil {
+		slog.Error("user encoding failed", "err", err)
+	}
+}
 
 func (s *Server) authHandler(w http.ResponseWriter, r *http.Request) {
 	var cred auth.Credentials
-	json.NewDecoder(r.Body).Decode(&cred)
+	if err := json.NewDecoder(r.Body).Decode(&cred); err != nil {
+		auth.WriteError(w, http.StatusBadRequest, "bad_request", "malformed credentials")
+		return
+	}
+	cred.RemoteAddr = remoteHost(r)
 
 	user, err := s.authenticator.AuthenticateCredentials(cred)
 	if err != nil {
-		slog.Error("authentication failed: %s", err)
+		s.loginFailed(w, r, cred.Username, err)
+		return
+	}
+
+	if user, err = s.provision(user); err != nil {
+		slog.Error("provisioning user failed", "err", err)
+		auth.WriteError(w, http.StatusInternalServerError, "internal", "authentication failed")
 		return
 	}
-  
-	token, err := s.authenticator.NewToken(user)
+
+	e := audit.RequestEvent(audit.LoginSucceeded, r)
+	e.User = user.Name
+	s.audit.Log(e)
+
+	grant, err := s.sessions.Start(user)
 	if err != nil {
-		slog.Error("token generation failed: %s", err)
+		slog.Error("session creation failed", "err", err)
+		auth.WriteError(w, http.StatusInternalServerError, "internal", "session creation failed")
 		return
 	}
-  
-	if err := json.NewEncoder(w).Encode(token); err != nil {
-		slog.Error("token encoding failed: %s", err)
+
+	s.writeGrant(w, r, grant, "login")
+}
+
+func (s *Server) loginFailed(w http.ResponseWriter, r *http.Request, username string, err error) {
+	e := audit.RequestEvent(audit.LoginFailed, r)
+	e.User = username
+	defer func() { s.audit.Log(e) }()
+
+	var lockout auth.LockoutError
+	switch {
+	case errors.As(err, &lockout):
+		e.Reason = "too_many_attempts"
+		retry := int(math.Ceil(lockout.RetryAfter.Seconds()))
+		w.Header().Set("Retry-After", strconv.Itoa(retry))
+		auth.WriteError(w, http.StatusTooManyRequests, e.Reason, lockout.Error())
+
+	case errors.Is(err, auth.ErrSecondFactorRequired):
+		e.Reason = "totp_required"
+		auth.WriteError(w, http.StatusUnauthorized, e.Reason, err.Error())
+
+	case errors.Is(err, auth.ErrInvalidCredentials):
+		e.Reason = "invalid_credentials"
+		auth.WriteError(w, http.StatusUnauthorized, e.Reason, auth.ErrInvalidCredentials.Error())
+
+	default:
+		e.Reason = "internal"
+		slog.Error("authentication failed", "err", err)
+		auth.WriteError(w, http.StatusInternalServerError, e.Reason, "authentication failed")
+	}
+}
+
+// remoteHost strips the port from the peer address, so every connection of a
+// host counts against the same lockout.
+func remoteHost(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+
+	return host
+}
+
+type refreshRequest struct {
+	RefreshToken string `json:"refresh_token"`
+}
+
+// refreshToken reads the refresh token from the body, or the cookie set at
+// login when the body has none.
+func refreshToken(r *http.Request) string {
+	var req refreshRequest
+	json.NewDecoder(r.Body).Decode(&req)
+	if req.RefreshToken != "" {
+		return req.RefreshToken
+	}
+
+	if c, err := r.Cookie(auth.RefreshCookieName); err == nil {
+		return c.Value
+	}
+
+	return ""
+}
+
+func (s *Server) refreshHandler(w http.ResponseWriter, r *http.Request) {
+	grant, err := s.sessions.Refresh(refreshToken(r))
+	if err != nil {
+		slog.Debug("refresh failed", "err", err)
+		e := audit.RequestEvent(audit.TokenRejected, r)
+		e.Reason = "invalid_refresh_token"
+		s.audit.Log(e)
+		auth.WriteError(w, http.StatusUnauthorized, "invalid_refresh_token", "invalid refresh token")
 		return
 	}
+
+	s.writeGrant(w, r, grant, "refresh")
 }
 
-func (s *Server) wsHandler(w http.ResponseWriter, r *http.Request) {
-	// TODO change AuthenticateToken to Authorize Middleware
-	var token auth.Token
-	if err := s.authenticator.NewDecoder(r).Decode(&token); err != nil {
-		slog.Error("token decoding failed: %s", err)
+func (s *Server) logoutHandler(w http.ResponseWriter, r *http.Request) {
+	session, err := s.sessions.Revoke(refreshToken(r))
+	if err != nil {
+		slog.Debug("logout failed", "err", err)
+		auth.WriteError(w, http.StatusUnauthorized, "invalid_refresh_token", "invalid refresh token")
 		return
 	}
 
-	user, err := s.authenticator.AuthenticateToken(token)
+	s.clientServer.DisconnectSession(session)
+	e := audit.RequestEvent(audit.Evicted, r)
+	e.Session, e.Reason = session, "logout"
+	s.audit.Log(e)
+
+	http.SetCookie(w, &http.Cookie{Name: auth.TokenCookieName, Path: "/", MaxAge: -1})
+	http.SetCookie(w, &http.Cookie{Name: auth.RefreshCookieName, Path: "/auth", MaxAge: -1})
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// writeGrant hands grant to the client, reason tells the audit log how it
+// was earned.
+func (s *Server) writeGrant(w http.ResponseWriter, r *http.Request, grant auth.Grant, reason string) {
+	s.logGrant(r, grant, reason)
+	http.SetCookie(w, auth.TokenCookie(r, grant.Token))
+	http.SetCookie(w, auth.RefreshCookie(r, grant))
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(grant); err != nil {
+		slog.Error("grant encoding failed", "err", err)
+	}
+}
+
+func (s *Server) logGrant(r *http.Request, grant auth.Grant, reason string) {
+	e := audit.RequestEvent(audit.TokenIssued, r)
+	e.User, e.Session, e.Reason = grant.User.Name, grant.Session, reason
+	s.audit.Log(e)
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		slog.Error("response encoding failed", "err", err)
+	}
+}
+
+// encode frames v the way the channel does for its receivers.
+func encode(v any) []byte {
+	var data bytes.Buffer
+	if err := json.NewEncoder(&data).Encode(v); err != nil {
+		slog.Error("encoding failed", "err", err)
+	}
+
+	return data.Bytes()
+}
+
+func (s *Server) totpEnrollHandler(w http.ResponseWriter, r *http.Request) {
+	user, _ := auth.UserFromContext(r.Context())
+
+	enrollment, err := s.totp.Enroll(user)
+	if errors.Is(err, auth.ErrTOTPEnrolled) {
+		auth.WriteError(w, http.StatusConflict, "totp_enrolled", err.Error())
+		return
+	}
 	if err != nil {
-		slog.Error("authentication failed: %s", err)
+		slog.Error("TOTP enrollment failed", "err", err)
+		auth.WriteError(w, http.StatusInternalServerError, "internal", "TOTP enrollment failed")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(enrollment); err != nil {
+		slog.Error("enrollment encoding failed", "err", err)
+	}
+}
+
+type totpConfirmRequest struct {
+	Code string `json:"code"`
+}
+
+func (s *Server) totpConfirmHandler(w http.ResponseWriter, r *http.Request) {
+	user, _ := auth.UserFromContext(r.Context())
+
+	var req totpConfirmRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		auth.WriteError(w, http.StatusBadRequest, "bad_request", "malformed confirmation")
+		return
+	}
+
+	err := s.totp.Confirm(user, req.Code)
+	switch {
+	case errors.Is(err, auth.ErrTOTPNotEnrolled):
+		auth.WriteError(w, http.StatusNotFound, "totp_not_enrolled", err.Error())
+		return
+
+	case errors.Is(err, auth.ErrTOTPEnrolled):
+		auth.WriteError(w, http.StatusConflict, "totp_enrolled", err.Error())
+		return
+
+	case errors.Is(err, auth.ErrInvalidCredentials):
+		auth.WriteError(w, http.StatusBadRequest, "invalid_code", "invalid TOTP code")
 		return
+
+	case err != nil:
+		slog.Error("TOTP confirmation failed", "err", err)
+		auth.WriteError(w, http.StatusInternalServerError, "internal", "TOTP confirmation failed")
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+type messageRequest struct {
+	ClientID    string              `json:"client_id,omitempty"`
+	ParentID    string              `json:"parent_id,omitempty"`
+	ContentType message.ContentType `json:"content_type,omitempty"`
+	Content     string              `json:"content"`
+	Language    string              `json:"language,omitempty"`
+	Attachment  *message.Attachment `json:"attachment,omitempty"`
+}
+
+// decodeMessageRequest reads the request body, writing the error when it
+// isn't a valid message request.
+func decodeMessageRequest(w http.ResponseWriter, r *http.Request) (messageRequest, bool) {
+	var req messageRequest
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		auth.WriteError(w, http.StatusBadRequest, "bad_request", "malformed message")
+		return req, false
+	}
+
+	err = message.DecodeJSON(body, &req)
+	var contentErr message.ContentError
+	if errors.As(err, &contentErr) {
+		writeContentError(w, err)
+		return req, false
+	}
+	if err != nil {
+		auth.WriteError(w, http.StatusBadRequest, "bad_request", "malformed message")
+		return req, false
+	}
+
+	return req, true
+}
+
+// message is the message req asks to send, cleaned up by in and its content
+// checked for its type.
+func (req messageRequest) message(in message.Inbound) (message.Message, error) {
+	msg, err := in.Clean(message.Message{
+		ClientID:    req.ClientID,
+		ParentID:    req.ParentID,
+		ContentType: req.ContentType,
+		Content:     req.Content,
+		Language:    req.Language,
+		Attachment:  req.Attachment,
+	})
+	if err != nil {
+		return msg, err
+	}
+
+	return msg.CheckContent()
+}
+
+// writeContentError writes why the content of a message was refused.
+func writeContentError(w http.ResponseWriter, err error) {
+	var contentErr message.ContentError
+	if errors.As(err, &contentErr) {
+		auth.WriteError(w, http.StatusBadRequest, contentErr.Code(), contentErr.Error())
+		return
+	}
+
+	auth.WriteError(w, http.StatusBadRequest, "bad_request", err.Error())
+}
+
+func (s *Server) messageHandler(w http.ResponseWriter, r *http.Request) {
+	user, _ := auth.UserFromContext(r.Context())
+
+	req, ok := decodeMessageRequest(w, r)
+	if !ok {
+		return
+	}
+
+	if len(req.ClientID) > message.MaxClientIDLength {
+		auth.WriteError(w, http.StatusBadRequest, "client_id_invalid", fmt.Sprintf("client_id must be at most %d bytes", message.MaxClientIDLength))
+		return
+	}
+
+	msg, err := req.message(s.inbound)
+	if err != nil {
+		writeContentError(w, err)
+		return
+	}
+
+	msg = msg.From(user, s.profiles).WithMentions(s.profiles)
+	result, ok := s.post(w, r, msg)
+	if !ok {
+		return
+	}
+
+	// Resending a ClientID answers with the message the channel already had.
+	status := http.StatusCreated
+	if result.Duplicate {
+		status = http.StatusOK
+	}
+	writeJSON(w, status, result.Message)
+}
+
+// presenceHandler lists who is connected to the channel.
+func (s *Server) presenceHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(s.presence.Present()); err != nil {
+		slog.Error("presence encoding failed", "err", err)
+	}
+}
+
+func (s *Server) wsHandler(w http.ResponseWriter, r *http.Request) {
+	user, _ := auth.UserFromContext(r.Context())
+	token, _ := auth.TokenFromContext(r.Context())
+	session, _ := s.sessions.SessionOf(token)
+
+	opts := []client.Option{client.Profiles(s.profiles), client.Inbound(s.inbound)}
+	if key, ok, _ := s.apiKeys.Lookup(token); ok && !key.Allows(auth.ScopePost) {
+		opts = append(opts, client.ReadOnly())
 	}
-	// ODOT
 
 	conn, err := s.connectionUpgrader.Upgrade(w, r)
 	if err != nil {
-		slog.Error("connection upgrade failed: %s", err)
+		slog.Error("connection upgrade failed", "err", err)
 		return
 	}
 
-	s.clientServer.ServeClient(conn, user, s.channel)
+	s.presence.Connect(user, conn)
+	opts = append(opts, client.OnActivity(func() { s.presence.Activity(user, conn) }))
+	c := s.clientServer.ServeClient(conn, user, s.channel, session, opts...)
+
+	e := audit.RequestEvent(audit.Upgraded, r)
+	e.User, e.Session = user.Name, session
+	s.audit.Log(e)
+	go func() {
+		<-c.Done()
+		s.presence.Disconnect(user, conn)
+		e.Kind, e.Time = audit.Disconnected, time.Time{}
+		s.audit.Log(e)
+	}()
+}
+
+// evicted audits receivers the channel dropped.
+func (s *Server) evicted(rcv channel.Receiver, reason string) {
+	e := audit.Event{Kind: audit.Evicted, Reason: reason}
+	if c, ok := rcv.(*client.Client); ok {
+		e.User, e.Session = c.Who(), c.Session()
+	}
+	s.audit.Log(e)
 }
 
 func main() {
-	l := New()
-  p := "7331"
-  if err := l.Serve(p); err != nil {
-    slog.Error("failed to start server", err)
-  }
+	config, err := ConfigFromEnv()
+	if err != nil {
+		slog.Error("invalid configuration", "err", err)
+		return
+	}
+
+	l, err := NewWithConfig(config)
+	if err != nil {
+		slog.Error("failed to create server", "err", err)
+		return
+	}
+	defer l.Close()
+
+	if err := l.Serve(config.Port); err != nil {
+		slog.Error("failed to start server", "err", err)
+	}
 }
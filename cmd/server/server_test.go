@@ -2,22 +2,35 @@ package main
 
 import (
 	"bytes"
+	"encoding/base32"
+	"encoding/base64"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
+	"net"
 	"net/http"
 	"net/http/httptest"
+	neturl "net/url"
+	"os"
+	"path/filepath"
 	"reflect"
+	"slices"
 	"strings"
 	"sync"
 	"testing"
+	"time"
 
 	"github.com/gorilla/websocket"
+	"golang.org/x/crypto/bcrypt"
 
+	"github.com/DanyPops/logues/domain/audit"
 	"github.com/DanyPops/logues/domain/auth"
+	"github.com/DanyPops/logues/domain/channel"
 	"github.com/DanyPops/logues/domain/connection"
+	"github.com/DanyPops/logues/domain/contact"
 	"github.com/DanyPops/logues/domain/message"
+	"github.com/DanyPops/logues/domain/presence"
 	"github.com/DanyPops/logues/domain/user"
 )
 
@@ -49,19 +62,40 @@ func connect(url string, creds auth.Credentials) (*loguesClient, error) {
 	return c, nil
 }
 
-func getOTP(url string, creds auth.Credentials) (string, error) {
+func postJSON(url string, v any) (*http.Response, error) {
 	body := new(bytes.Buffer)
-	json.NewEncoder(body).Encode(creds)
-	req, err := http.NewRequest("POST", url+"/auth", body)
+	json.NewEncoder(body).Encode(v)
+	req, err := http.NewRequest("POST", url, body)
 	if err != nil {
-		return "", err
+		return nil, err
+	}
+
+	return http.DefaultClient.Do(req)
+}
+
+func register(url string, creds auth.Credentials) error {
+	resp, err := postJSON(url+"/register", creds)
+	if err != nil {
+		return err
 	}
 
-	resp, err := http.DefaultClient.Do(req)
+	if resp.StatusCode != http.StatusCreated {
+		return fmt.Errorf("registration failed with status %d", resp.StatusCode)
+	}
+
+	return nil
+}
+
+func getOTP(url string, creds auth.Credentials) (string, error) {
+	resp, err := postJSON(url+"/auth", creds)
 	if err != nil {
 		return "", err
 	}
 
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("authentication failed with status %d", resp.StatusCode)
+	}
+
 	var otp auth.Token
 	json.NewDecoder(resp.Body).Decode(&otp)
 
@@ -71,9 +105,9 @@ func getOTP(url string, creds auth.Credentials) (string, error) {
 func (c *loguesClient) Start() {
 	for {
 		// TODO - MSG R
-		msg := message.Message{}
+		var raw json.RawMessage
 
-		if err := json.NewDecoder(c.connection).Decode(&msg); err != nil {
+		if err := json.NewDecoder(c.connection).Decode(&raw); err != nil {
 			if errors.Is(err, io.EOF) {
 				continue
 			}
@@ -85,6 +119,17 @@ func (c *loguesClient) Start() {
 			break
 		}
 
+		// Events like presence changes aren't messages.
+		var event struct {
+			Type string `json:"type"`
+		}
+		json.Unmarshal(raw, &event)
+		if event.Type != "" {
+			continue
+		}
+
+		msg := message.Message{}
+		json.Unmarshal(raw, &msg)
 		c.msgLog = append(c.msgLog, msg)
 		// ODOT
 		c.wg.Done()
@@ -95,6 +140,18 @@ func (c *loguesClient) SendMessage(content string) error {
 	return json.NewEncoder(c.connection).Encode(message.Message{Content: content})
 }
 
+// unstamped checks that the server stamped msg and clears the stamps for
+// comparisons.
+func unstamped(t *testing.T, msg message.Message) message.Message {
+	t.Helper()
+	if msg.ID == "" || msg.Seq == 0 || msg.Time.IsZero() {
+		t.Errorf("got unstamped message %+v", msg)
+	}
+
+	msg.ID, msg.Seq, msg.Time = "", 0, time.Time{}
+	return msg
+}
+
 func (c *loguesClient) LastMessage() message.Message {
 	l := len(c.msgLog)
 	if l == 0 {
@@ -103,8 +160,44 @@ func (c *loguesClient) LastMessage() message.Message {
 	return c.msgLog[l-1]
 }
 
+func newTestServer(t *testing.T) *Server {
+	return newTestServerWithConfig(t, DefaultConfig())
+}
+
+// newSignedTestServer serves a server whose tokens any instance sharing its
+// key accepts, configure adjusts its config first.
+func newSignedTestServer(t *testing.T, configure ...func(*Config)) *httptest.Server {
+	config := DefaultConfig()
+	config.TokenAuth = TokenAuthSigned
+	config.TokenKeys = []string{"k1:hmac:" + base64.StdEncoding.EncodeToString(bytes.Repeat([]byte("k"), 32))}
+	for _, f := range configure {
+		f(&config)
+	}
+
+	l := newTestServerWithConfig(t, config)
+	srv := httptest.NewServer(l)
+	t.Cleanup(func() {
+		srv.Close()
+		l.Close()
+	})
+
+	return srv
+}
+
+func newTestServerWithConfig(t *testing.T, config Config) *Server {
+	config.PasswordCost = bcrypt.MinCost
+	l, err := NewWithConfig(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return l
+}
+
 func TestServer(t *testing.T) {
-	srv := httptest.NewServer(New())
+	l := newTestServer(t)
+	defer l.Close()
+	srv := httptest.NewServer(l)
 	defer srv.Close()
 
 	t.Run("verify static pages", func(t *testing.T) {
@@ -131,12 +224,17 @@ func TestServer(t *testing.T) {
 		name := "dpop"
 		creds := auth.Credentials{
 			Username: name,
-			Password: "",
+			Password: "hunter22",
+		}
+		if err := register(srv.URL, creds); err != nil {
+			t.Fatal(err)
 		}
+
 		content := "Hello!"
 		want := message.Message{
-			Sender:  user.User{Name: name},
-			Content: content,
+			Sender:      message.Sender{Name: name},
+			ContentType: message.PlainContent,
+			Content:     content,
 		}
 		clis := make([]*loguesClient, 1000)
 		for i := range len(clis) {
@@ -152,10 +250,1795 @@ func TestServer(t *testing.T) {
 
 		for _, c := range clis {
 			c.wg.Wait()
-			got := c.LastMessage()
+			got := unstamped(t, c.LastMessage())
+			if got.SenderID == "" {
+				t.Errorf("got message without sender id")
+			}
+			got.SenderID = ""
 			if !reflect.DeepEqual(got, want) {
-				t.Errorf("got %s, want %s", got, want)
+				t.Errorf("got %v, want %v", got, want)
+			}
+		}
+	})
+}
+
+func TestRegistration(t *testing.T) {
+	l := newTestServer(t)
+	defer l.Close()
+	srv := httptest.NewServer(l)
+	defer srv.Close()
+
+	creds := auth.Credentials{Username: "dpop", Password: "hunter22"}
+	if err := register(srv.URL, creds); err != nil {
+		t.Fatal(err)
+	}
+
+	cases := map[string]struct {
+		path   string
+		creds  auth.Credentials
+		status int
+		code   string
+	}{
+		"username taken":  {"/register", creds, http.StatusConflict, "username_taken"},
+		"short password":  {"/register", auth.Credentials{Username: "other", Password: "short"}, http.StatusBadRequest, "password_policy"},
+		"bad username":    {"/register", auth.Credentials{Username: "<b>", Password: "hunter22"}, http.StatusBadRequest, "username_policy"},
+		"wrong password":  {"/auth", auth.Credentials{Username: "dpop", Password: "hunter23"}, http.StatusUnauthorized, "invalid_credentials"},
+		"unknown account": {"/auth", auth.Credentials{Username: "nobody", Password: "hunter22"}, http.StatusUnauthorized, "invalid_credentials"},
+	}
+
+	for name, c := range cases {
+		t.Run(name, func(t *testing.T) {
+			resp, err := postJSON(srv.URL+c.path, c.creds)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if resp.StatusCode != c.status {
+				t.Errorf("got status code %d, want %d", resp.StatusCode, c.status)
+			}
+
+			var body auth.ErrorResponse
+			json.NewDecoder(resp.Body).Decode(&body)
+			if body.Code != c.code {
+				t.Errorf("got code %s, want %s", body.Code, c.code)
+			}
+		})
+	}
+}
+
+func TestSignedTokenServer(t *testing.T) {
+	t.Run("token issued by one instance is accepted by another", func(t *testing.T) {
+		// Both instances need the account, as they don't share a user store.
+		creds := auth.Credentials{Username: "dpop", Password: "hunter22"}
+		urls := make([]string, 2)
+		for i := range urls {
+			srv := newSignedTestServer(t)
+			urls[i] = srv.URL
+
+			if err := register(srv.URL, creds); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		token, err := getOTP(urls[0], creds)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		conn, err := connection.Dial("ws" + strings.TrimPrefix(urls[1], "http") + "/ws?otp=" + token)
+		if err != nil {
+			t.Fatal(err)
+		}
+		conn.Close()
+	})
+
+	t.Run("default tokens last for more than one request", func(t *testing.T) {
+		l := newTestServer(t)
+		defer l.Close()
+		srv := httptest.NewServer(l)
+		defer srv.Close()
+
+		tokens, _ := registerUsers(t, srv.URL, "dpop")
+		for i := range 2 {
+			resp, err := authorizedRequest("GET", srv.URL+"/users/me", tokens["dpop"], nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			resp.Body.Close()
+			if resp.StatusCode != http.StatusOK {
+				t.Errorf("request %d: got status code %d, want %d", i, resp.StatusCode, http.StatusOK)
+			}
+		}
+	})
+
+	t.Run("misconfiguration is refused", func(t *testing.T) {
+		for name, c := range map[string]Config{
+			"bad key":      {TokenAuth: TokenAuthSigned, TokenKeys: []string{"k1:hmac:short"}},
+			"unknown auth": {TokenAuth: "magic"},
+		} {
+			if _, err := NewWithConfig(c); err == nil {
+				t.Errorf("%s: expected error", name)
+			}
+		}
+	})
+}
+
+func TestTokenSources(t *testing.T) {
+	l := newTestServer(t)
+	defer l.Close()
+	srv := httptest.NewServer(l)
+	defer srv.Close()
+
+	creds := auth.Credentials{Username: "dpop", Password: "hunter22"}
+	if err := register(srv.URL, creds); err != nil {
+		t.Fatal(err)
+	}
+	wsURL := "ws" + strings.TrimPrefix(srv.URL, "http") + "/ws"
+
+	cases := map[string]func(token string) (websocket.Dialer, http.Header){
+		"authorization header": func(token string) (websocket.Dialer, http.Header) {
+			return websocket.Dialer{}, http.Header{"Authorization": {"Bearer " + token}}
+		},
+		"subprotocol": func(token string) (websocket.Dialer, http.Header) {
+			return websocket.Dialer{Subprotocols: []string{auth.Subprotocol, auth.TokenSubprotocolPrefix + token}}, nil
+		},
+		"cookie": func(token string) (websocket.Dialer, http.Header) {
+			return websocket.Dialer{}, http.Header{"Cookie": {auth.TokenCookieName + "=" + token}}
+		},
+	}
+
+	for name, source := range cases {
+		t.Run(name, func(t *testing.T) {
+			token, err := getOTP(srv.URL, creds)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			dialer, header := source(token)
+			ws, _, err := dialer.Dial(wsURL, header)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer ws.Close()
+
+			if len(dialer.Subprotocols) > 0 && ws.Subprotocol() != auth.Subprotocol {
+				t.Errorf("got subprotocol %q, want %q", ws.Subprotocol(), auth.Subprotocol)
+			}
+		})
+	}
+
+	t.Run("login sets an HttpOnly cookie", func(t *testing.T) {
+		resp, err := postJSON(srv.URL+"/auth", creds)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var cookie *http.Cookie
+		for _, c := range resp.Cookies() {
+			if c.Name == auth.TokenCookieName {
+				cookie = c
+			}
+		}
+
+		if cookie == nil || !cookie.HttpOnly {
+			t.Errorf("got cookie %v, want an HttpOnly %s cookie", cookie, auth.TokenCookieName)
+		}
+	})
+}
+
+func TestProtectedRoutes(t *testing.T) {
+	l := newTestServer(t)
+	defer l.Close()
+	srv := httptest.NewServer(l)
+	defer srv.Close()
+
+	creds := auth.Credentials{Username: "dpop", Password: "hunter22"}
+	if err := register(srv.URL, creds); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, path := range []string{"/ws", "/users/me"} {
+		t.Run(path+" refuses anonymous requests", func(t *testing.T) {
+			resp, err := http.Get(srv.URL + path)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if resp.StatusCode != http.StatusUnauthorized {
+				t.Errorf("got status code %d, want %d", resp.StatusCode, http.StatusUnauthorized)
+			}
+
+			var body auth.ErrorResponse
+			if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+				t.Fatal(err)
+			}
+
+			if body.Code != "missing_token" {
+				t.Errorf("got code %s, want missing_token", body.Code)
+			}
+		})
+	}
+
+	t.Run("/users/me returns the authenticated user", func(t *testing.T) {
+		token, err := getOTP(srv.URL, creds)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		req, _ := http.NewRequest("GET", srv.URL+"/users/me", nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var got user.Profile
+		json.NewDecoder(resp.Body).Decode(&got)
+		if got.Name != creds.Username || got.ID == "" {
+			t.Errorf("got %v, want %s with an id", got, creds.Username)
+		}
+	})
+}
+
+func getGrant(url string, creds auth.Credentials) (auth.Grant, error) {
+	var grant auth.Grant
+	resp, err := postJSON(url+"/auth", creds)
+	if err != nil {
+		return grant, err
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return grant, fmt.Errorf("authentication failed with status %d", resp.StatusCode)
+	}
+
+	err = json.NewDecoder(resp.Body).Decode(&grant)
+	return grant, err
+}
+
+func TestSessions(t *testing.T) {
+	l := newTestServer(t)
+	defer l.Close()
+	srv := httptest.NewServer(l)
+	defer srv.Close()
+
+	creds := auth.Credentials{Username: "dpop", Password: "hunter22"}
+	if err := register(srv.URL, creds); err != nil {
+		t.Fatal(err)
+	}
+	wsURL := "ws" + strings.TrimPrefix(srv.URL, "http") + "/ws?otp="
+
+	t.Run("refresh reconnects & logout disconnects", func(t *testing.T) {
+		grant, err := getGrant(srv.URL, creds)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		first, _, err := websocket.DefaultDialer.Dial(wsURL+grant.Key, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer first.Close()
+
+		resp, err := postJSON(srv.URL+"/auth/refresh", map[string]string{"refresh_token": grant.RefreshToken})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var refreshed auth.Grant
+		json.NewDecoder(resp.Body).Decode(&refreshed)
+		second, _, err := websocket.DefaultDialer.Dial(wsURL+refreshed.Key, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer second.Close()
+
+		resp, err = postJSON(srv.URL+"/auth/logout", map[string]string{"refresh_token": refreshed.RefreshToken})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if resp.StatusCode != http.StatusNoContent {
+			t.Errorf("got status code %d, want %d", resp.StatusCode, http.StatusNoContent)
+		}
+
+		for _, ws := range []*websocket.Conn{first, second} {
+			ws.SetReadDeadline(time.Now().Add(time.Second))
+			// Skip the presence events sent before the logout.
+			var err error
+			for err == nil {
+				_, _, err = ws.ReadMessage()
+			}
+			if !websocket.IsUnexpectedCloseError(err) {
+				t.Errorf("got %v, want a closed connection", err)
 			}
 		}
+
+		resp, err = postJSON(srv.URL+"/auth/refresh", map[string]string{"refresh_token": refreshed.RefreshToken})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if resp.StatusCode != http.StatusUnauthorized {
+			t.Errorf("got status code %d, want %d", resp.StatusCode, http.StatusUnauthorized)
+		}
 	})
 }
+
+func TestLockout(t *testing.T) {
+	config := DefaultConfig()
+	config.Lockout.UserThreshold = 2
+	l := newTestServerWithConfig(t, config)
+	defer l.Close()
+	srv := httptest.NewServer(l)
+	defer srv.Close()
+
+	creds := auth.Credentials{Username: "dpop", Password: "hunter22"}
+	if err := register(srv.URL, creds); err != nil {
+		t.Fatal(err)
+	}
+
+	wrong := auth.Credentials{Username: "dpop", Password: "hunter23"}
+	for range config.Lockout.UserThreshold {
+		resp, err := postJSON(srv.URL+"/auth", wrong)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if resp.StatusCode != http.StatusUnauthorized {
+			t.Fatalf("got status code %d, want %d", resp.StatusCode, http.StatusUnauthorized)
+		}
+	}
+
+	resp, err := postJSON(srv.URL+"/auth", creds)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if resp.StatusCode != http.StatusTooManyRequests {
+		t.Errorf("got status code %d, want %d", resp.StatusCode, http.StatusTooManyRequests)
+	}
+
+	if retry := resp.Header.Get("Retry-After"); retry != "1" {
+		t.Errorf("got Retry-After %q, want %q", retry, "1")
+	}
+}
+
+func authorizedPost(url, token string, v any) (*http.Response, error) {
+	return authorizedRequest("POST", url, token, v)
+}
+
+func authorizedRequest(method, url, token string, v any) (*http.Response, error) {
+	body := new(bytes.Buffer)
+	if v != nil {
+		json.NewEncoder(body).Encode(v)
+	}
+	req, err := http.NewRequest(method, url, body)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+
+	return http.DefaultClient.Do(req)
+}
+
+func TestTOTP(t *testing.T) {
+	l := newTestServer(t)
+	defer l.Close()
+	srv := httptest.NewServer(l)
+	defer srv.Close()
+
+	creds := auth.Credentials{Username: "dpop", Password: "hunter22"}
+	if err := register(srv.URL, creds); err != nil {
+		t.Fatal(err)
+	}
+
+	token, err := getOTP(srv.URL, creds)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	resp, err := authorizedPost(srv.URL+"/auth/totp", token, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var enrollment auth.TOTPEnrollment
+	json.NewDecoder(resp.Body).Decode(&enrollment)
+	secret, err := base32.StdEncoding.WithPadding(base32.NoPadding).DecodeString(enrollment.Secret)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	config := auth.NewTOTPConfig("logues")
+	code, _ := auth.TOTP(secret, time.Now().Add(-config.Period), config)
+	token, _ = getOTP(srv.URL, creds)
+	resp, err = authorizedPost(srv.URL+"/auth/totp/confirm", token, map[string]string{"code": code})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if resp.StatusCode != http.StatusNoContent {
+		t.Fatalf("got status code %d, want %d", resp.StatusCode, http.StatusNoContent)
+	}
+
+	resp, err = postJSON(srv.URL+"/auth", creds)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var body auth.ErrorResponse
+	json.NewDecoder(resp.Body).Decode(&body)
+	if resp.StatusCode != http.StatusUnauthorized || body.Code != "totp_required" {
+		t.Errorf("got %d %s, want %d totp_required", resp.StatusCode, body.Code, http.StatusUnauthorized)
+	}
+
+	creds.Code, _ = auth.TOTP(secret, time.Now(), config)
+	if _, err := getOTP(srv.URL, creds); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestAPIKeys(t *testing.T) {
+	config := DefaultConfig()
+	config.Admins = []string{"admin"}
+	l := newTestServerWithConfig(t, config)
+	defer l.Close()
+	srv := httptest.NewServer(l)
+	defer srv.Close()
+
+	admin := auth.Credentials{Username: "admin", Password: "hunter22"}
+	creds := auth.Credentials{Username: "dpop", Password: "hunter22"}
+	for _, c := range []auth.Credentials{admin, creds} {
+		if err := register(srv.URL, c); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	createKey := func(t *testing.T, creds auth.Credentials, scopes ...auth.Scope) (*http.Response, string) {
+		token, err := getOTP(srv.URL, creds)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		resp, err := authorizedPost(srv.URL+"/apikeys", token, apiKeyRequest{Name: "deploys", Service: "ci-bot", Scopes: scopes})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var key apiKeyResponse
+		json.NewDecoder(resp.Body).Decode(&key)
+		return resp, key.Secret
+	}
+
+	t.Run("only admins create keys", func(t *testing.T) {
+		resp, _ := createKey(t, creds, auth.ScopePost)
+		if resp.StatusCode != http.StatusForbidden {
+			t.Errorf("got status code %d, want %d", resp.StatusCode, http.StatusForbidden)
+		}
+	})
+
+	t.Run("post key sends messages over HTTP", func(t *testing.T) {
+		resp, secret := createKey(t, admin, auth.ScopePost)
+		if resp.StatusCode != http.StatusCreated {
+			t.Fatalf("got status code %d, want %d", resp.StatusCode, http.StatusCreated)
+		}
+
+		c, err := connect(srv.URL, creds)
+		if err != nil {
+			t.Fatal(err)
+		}
+		c.wg.Add(1)
+
+		resp, err = authorizedPost(srv.URL+"/messages", secret, messageRequest{Content: "deployed"})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if resp.StatusCode != http.StatusCreated {
+			t.Fatalf("got status code %d, want %d", resp.StatusCode, http.StatusCreated)
+		}
+
+		c.wg.Wait()
+		want := message.Message{Sender: message.Sender{Name: "bot:ci-bot"}, ContentType: message.PlainContent, Content: "deployed"}
+		got := unstamped(t, c.LastMessage())
+		if got.SenderID == "" {
+			t.Errorf("got message without sender id")
+		}
+		got.SenderID = ""
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("got %v, want %v", got, want)
+		}
+	})
+
+	t.Run("read key connects but can't post", func(t *testing.T) {
+		_, secret := createKey(t, admin, auth.ScopeRead)
+
+		ws, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(srv.URL, "http")+"/ws", http.Header{"Authorization": {"Bearer " + secret}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		ws.Close()
+
+		for _, route := range []struct{ method, path string }{
+			{"POST", "/messages"},
+			{"PATCH", "/users/me"},
+			{"PUT", "/users/me/blocks/someone"},
+			{"DELETE", "/users/me/mutes/someone"},
+			{"POST", "/contacts/requests"},
+			{"POST", "/contacts/requests/someone/accept"},
+			{"POST", "/auth/totp"},
+		} {
+			resp, err := authorizedRequest(route.method, srv.URL+route.path, secret, messageRequest{Content: "deployed"})
+			if err != nil {
+				t.Fatal(err)
+			}
+			resp.Body.Close()
+
+			if resp.StatusCode != http.StatusForbidden {
+				t.Errorf("%s %s: got status code %d, want %d", route.method, route.path, resp.StatusCode, http.StatusForbidden)
+			}
+		}
+	})
+
+	t.Run("revoked key is refused", func(t *testing.T) {
+		_, secret := createKey(t, admin, auth.ScopeRead, auth.ScopeAdmin)
+
+		req, _ := http.NewRequest("GET", srv.URL+"/apikeys", nil)
+		req.Header.Set("Authorization", "Bearer "+secret)
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var keys []auth.APIKey
+		json.NewDecoder(resp.Body).Decode(&keys)
+		if len(keys) != 3 {
+			t.Fatalf("got %d keys, want 3", len(keys))
+		}
+
+		for _, key := range keys {
+			req, _ := http.NewRequest("DELETE", srv.URL+"/apikeys/"+key.ID, nil)
+			req.Header.Set("Authorization", "Bearer "+secret)
+			if _, err := http.DefaultClient.Do(req); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		resp, err = authorizedPost(srv.URL+"/messages", secret, messageRequest{Content: "deployed"})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if resp.StatusCode != http.StatusUnauthorized {
+			t.Errorf("got status code %d, want %d", resp.StatusCode, http.StatusUnauthorized)
+		}
+	})
+}
+
+func TestHtpasswdServer(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "htpasswd")
+	line := "alice:$5$saltstring$5B8vYYiY.CVt1RlTTf8KbXBH3hsxY/GNooZaBBGWEc5\n"
+	if err := os.WriteFile(path, []byte(line), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	config := DefaultConfig()
+	config.HtpasswdFile = path
+	l := newTestServerWithConfig(t, config)
+	defer l.Close()
+	srv := httptest.NewServer(l)
+	defer srv.Close()
+
+	if _, err := getOTP(srv.URL, auth.Credentials{Username: "alice", Password: "Hello world!"}); err != nil {
+		t.Errorf("Failed to log in from the file: %s", err)
+	}
+
+	resp, err := postJSON(srv.URL+"/register", auth.Credentials{Username: "mallory", Password: "hunter22"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if resp.StatusCode == http.StatusCreated {
+		t.Error("Registered a user next to the static file")
+	}
+}
+
+func TestAuditLog(t *testing.T) {
+	sink := audit.NewMemorySink()
+	config := DefaultConfig()
+	config.AuditSinks = []audit.Sink{sink}
+	l := newTestServerWithConfig(t, config)
+	defer l.Close()
+	srv := httptest.NewServer(l)
+	defer srv.Close()
+
+	creds := auth.Credentials{Username: "dpop", Password: "hunter22"}
+	if err := register(srv.URL, creds); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := getGrant(srv.URL, auth.Credentials{Username: "dpop", Password: "hunter23"}); err == nil {
+		t.Fatal("Logged in with a wrong password")
+	}
+
+	grant, err := getGrant(srv.URL, creds)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	header := http.Header{"User-Agent": {"audit-test/1.0"}}
+	ws, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(srv.URL, "http")+"/ws?otp="+grant.Key, header)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	resp, err := postJSON(srv.URL+"/auth/logout", map[string]string{"refresh_token": grant.RefreshToken})
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	ws.SetReadDeadline(time.Now().Add(time.Second))
+	ws.ReadMessage()
+	ws.Close()
+
+	want := []audit.Kind{
+		audit.LoginFailed,
+		audit.LoginSucceeded,
+		audit.TokenIssued,
+		audit.TokenConsumed,
+		audit.Upgraded,
+		audit.Evicted,
+		audit.Disconnected,
+	}
+
+	deadline := time.Now().Add(time.Second)
+	for len(sink.Events()) < len(want) && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	events := sink.Events()
+	var got []audit.Kind
+	for _, e := range events {
+		got = append(got, e.Kind)
+	}
+
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("got events %v, want %v", got, want)
+	}
+
+	if e := events[0]; e.User != "dpop" || e.Reason != "invalid_credentials" || e.RemoteAddr != "127.0.0.1" {
+		t.Errorf("unexpected login failure %+v", e)
+	}
+
+	if e := events[4]; e.User != "dpop" || e.Session == "" || e.UserAgent != "audit-test/1.0" {
+		t.Errorf("unexpected upgrade %+v", e)
+	}
+
+	t.Run("unresponsive clients are audited", func(t *testing.T) {
+		conn, other := net.Pipe()
+		defer other.Close()
+		c := l.clientServer.ServeClient(conn, user.User{ID: "u1", Name: "dpop"}, l.channel, "session-1")
+		defer c.Stop()
+
+		l.evicted(c, channel.EvictUnresponsive)
+		evicted := sink.Events(audit.Evicted)
+		if e := evicted[len(evicted)-1]; e.User != "dpop" || e.Session != "session-1" || e.Reason != channel.EvictUnresponsive {
+			t.Errorf("unexpected eviction %+v", e)
+		}
+	})
+}
+
+func TestProfiles(t *testing.T) {
+	srv := newSignedTestServer(t)
+
+	creds := auth.Credentials{Username: "dpop", Password: "hunter22"}
+	if err := register(srv.URL, creds); err != nil {
+		t.Fatal(err)
+	}
+	token, err := getOTP(srv.URL, creds)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	resp, err := authorizedRequest("PATCH", srv.URL+"/users/me", token, map[string]string{
+		"display_name": "Dany P.",
+		"timezone":     "Asia/Jerusalem",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var me user.Profile
+	json.NewDecoder(resp.Body).Decode(&me)
+	if resp.StatusCode != http.StatusOK || me.DisplayName != "Dany P." || me.Timezone != "Asia/Jerusalem" || me.Created.IsZero() {
+		t.Fatalf("got status code %d and profile %+v", resp.StatusCode, me)
+	}
+
+	t.Run("profiles are found by id", func(t *testing.T) {
+		resp, err := authorizedRequest("GET", srv.URL+"/users/"+me.ID, token, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var got user.Profile
+		json.NewDecoder(resp.Body).Decode(&got)
+		if got.ID != me.ID || got.DisplayName != me.DisplayName {
+			t.Errorf("got %+v, want %+v", got, me)
+		}
+
+		resp, err = authorizedRequest("GET", srv.URL+"/users/nobody", token, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if resp.StatusCode != http.StatusNotFound {
+			t.Errorf("got status code %d, want %d", resp.StatusCode, http.StatusNotFound)
+		}
+	})
+
+	t.Run("invalid fields are refused", func(t *testing.T) {
+		for field, value := range map[string]string{
+			"timezone":   "Mars/Olympus",
+			"avatar_url": "javascript:alert(1)",
+		} {
+			resp, err := authorizedRequest("PATCH", srv.URL+"/users/me", token, map[string]string{field: value})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var body auth.ErrorResponse
+			json.NewDecoder(resp.Body).Decode(&body)
+			if resp.StatusCode != http.StatusBadRequest || body.Code != field+"_invalid" {
+				t.Errorf("got status code %d and code %s for %s", resp.StatusCode, body.Code, field)
+			}
+		}
+	})
+
+	t.Run("profile text is cleaned up like messages", func(t *testing.T) {
+		resp, err := authorizedRequest("PATCH", srv.URL+"/users/me", token, map[string]string{"bio": "\u202eevil\u0007 cafe\u0301"})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var got user.Profile
+		json.NewDecoder(resp.Body).Decode(&got)
+		if got.Bio != "evil caf\u00e9" {
+			t.Errorf("got bio %q, want it cleaned up", got.Bio)
+		}
+	})
+
+	t.Run("users are found by name & display name", func(t *testing.T) {
+		for _, name := range []string{"danielle", "adan"} {
+			if err := register(srv.URL, auth.Credentials{Username: name, Password: "hunter22"}); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		var names []string
+		next := ""
+		for range 4 {
+			resp, err := authorizedRequest("GET", srv.URL+"/users?q=dan&limit=2&cursor="+next, token, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var page user.SearchPage
+			json.NewDecoder(resp.Body).Decode(&page)
+			for _, p := range page.Profiles {
+				names = append(names, p.Name)
+			}
+			if next = page.Next; next == "" {
+				break
+			}
+		}
+
+		if want := []string{"danielle", "dpop", "adan"}; !reflect.DeepEqual(names, want) {
+			t.Errorf("got %v, want %v", names, want)
+		}
+
+		resp, err := authorizedRequest("GET", srv.URL+"/users?q=dan&cursor=bogus", token, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var body auth.ErrorResponse
+		json.NewDecoder(resp.Body).Decode(&body)
+		if resp.StatusCode != http.StatusBadRequest || body.Code != "cursor_invalid" {
+			t.Errorf("got status code %d and code %s", resp.StatusCode, body.Code)
+		}
+	})
+
+	t.Run("messages carry the sender id & display name", func(t *testing.T) {
+		otp, err := getOTP(srv.URL, creds)
+		if err != nil {
+			t.Fatal(err)
+		}
+		c, err := connect(srv.URL, creds)
+		if err != nil {
+			t.Fatal(err)
+		}
+		c.wg.Add(1)
+
+		// Give the client time to join the channel.
+		time.Sleep(50 * time.Millisecond)
+		resp, err := authorizedPost(srv.URL+"/messages", otp, map[string]string{"content": "hi"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+
+		c.wg.Wait()
+		want := message.Message{SenderID: me.ID, Sender: message.Sender{Name: "dpop", DisplayName: "Dany P."}, ContentType: message.PlainContent, Content: "hi"}
+		if got := unstamped(t, c.LastMessage()); !reflect.DeepEqual(got, want) {
+			t.Errorf("got %+v, want %+v", got, want)
+		}
+	})
+}
+
+func TestPresence(t *testing.T) {
+	srv := newSignedTestServer(t)
+
+	watcher := auth.Credentials{Username: "watcher", Password: "hunter22"}
+	dpop := auth.Credentials{Username: "dpop", Password: "hunter22"}
+	for _, creds := range []auth.Credentials{watcher, dpop} {
+		if err := register(srv.URL, creds); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	wsURL := "ws" + strings.TrimPrefix(srv.URL, "http") + "/ws?otp="
+	dial := func(creds auth.Credentials) (*websocket.Conn, string) {
+		token, err := getOTP(srv.URL, creds)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		ws, _, err := websocket.DefaultDialer.Dial(wsURL+token, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return ws, token
+	}
+
+	// nextPresence skips the watcher's own events, which may arrive once it
+	// joined the channel.
+	nextPresence := func(ws *websocket.Conn) presence.Event {
+		ws.SetReadDeadline(time.Now().Add(time.Second))
+		for {
+			var e presence.Event
+			if err := ws.ReadJSON(&e); err != nil {
+				t.Fatal(err)
+			}
+			if e.Type == presence.EventType && e.User.Name != watcher.Username {
+				return e
+			}
+		}
+	}
+
+	watching, token := dial(watcher)
+	defer watching.Close()
+	// Wait for the watcher to join the channel.
+	time.Sleep(50 * time.Millisecond)
+
+	laptop, _ := dial(dpop)
+	phone, _ := dial(dpop)
+	if e := nextPresence(watching); e.User.Name != "dpop" || e.Status != presence.Online {
+		t.Errorf("got %+v, want dpop online", e)
+	}
+
+	resp, err := authorizedRequest("GET", srv.URL+"/presence", token, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var present []presence.Presence
+	json.NewDecoder(resp.Body).Decode(&present)
+	if len(present) != 2 || present[0].User.Name != "dpop" || present[1].User.Name != "watcher" {
+		t.Errorf("got present %+v, want dpop & watcher", present)
+	}
+
+	laptop.Close()
+	phone.Close()
+	if e := nextPresence(watching); e.User.Name != "dpop" || e.Status != presence.Offline {
+		t.Errorf("got %+v, want dpop offline", e)
+	}
+}
+
+// registerUsers registers names and returns their tokens and IDs, the server
+// needs reusable tokens.
+func registerUsers(t *testing.T, url string, names ...string) (map[string]string, map[string]string) {
+	tokens, ids := make(map[string]string), make(map[string]string)
+	for _, name := range names {
+		creds := auth.Credentials{Username: name, Password: "hunter22"}
+		if err := register(url, creds); err != nil {
+			t.Fatal(err)
+		}
+		token, err := getOTP(url, creds)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		resp, err := authorizedRequest("GET", url+"/users/me", token, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var me user.Profile
+		json.NewDecoder(resp.Body).Decode(&me)
+		tokens[name], ids[name] = token, me.ID
+	}
+
+	return tokens, ids
+}
+
+func TestBlocksAndMutes(t *testing.T) {
+	srv := newSignedTestServer(t)
+
+	tokens, ids := registerUsers(t, srv.URL, "alice", "bob", "carol")
+
+	for path, target := range map[string]string{"blocks": "bob", "mutes": "carol"} {
+		resp, err := authorizedRequest("PUT", srv.URL+"/users/me/"+path+"/"+ids[target], tokens["alice"], nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if resp.StatusCode != http.StatusNoContent {
+			t.Fatalf("got status code %d adding %s to %s", resp.StatusCode, target, path)
+		}
+	}
+
+	resp, err := authorizedRequest("GET", srv.URL+"/users/me/blocks", tokens["alice"], nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var blocks []string
+	json.NewDecoder(resp.Body).Decode(&blocks)
+	if !reflect.DeepEqual(blocks, []string{ids["bob"]}) {
+		t.Errorf("got blocks %v, want bob", blocks)
+	}
+
+	ws, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(srv.URL, "http")+"/ws?otp="+tokens["alice"], nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ws.Close()
+	// Wait for alice to join the channel.
+	time.Sleep(50 * time.Millisecond)
+
+	nextMessage := func() message.Message {
+		ws.SetReadDeadline(time.Now().Add(time.Second))
+		for {
+			var frame struct {
+				Type string `json:"type"`
+				message.Message
+			}
+			if err := ws.ReadJSON(&frame); err != nil {
+				t.Fatal(err)
+			}
+			if frame.Type == "" {
+				return frame.Message
+			}
+		}
+	}
+
+	t.Run("blocked senders are skipped and muted ones flagged", func(t *testing.T) {
+		for _, name := range []string{"bob", "carol"} {
+			if _, err := authorizedPost(srv.URL+"/messages", tokens[name], messageRequest{Content: "hi from " + name}); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		if got := nextMessage(); got.Content != "hi from carol" || !got.Muted {
+			t.Errorf("got %+v, want carol's message muted", got)
+		}
+	})
+
+	t.Run("blocked users can't message directly", func(t *testing.T) {
+		resp, err := authorizedPost(srv.URL+"/users/"+ids["alice"]+"/messages", tokens["bob"], messageRequest{Content: "psst"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if resp.StatusCode != http.StatusForbidden {
+			t.Errorf("got status code %d, want %d", resp.StatusCode, http.StatusForbidden)
+		}
+
+		resp, err = authorizedPost(srv.URL+"/users/"+ids["alice"]+"/messages", tokens["carol"], messageRequest{Content: "psst"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if resp.StatusCode != http.StatusAccepted {
+			t.Errorf("got status code %d, want %d", resp.StatusCode, http.StatusAccepted)
+		}
+
+		if got := nextMessage(); got.Content != "psst" || got.To != ids["alice"] || !got.Muted {
+			t.Errorf("got %+v, want carol's direct message muted", got)
+		}
+	})
+
+	t.Run("unblocking lets messages through again", func(t *testing.T) {
+		resp, err := authorizedRequest("DELETE", srv.URL+"/users/me/blocks/"+ids["bob"], tokens["alice"], nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if resp.StatusCode != http.StatusNoContent {
+			t.Fatalf("got status code %d", resp.StatusCode)
+		}
+
+		authorizedPost(srv.URL+"/messages", tokens["bob"], messageRequest{Content: "hi again"})
+		if got := nextMessage(); got.Content != "hi again" || got.Muted {
+			t.Errorf("got %+v, want bob's message", got)
+		}
+	})
+}
+
+func TestContacts(t *testing.T) {
+	srv := newSignedTestServer(t)
+
+	tokens, ids := registerUsers(t, srv.URL, "alice", "bob", "carol")
+	dial := func(name string) *websocket.Conn {
+		ws, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(srv.URL, "http")+"/ws?otp="+tokens[name], nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { ws.Close() })
+		return ws
+	}
+	nextEvent := func(ws *websocket.Conn) contact.Event {
+		ws.SetReadDeadline(time.Now().Add(time.Second))
+		for {
+			var e contact.Event
+			if err := ws.ReadJSON(&e); err != nil {
+				t.Fatal(err)
+			}
+			if e.Type == contact.RequestEventType || e.Type == contact.AcceptedEventType {
+				return e
+			}
+		}
+	}
+
+	alice, bob := dial("alice"), dial("bob")
+	// Wait for the clients to be tracked.
+	time.Sleep(50 * time.Millisecond)
+
+	resp, err := authorizedPost(srv.URL+"/contacts/requests", tokens["alice"], contactRequest{UserID: ids["bob"]})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusCreated {
+		t.Fatalf("got status code %d, want %d", resp.StatusCode, http.StatusCreated)
+	}
+
+	if e := nextEvent(bob); e.Type != contact.RequestEventType || e.User.Name != "alice" || e.From != ids["alice"] {
+		t.Errorf("got %+v, want alice's request", e)
+	}
+
+	resp, err = authorizedRequest("GET", srv.URL+"/contacts/requests", tokens["bob"], nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var pending []contact.Request
+	json.NewDecoder(resp.Body).Decode(&pending)
+	if len(pending) != 1 || pending[0].From != ids["alice"] {
+		t.Errorf("got pending %+v, want alice's request", pending)
+	}
+
+	resp, err = authorizedPost(srv.URL+"/contacts/requests", tokens["bob"], contactRequest{UserID: ids["alice"]})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusConflict {
+		t.Errorf("got status code %d for crossed request, want %d", resp.StatusCode, http.StatusConflict)
+	}
+
+	resp, err = authorizedPost(srv.URL+"/contacts/requests/"+ids["alice"]+"/accept", tokens["bob"], nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusNoContent {
+		t.Fatalf("got status code %d, want %d", resp.StatusCode, http.StatusNoContent)
+	}
+
+	if e := nextEvent(alice); e.Type != contact.AcceptedEventType || e.User.Name != "bob" {
+		t.Errorf("got %+v, want bob's acceptance", e)
+	}
+
+	resp, err = authorizedRequest("GET", srv.URL+"/contacts", tokens["alice"], nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var contacts []presence.Presence
+	json.NewDecoder(resp.Body).Decode(&contacts)
+	if len(contacts) != 1 || contacts[0].User.Name != "bob" || contacts[0].Status != presence.Online {
+		t.Errorf("got contacts %+v, want bob online", contacts)
+	}
+
+	t.Run("declined requests are gone", func(t *testing.T) {
+		authorizedPost(srv.URL+"/contacts/requests", tokens["carol"], contactRequest{UserID: ids["bob"]})
+
+		for _, want := range []int{http.StatusNoContent, http.StatusNotFound} {
+			resp, err := authorizedPost(srv.URL+"/contacts/requests/"+ids["carol"]+"/decline", tokens["bob"], nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if resp.StatusCode != want {
+				t.Errorf("got status code %d, want %d", resp.StatusCode, want)
+			}
+		}
+	})
+
+	t.Run("blocked users can't send requests", func(t *testing.T) {
+		authorizedRequest("PUT", srv.URL+"/users/me/blocks/"+ids["carol"], tokens["bob"], nil)
+
+		resp, err := authorizedPost(srv.URL+"/contacts/requests", tokens["carol"], contactRequest{UserID: ids["bob"]})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if resp.StatusCode != http.StatusForbidden {
+			t.Errorf("got status code %d, want %d", resp.StatusCode, http.StatusForbidden)
+		}
+	})
+}
+
+func TestReplay(t *testing.T) {
+	srv := newSignedTestServer(t)
+
+	tokens, ids := registerUsers(t, srv.URL, "alice", "bob")
+	for _, name := range []string{"alice", "bob", "alice"} {
+		if _, err := authorizedPost(srv.URL+"/messages", tokens[name], messageRequest{Content: "hi from " + name}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	authorizedRequest("PUT", srv.URL+"/users/me/blocks/"+ids["alice"], tokens["bob"], nil)
+
+	replay := func(name, query string) replayResponse {
+		// The channel records messages after taking them.
+		deadline := time.Now().Add(time.Second)
+		for {
+			resp, err := authorizedRequest("GET", srv.URL+"/messages?"+query, tokens[name], nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var got replayResponse
+			json.NewDecoder(resp.Body).Decode(&got)
+			if got.Last == 3 || time.Now().After(deadline) {
+				return got
+			}
+			time.Sleep(time.Millisecond)
+		}
+	}
+
+	seqs := func(resp replayResponse) []uint64 {
+		var seqs []uint64
+		for _, msg := range resp.Messages {
+			seqs = append(seqs, msg.Seq)
+		}
+		return seqs
+	}
+
+	if got := replay("alice", "after=1"); !reflect.DeepEqual(seqs(got), []uint64{2, 3}) {
+		t.Errorf("got %v, want messages 2 & 3", seqs(got))
+	}
+
+	if got := replay("bob", ""); !reflect.DeepEqual(seqs(got), []uint64{2}) || got.Last != 3 {
+		t.Errorf("got %v up to %d, want only bob's own message up to 3", seqs(got), got.Last)
+	}
+
+	resp, err := authorizedRequest("GET", srv.URL+"/messages?after=-1", tokens["alice"], nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("got status code %d, want %d", resp.StatusCode, http.StatusBadRequest)
+	}
+}
+
+func TestIdempotentSend(t *testing.T) {
+	srv := newSignedTestServer(t)
+
+	tokens, _ := registerUsers(t, srv.URL, "alice")
+	ws, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(srv.URL, "http")+"/ws?otp="+tokens["alice"], nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ws.Close()
+
+	nextAck := func() channel.Ack {
+		ws.SetReadDeadline(time.Now().Add(time.Second))
+		for {
+			var ack channel.Ack
+			if err := ws.ReadJSON(&ack); err != nil {
+				t.Fatal(err)
+			}
+			if ack.Type == channel.AckEventType {
+				return ack
+			}
+		}
+	}
+
+	// The resend reuses the client id after the connection dropped.
+	for _, want := range []bool{false, true} {
+		if err := ws.WriteJSON(message.Message{ClientID: "draft-1", Content: "hi"}); err != nil {
+			t.Fatal(err)
+		}
+
+		if ack := nextAck(); ack.ClientID != "draft-1" || ack.ID == "" || ack.Seq != 1 || ack.Duplicate != want {
+			t.Errorf("got %+v, want ack of message 1 with duplicate %t", ack, want)
+		}
+	}
+
+	resp, err := authorizedRequest("GET", srv.URL+"/messages", tokens["alice"], nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var replay replayResponse
+	json.NewDecoder(resp.Body).Decode(&replay)
+	if len(replay.Messages) != 1 {
+		t.Errorf("got %d messages, want 1", len(replay.Messages))
+	}
+}
+
+func TestPostMessage(t *testing.T) {
+	srv := newSignedTestServer(t)
+	tokens, _ := registerUsers(t, srv.URL, "alice")
+
+	post := func(req messageRequest) (int, message.Message, auth.ErrorResponse) {
+		resp, err := authorizedPost(srv.URL+"/messages", tokens["alice"], req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+
+		var msg message.Message
+		var apiErr auth.ErrorResponse
+		if resp.StatusCode < 300 {
+			json.NewDecoder(resp.Body).Decode(&msg)
+		} else {
+			json.NewDecoder(resp.Body).Decode(&apiErr)
+		}
+		return resp.StatusCode, msg, apiErr
+	}
+
+	status, sent, _ := post(messageRequest{ClientID: "draft-1", Content: "hi"})
+	if status != http.StatusCreated || sent.ID == "" || sent.Seq != 1 || sent.Content != "hi" {
+		t.Fatalf("got %d %+v, want the stored message", status, sent)
+	}
+
+	status, resent, _ := post(messageRequest{ClientID: "draft-1", Content: "hi"})
+	if status != http.StatusOK || resent.ID != sent.ID || resent.Seq != sent.Seq {
+		t.Errorf("got %d %+v, want %s again", status, resent, sent.ID)
+	}
+
+	resp, err := authorizedRequest("DELETE", srv.URL+"/messages/"+sent.ID, tokens["alice"], nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	for name, c := range map[string]struct {
+		parent string
+		status int
+		code   string
+	}{
+		"unknown parent": {"unknown", http.StatusNotFound, "message_not_found"},
+		"deleted parent": {sent.ID, http.StatusConflict, "message_deleted"},
+	} {
+		status, _, apiErr := post(messageRequest{ParentID: c.parent, Content: "reply"})
+		if status != c.status || apiErr.Code != c.code {
+			t.Errorf("%s: got %d %q, want %d %q", name, status, apiErr.Code, c.status, c.code)
+		}
+	}
+}
+
+func TestEditAndDelete(t *testing.T) {
+	srv := newSignedTestServer(t, func(c *Config) {
+		c.Moderators = []string{"mod"}
+	})
+
+	tokens, _ := registerUsers(t, srv.URL, "alice", "bob", "mod")
+	ws, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(srv.URL, "http")+"/ws?otp="+tokens["bob"], nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ws.Close()
+	// Wait for bob to join the channel.
+	time.Sleep(50 * time.Millisecond)
+
+	authorizedPost(srv.URL+"/messages", tokens["alice"], messageRequest{Content: "teh"})
+	ws.SetReadDeadline(time.Now().Add(time.Second))
+	var msg message.Message
+	for msg.ID == "" {
+		var frame struct {
+			Type string `json:"type"`
+			message.Message
+		}
+		if err := ws.ReadJSON(&frame); err != nil {
+			t.Fatal(err)
+		}
+		if frame.Type == "" {
+			msg = frame.Message
+		}
+	}
+	url := srv.URL + "/messages/" + msg.ID
+
+	nextUpdate := func() message.UpdateEvent {
+		ws.SetReadDeadline(time.Now().Add(time.Second))
+		for {
+			var e message.UpdateEvent
+			if err := ws.ReadJSON(&e); err != nil {
+				t.Fatal(err)
+			}
+			if e.Type == message.EditedEventType || e.Type == message.DeletedEventType {
+				return e
+			}
+		}
+	}
+
+	status := func(method, name string, v any) int {
+		resp, err := authorizedRequest(method, url, tokens[name], v)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+
+	if got := status("PATCH", "bob", messageRequest{Content: "mine now"}); got != http.StatusForbidden {
+		t.Errorf("got status code %d for someone else's edit, want %d", got, http.StatusForbidden)
+	}
+
+	if got := status("PATCH", "alice", messageRequest{Content: "the"}); got != http.StatusOK {
+		t.Fatalf("got status code %d for the sender's edit, want %d", got, http.StatusOK)
+	}
+
+	if e := nextUpdate(); e.Type != message.EditedEventType || e.ID != msg.ID || e.Content != "the" || e.Edited.IsZero() {
+		t.Errorf("got %+v, want the edit of %s", e, msg.ID)
+	}
+
+	resp, err := authorizedRequest("GET", url+"/versions", tokens["bob"], nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var versions []message.Version
+	json.NewDecoder(resp.Body).Decode(&versions)
+	if len(versions) != 1 || versions[0].Content != "teh" {
+		t.Errorf("got versions %+v, want the original", versions)
+	}
+
+	if got := status("DELETE", "bob", nil); got != http.StatusForbidden {
+		t.Errorf("got status code %d for someone else's deletion, want %d", got, http.StatusForbidden)
+	}
+
+	if got := status("DELETE", "mod", nil); got != http.StatusNoContent {
+		t.Fatalf("got status code %d for a moderator's deletion, want %d", got, http.StatusNoContent)
+	}
+
+	if e := nextUpdate(); e.Type != message.DeletedEventType || e.ID != msg.ID {
+		t.Errorf("got %+v, want the deletion of %s", e, msg.ID)
+	}
+
+	if got := status("PATCH", "alice", messageRequest{Content: "undo"}); got != http.StatusConflict {
+		t.Errorf("got status code %d editing a tombstone, want %d", got, http.StatusConflict)
+	}
+
+	resp, err = authorizedRequest("GET", srv.URL+"/messages", tokens["bob"], nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var replay replayResponse
+	json.NewDecoder(resp.Body).Decode(&replay)
+	if len(replay.Messages) != 1 || !replay.Messages[0].Deleted || replay.Messages[0].Content != "" {
+		t.Errorf("got %+v, want a tombstone", replay.Messages)
+	}
+}
+
+func TestReactions(t *testing.T) {
+	srv := newSignedTestServer(t)
+
+	tokens, ids := registerUsers(t, srv.URL, "alice", "bob")
+	ws, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(srv.URL, "http")+"/ws?otp="+tokens["alice"], nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ws.Close()
+
+	if err := ws.WriteJSON(message.Message{ClientID: "c1", Content: "ship it?"}); err != nil {
+		t.Fatal(err)
+	}
+
+	nextEvent := func() map[string]any {
+		ws.SetReadDeadline(time.Now().Add(time.Second))
+		for {
+			var e map[string]any
+			if err := ws.ReadJSON(&e); err != nil {
+				t.Fatal(err)
+			}
+			if e["type"] != nil && e["type"] != presence.EventType {
+				return e
+			}
+		}
+	}
+
+	ack := nextEvent()
+	url := srv.URL + "/messages/" + ack["id"].(string) + "/reactions/" + neturl.PathEscape("👍")
+	react := func(method, name string) {
+		resp, err := authorizedRequest(method, url, tokens[name], nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if resp.StatusCode != http.StatusNoContent {
+			t.Fatalf("got status code %d, want %d", resp.StatusCode, http.StatusNoContent)
+		}
+	}
+
+	react("PUT", "bob")
+	react("PUT", "bob")
+	react("PUT", "alice")
+	react("DELETE", "bob")
+
+	for _, want := range []struct {
+		kind, user string
+		count      float64
+	}{
+		{message.ReactionAddedEventType, "bob", 1},
+		{message.ReactionAddedEventType, "alice", 2},
+		{message.ReactionRemovedEventType, "bob", 1},
+	} {
+		e := nextEvent()
+		if e["type"] != want.kind || e["user_id"] != ids[want.user] || e["emoji"] != "👍" || e["count"] != want.count {
+			t.Errorf("got %v, want %s of %s leaving %v", e, want.kind, want.user, want.count)
+		}
+	}
+
+	resp, err := authorizedRequest("GET", srv.URL+"/messages", tokens["bob"], nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var replay replayResponse
+	json.NewDecoder(resp.Body).Decode(&replay)
+	want := []message.Reaction{{Emoji: "👍", Count: 1, Users: []string{ids["alice"]}}}
+	if len(replay.Messages) != 1 || !reflect.DeepEqual(replay.Messages[0].Reactions, want) {
+		t.Errorf("got %+v, want alice's reaction", replay.Messages)
+	}
+
+	resp, err = authorizedRequest("PUT", strings.Replace(url, neturl.PathEscape("👍"), "lol", 1), tokens["bob"], nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("got status code %d for a word, want %d", resp.StatusCode, http.StatusBadRequest)
+	}
+}
+
+func TestThreads(t *testing.T) {
+	srv := newSignedTestServer(t)
+
+	tokens, ids := registerUsers(t, srv.URL, "alice", "bob", "carol")
+	dial := func(name string) *websocket.Conn {
+		ws, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(srv.URL, "http")+"/ws?otp="+tokens[name], nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return ws
+	}
+	ws, carol := dial("bob"), dial("carol")
+	defer ws.Close()
+	defer carol.Close()
+	// Wait for bob and carol to join the channel.
+	time.Sleep(50 * time.Millisecond)
+
+	type frame struct {
+		Type   string         `json:"type"`
+		Thread message.Thread `json:"thread"`
+		message.Message
+	}
+	next := func(match func(frame) bool) frame {
+		ws.SetReadDeadline(time.Now().Add(time.Second))
+		for {
+			var f frame
+			if err := ws.ReadJSON(&f); err != nil {
+				t.Fatal(err)
+			}
+			if match(f) {
+				return f
+			}
+		}
+	}
+
+	authorizedPost(srv.URL+"/messages", tokens["alice"], messageRequest{Content: "parent"})
+	parent := next(func(f frame) bool { return f.Type == "" }).Message
+
+	ws.WriteJSON(map[string]string{"type": "subscribe", "thread_id": parent.ID})
+	// Wait for the subscription to reach the channel.
+	time.Sleep(50 * time.Millisecond)
+
+	resp, err := authorizedPost(srv.URL+"/messages", tokens["alice"], messageRequest{ParentID: parent.ID, Content: "reply"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusCreated {
+		t.Fatalf("got status code %d for a reply, want %d", resp.StatusCode, http.StatusCreated)
+	}
+
+	reply := next(func(f frame) bool { return f.Type == "" }).Message
+	if reply.ParentID != parent.ID || reply.Seq != 1 || reply.Content != "reply" {
+		t.Errorf("got %v, want the first reply to %s", reply, parent.ID)
+	}
+
+	summary := next(func(f frame) bool { return f.Type == message.ThreadEventType })
+	if summary.ID != parent.ID || summary.Thread.Replies != 1 || !slices.Equal(summary.Thread.Participants, []string{ids["alice"]}) {
+		t.Errorf("got %+v, want the summary of %s", summary, parent.ID)
+	}
+
+	resp, err = authorizedRequest("PATCH", srv.URL+"/messages/"+reply.ID, tokens["alice"], messageRequest{Content: "edited"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	edit := next(func(f frame) bool { return f.Type == message.EditedEventType })
+	if edit.ID != reply.ID || edit.ParentID != parent.ID {
+		t.Errorf("got %+v, want the edit of %s", edit, reply.ID)
+	}
+
+	// carol never opened the thread, she gets its summary but nothing else.
+	carol.SetReadDeadline(time.Now().Add(200 * time.Millisecond))
+	for {
+		var f frame
+		if err := carol.ReadJSON(&f); err != nil {
+			break
+		}
+		if f.ParentID != "" {
+			t.Errorf("got %+v outside of the thread", f)
+		}
+	}
+
+	for name, c := range map[string]struct {
+		parent string
+		want   int
+	}{
+		"unknown parent": {"unknown", http.StatusNotFound},
+		"nested reply":   {reply.ID, http.StatusBadRequest},
+	} {
+		resp, err := authorizedPost(srv.URL+"/messages", tokens["alice"], messageRequest{ParentID: c.parent, Content: "reply"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != c.want {
+			t.Errorf("%s: got status code %d, want %d", name, resp.StatusCode, c.want)
+		}
+	}
+
+	resp, err = authorizedRequest("GET", srv.URL+"/messages/"+parent.ID+"/replies?limit=10", tokens["bob"], nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	var page replayResponse
+	json.NewDecoder(resp.Body).Decode(&page)
+	if len(page.Messages) != 1 || page.Messages[0].ID != reply.ID || page.Last != 1 {
+		t.Errorf("got replies %+v, want %s", page, reply.ID)
+	}
+}
+
+func TestMentions(t *testing.T) {
+	srv := newSignedTestServer(t)
+
+	tokens, ids := registerUsers(t, srv.URL, "alice", "bob", "carol")
+	dial := func(name string) *websocket.Conn {
+		ws, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(srv.URL, "http")+"/ws?otp="+tokens[name], nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return ws
+	}
+	bob, bobToo, carol := dial("bob"), dial("bob"), dial("carol")
+	defer bob.Close()
+	defer bobToo.Close()
+	defer carol.Close()
+	// Wait for everyone to join the channel.
+	time.Sleep(50 * time.Millisecond)
+
+	// nextMention skips anything else, it returns false when no mention
+	// arrives in time.
+	nextMention := func(ws *websocket.Conn, wait time.Duration) (message.MentionEvent, bool) {
+		ws.SetReadDeadline(time.Now().Add(wait))
+		for {
+			var e message.MentionEvent
+			if err := ws.ReadJSON(&e); err != nil {
+				return e, false
+			}
+			if e.Type == message.MentionEventType {
+				return e, true
+			}
+		}
+	}
+
+	authorizedPost(srv.URL+"/messages", tokens["alice"], messageRequest{Content: "hi @bob and @nobody"})
+	for _, ws := range []*websocket.Conn{bob, bobToo} {
+		e, ok := nextMention(ws, time.Second)
+		if !ok || e.Message.Content != "hi @bob and @nobody" || !slices.Equal(e.Message.Mentions.Users, []string{ids["bob"]}) {
+			t.Errorf("got %+v, want bob's mention on each connection", e)
+		}
+	}
+	if e, ok := nextMention(carol, 100*time.Millisecond); ok {
+		t.Errorf("carol got %+v, want no mention", e)
+	}
+
+	// The read that timed out broke carol's connection.
+	carol = dial("carol")
+	defer carol.Close()
+	time.Sleep(50 * time.Millisecond)
+
+	authorizedPost(srv.URL+"/messages", tokens["alice"], messageRequest{Content: "@here"})
+	if e, ok := nextMention(carol, time.Second); !ok || !e.Message.Mentions.Here {
+		t.Errorf("got %+v, want carol's mention by @here", e)
+	}
+}
+
+func TestRichContent(t *testing.T) {
+	srv := newSignedTestServer(t)
+
+	tokens, _ := registerUsers(t, srv.URL, "alice", "bob")
+	ws, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(srv.URL, "http")+"/ws?otp="+tokens["bob"], nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ws.Close()
+	// Wait for bob to join the channel.
+	time.Sleep(50 * time.Millisecond)
+
+	type frame struct {
+		Type string `json:"type"`
+		Code string `json:"code"`
+		message.Message
+	}
+	next := func() frame {
+		ws.SetReadDeadline(time.Now().Add(time.Second))
+		for {
+			var f frame
+			if err := ws.ReadJSON(&f); err != nil {
+				t.Fatal(err)
+			}
+			if f.Type == "" || f.Type == channel.ErrorEventType {
+				return f
+			}
+		}
+	}
+
+	resp, err := authorizedPost(srv.URL+"/messages", tokens["alice"], messageRequest{ContentType: message.CodeContent, Content: "x := 1", Language: "go"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if got := next(); got.ContentType != message.CodeContent || got.Language != "go" || got.Content != "x := 1" {
+		t.Errorf("got %+v, want the code", got.Message)
+	}
+
+	for name, c := range map[string]struct {
+		req  messageRequest
+		code string
+	}{
+		"empty":       {messageRequest{}, "content_invalid"},
+		"system":      {messageRequest{ContentType: message.SystemContent, Content: "hi"}, "content_type_invalid"},
+		"no language": {messageRequest{ContentType: message.CodeContent, Content: "x"}, "language_invalid"},
+		"no file":     {messageRequest{ContentType: message.AttachmentContent}, "attachment_invalid"},
+	} {
+		resp, err := authorizedPost(srv.URL+"/messages", tokens["alice"], c.req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var body auth.ErrorResponse
+		json.NewDecoder(resp.Body).Decode(&body)
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusBadRequest || body.Code != c.code {
+			t.Errorf("%s: got status code %d & %q, want %d & %q", name, resp.StatusCode, body.Code, http.StatusBadRequest, c.code)
+		}
+	}
+
+	ws.WriteJSON(map[string]any{"client_id": "pic", "content_type": "attachment", "attachment": map[string]any{"url": "https://example.com/a.png", "name": "a.png", "mime_type": "image/png", "size": 3}})
+	if got := next(); got.ContentType != message.AttachmentContent || got.Attachment == nil || got.Attachment.Name != "a.png" {
+		t.Errorf("got %+v, want the attachment", got.Message)
+	}
+
+	for raw, code := range map[string]string{
+		`{"client_id": "bad", "content_type": "attachment", "attachment": {"url": "file:///etc/passwd", "name": "passwd", "mime_type": "text/plain"}}`: "attachment_invalid",
+		`{"client_id": "bad", "content_type": "code", "content": "x", "language": 42}`:                                                                 "language_invalid",
+	} {
+		ws.WriteMessage(websocket.TextMessage, []byte(raw))
+		if got := next(); got.Type != channel.ErrorEventType || got.Code != code || got.ClientID != "bad" {
+			t.Errorf("got %+v, want a %s error frame", got, code)
+		}
+	}
+}
+
+func TestInboundPipeline(t *testing.T) {
+	srv := newSignedTestServer(t, func(c *Config) {
+		c.Inbound.MaxLength = 16
+	})
+
+	tokens, _ := registerUsers(t, srv.URL, "alice", "bob")
+	ws, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(srv.URL, "http")+"/ws?otp="+tokens["bob"], nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ws.Close()
+	// Wait for bob to join the channel.
+	time.Sleep(50 * time.Millisecond)
+
+	type frame struct {
+		Type string `json:"type"`
+		Code string `json:"code"`
+		message.Message
+	}
+	next := func() frame {
+		ws.SetReadDeadline(time.Now().Add(time.Second))
+		for {
+			var f frame
+			if err := ws.ReadJSON(&f); err != nil {
+				t.Fatal(err)
+			}
+			if f.Type == "" || f.Type == channel.ErrorEventType {
+				return f
+			}
+		}
+	}
+
+	resp, err := authorizedPost(srv.URL+"/messages", tokens["alice"], messageRequest{Content: "\u202eevil\x1b[0m\u202c cafe\u0301"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if got := next(); got.Content != "evil[0m caf\u00e9" {
+		t.Errorf("got %q, want it cleaned up", got.Content)
+	}
+
+	post := func(body []byte) (int, string) {
+		req, _ := http.NewRequest("POST", srv.URL+"/messages", bytes.NewReader(body))
+		req.Header.Set("Authorization", "Bearer "+tokens["alice"])
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+
+		var e auth.ErrorResponse
+		json.NewDecoder(resp.Body).Decode(&e)
+		return resp.StatusCode, e.Code
+	}
+
+	for name, c := range map[string]struct {
+		body []byte
+		code string
+	}{
+		"too long":     {[]byte(`{"content": "far more than sixteen"}`), "content_too_long"},
+		"not utf8":     {[]byte("{\"content\": \"\xff\"}"), "message_not_utf8"},
+		"only control": {[]byte(`{"content": "\u0007"}`), "content_invalid"},
+	} {
+		if status, code := post(c.body); status != http.StatusBadRequest || code != c.code {
+			t.Errorf("%s: got status code %d & %q, want %d & %q", name, status, code, http.StatusBadRequest, c.code)
+		}
+	}
+
+	for raw, code := range map[string]string{
+		`{"client_id": "long", "content": "far more than sixteen"}`: "content_too_long",
+		"{\"content\": \"\xff\"}":                                   "message_not_utf8",
+		`{"type": "shout", "content": "hi"}`:                        "type_invalid",
+	} {
+		ws.WriteMessage(websocket.TextMessage, []byte(raw))
+		if got := next(); got.Type != channel.ErrorEventType || got.Code != code {
+			t.Errorf("got %+v, want a %s error frame", got, code)
+		}
+	}
+
+	// Frames far over the max length aren't even read, but are refused all the
+	// same.
+	ws.WriteJSON(messageRequest{Content: strings.Repeat("x", 10000)})
+	if got := next(); got.Type != channel.ErrorEventType || got.Code != "content_too_long" {
+		t.Errorf("got %+v, want a content_too_long error frame", got)
+	}
+
+	ws.WriteJSON(messageRequest{Content: "still here"})
+	if got := next(); got.Content != "still here" {
+		t.Errorf("got %+v, want the connection kept open", got)
+	}
+}
@@ -0,0 +1,155 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"strconv"
+
+	"github.com/DanyPops/logues/domain/auth"
+	"github.com/DanyPops/logues/domain/user"
+)
+
+// provision gives users of every identity source a profile and with it a
+// stable ID.
+func (s *Server) provision(u user.User) (user.User, error) {
+	p, err := user.Provision(s.profiles, u)
+	if err != nil {
+		return user.User{}, err
+	}
+
+	return p.User, nil
+}
+
+// provisioned fills in the ID of users authenticated without one, like the
+// service users of API keys.
+func (s *Server) provisioned(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		u, _ := auth.UserFromContext(r.Context())
+		if u.ID == "" {
+			provisioned, err := s.provision(u)
+			if err != nil {
+				slog.Error("provisioning user failed", "user", u.Name, "err", err)
+				auth.WriteError(w, http.StatusInternalServerError, "internal", "provisioning user failed")
+				return
+			}
+			r = r.WithContext(auth.WithUser(r.Context(), provisioned))
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (s *Server) writeProfile(w http.ResponseWriter, status int, p user.Profile) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(p); err != nil {
+		slog.Error("profile encoding failed", "err", err)
+	}
+}
+
+func (s *Server) meHandler(w http.ResponseWriter, r *http.Request) {
+	u, _ := auth.UserFromContext(r.Context())
+
+	p, err := user.Provision(s.profiles, u)
+	if err != nil {
+		slog.Error("profile lookup failed", "err", err)
+		auth.WriteError(w, http.StatusInternalServerError, "internal", "profile lookup failed")
+		return
+	}
+
+	s.writeProfile(w, http.StatusOK, p)
+}
+
+func (s *Server) userHandler(w http.ResponseWriter, r *http.Request) {
+	p, err := s.profiles.Get(r.PathValue("id"))
+	if errors.Is(err, user.ErrNotFound) {
+		auth.WriteError(w, http.StatusNotFound, "user_not_found", "user not found")
+		return
+	}
+	if err != nil {
+		slog.Error("profile lookup failed", "err", err)
+		auth.WriteError(w, http.StatusInternalServerError, "internal", "profile lookup failed")
+		return
+	}
+
+	s.writeProfile(w, http.StatusOK, p)
+}
+
+func (s *Server) updateMeHandler(w http.ResponseWriter, r *http.Request) {
+	u, _ := auth.UserFromContext(r.Context())
+
+	var update user.ProfileUpdate
+	if err := json.NewDecoder(r.Body).Decode(&update); err != nil {
+		auth.WriteError(w, http.StatusBadRequest, "bad_request", "malformed profile")
+		return
+	}
+
+	// Others see these like messages.
+	for field, text := range map[string]*string{"display_name": update.DisplayName, "bio": update.Bio} {
+		if text == nil {
+			continue
+		}
+
+		cleaned, err := s.inbound.Text(field, *text)
+		if err != nil {
+			writeContentError(w, err)
+			return
+		}
+		*text = cleaned
+	}
+
+	p, err := user.Provision(s.profiles, u)
+	if err == nil {
+		p, err = p.Apply(update)
+	}
+
+	var fieldErr user.FieldError
+	if errors.As(err, &fieldErr) {
+		auth.WriteError(w, http.StatusBadRequest, fieldErr.Field+"_invalid", fieldErr.Error())
+		return
+	}
+	if err == nil {
+		err = s.profiles.Update(p)
+	}
+	if err != nil {
+		slog.Error("profile update failed", "err", err)
+		auth.WriteError(w, http.StatusInternalServerError, "internal", "profile update failed")
+		return
+	}
+
+	s.writeProfile(w, http.StatusOK, p)
+}
+
+// searchUsersHandler pages through the users matching the q parameter, the
+// next page is asked for with the cursor of the previous one.
+func (s *Server) searchUsersHandler(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+
+	limit := 0
+	if raw := query.Get("limit"); raw != "" {
+		var err error
+		if limit, err = strconv.Atoi(raw); err != nil || limit <= 0 || limit > user.MaxSearchLimit {
+			auth.WriteError(w, http.StatusBadRequest, "limit_invalid", "limit must be between 1 and "+strconv.Itoa(user.MaxSearchLimit))
+			return
+		}
+	}
+
+	page, err := s.profiles.Search(query.Get("q"), query.Get("cursor"), limit)
+	var fieldErr user.FieldError
+	if errors.As(err, &fieldErr) {
+		auth.WriteError(w, http.StatusBadRequest, fieldErr.Field+"_invalid", fieldErr.Error())
+		return
+	}
+	if err != nil {
+		slog.Error("user search failed", "err", err)
+		auth.WriteError(w, http.StatusInternalServerError, "internal", "user search failed")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(page); err != nil {
+		slog.Error("search encoding failed", "err", err)
+	}
+}
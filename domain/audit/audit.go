@@ -0,0 +1,140 @@
+package audit
+
+import (
+	"context"
+	"log/slog"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+)
+
+type Kind string
+
+const (
+	LoginSucceeded Kind = "login_succeeded"
+	LoginFailed    Kind = "login_failed"
+	TokenIssued    Kind = "token_issued"
+	TokenConsumed  Kind = "token_consumed"
+	TokenRejected  Kind = "token_rejected"
+	Upgraded       Kind = "ws_upgraded"
+	Disconnected   Kind = "ws_disconnected"
+	Evicted        Kind = "session_evicted"
+)
+
+type Event struct {
+	Time       time.Time `json:"time"`
+	Kind       Kind      `json:"kind"`
+	User       string    `json:"user,omitempty"`
+	RemoteAddr string    `json:"remote_addr,omitempty"`
+	UserAgent  string    `json:"user_agent,omitempty"`
+	Session    string    `json:"session,omitempty"`
+	Reason     string    `json:"reason,omitempty"`
+}
+
+// RequestEvent fills in where r came from.
+func RequestEvent(kind Kind, r *http.Request) Event {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+
+	return Event{
+		Kind:       kind,
+		RemoteAddr: host,
+		UserAgent:  r.UserAgent(),
+	}
+}
+
+type Sink interface {
+	Write(Event) error
+}
+
+// Logger hands every event to all of its sinks. The zero value and nil drop
+// events.
+type Logger struct {
+	sinks []Sink
+}
+
+func NewLogger(sinks ...Sink) *Logger {
+	return &Logger{sinks: sinks}
+}
+
+func (l *Logger) Log(e Event) {
+	if l == nil {
+		return
+	}
+
+	if e.Time.IsZero() {
+		e.Time = time.Now()
+	}
+
+	for _, sink := range l.sinks {
+		if err := sink.Write(e); err != nil {
+			slog.Error("audit sink failed", "kind", e.Kind, "err", err)
+		}
+	}
+}
+
+type contextKey int
+
+const loggerContextKey contextKey = iota
+
+func WithLogger(ctx context.Context, l *Logger) context.Context {
+	return context.WithValue(ctx, loggerContextKey, l)
+}
+
+// FromContext returns the Logger of ctx, or nil which drops events.
+func FromContext(ctx context.Context) *Logger {
+	l, _ := ctx.Value(loggerContextKey).(*Logger)
+	return l
+}
+
+// Middleware makes l available to the handlers after it, see FromContext.
+func (l *Logger) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		next.ServeHTTP(w, r.WithContext(WithLogger(r.Context(), l)))
+	})
+}
+
+// MemorySink keeps events in memory, for tests.
+type MemorySink struct {
+	lock   *sync.Mutex
+	events []Event
+}
+
+func NewMemorySink() *MemorySink {
+	return &MemorySink{lock: new(sync.Mutex)}
+}
+
+func (s *MemorySink) Write(e Event) error {
+	s.lock.Lock()
+	s.events = append(s.events, e)
+	s.lock.Unlock()
+	return nil
+}
+
+// Events returns the events written so far, optionally only those of kinds.
+func (s *MemorySink) Events(kinds ...Kind) []Event {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	var events []Event
+	for _, e := range s.events {
+		if len(kinds) == 0 || containsKind(kinds, e.Kind) {
+			events = append(events, e)
+		}
+	}
+
+	return events
+}
+
+func containsKind(kinds []Kind, kind Kind) bool {
+	for _, k := range kinds {
+		if k == kind {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,57 @@
+package audit
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestLogger(t *testing.T) {
+	t.Run("Events reach every sink", func(t *testing.T) {
+		a, b := NewMemorySink(), NewMemorySink()
+		l := NewLogger(a, b)
+		l.Log(Event{Kind: LoginFailed, User: "dpop", Reason: "invalid_credentials"})
+		l.Log(Event{Kind: LoginSucceeded, User: "dpop"})
+
+		for _, sink := range []*MemorySink{a, b} {
+			events := sink.Events()
+			if len(events) != 2 {
+				t.Fatalf("Got %d events, Want 2", len(events))
+			}
+
+			if events[0].Time.IsZero() {
+				t.Error("Event time wasn't set")
+			}
+		}
+
+		if got := a.Events(LoginFailed); len(got) != 1 || got[0].Reason != "invalid_credentials" {
+			t.Errorf("Unexpected filtered events %v", got)
+		}
+	})
+
+	t.Run("Missing loggers drop events", func(t *testing.T) {
+		FromContext(context.Background()).Log(Event{Kind: LoginFailed})
+	})
+
+	t.Run("Middleware & request events", func(t *testing.T) {
+		sink := NewMemorySink()
+		h := NewLogger(sink).Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			FromContext(r.Context()).Log(RequestEvent(Upgraded, r))
+		}))
+
+		r := httptest.NewRequest("GET", "/ws", nil)
+		r.RemoteAddr = "192.0.2.1:4242"
+		r.Header.Set("User-Agent", "tester/1.0")
+		h.ServeHTTP(httptest.NewRecorder(), r)
+
+		events := sink.Events()
+		if len(events) != 1 {
+			t.Fatalf("Got %d events, Want 1", len(events))
+		}
+
+		if e := events[0]; e.Kind != Upgraded || e.RemoteAddr != "192.0.2.1" || e.UserAgent != "tester/1.0" {
+			t.Errorf("Unexpected event %+v", e)
+		}
+	})
+}
@@ -0,0 +1,111 @@
+package audit
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+)
+
+const (
+	defaultFileMaxSize    = 10 << 20
+	defaultFileMaxBackups = 5
+)
+
+// FileSink appends events as JSON lines. Once the file would grow past
+// maxSize it's renamed to path.1, shifting older backups up to path.N.
+type FileSink struct {
+	path       string
+	maxSize    int64
+	maxBackups int
+	lock       *sync.Mutex
+	file       *os.File
+	size       int64
+}
+
+// NewFileSink uses defaults for a maxSize or maxBackups of zero.
+func NewFileSink(path string, maxSize int64, maxBackups int) (*FileSink, error) {
+	if maxSize <= 0 {
+		maxSize = defaultFileMaxSize
+	}
+	if maxBackups <= 0 {
+		maxBackups = defaultFileMaxBackups
+	}
+
+	s := &FileSink{
+		path:       path,
+		maxSize:    maxSize,
+		maxBackups: maxBackups,
+		lock:       new(sync.Mutex),
+	}
+
+	if err := s.open(); err != nil {
+		return nil, err
+	}
+
+	return s, nil
+}
+
+func (s *FileSink) open() error {
+	f, err := os.OpenFile(s.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o600)
+	if err != nil {
+		return err
+	}
+
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+
+	s.file, s.size = f, info.Size()
+	return nil
+}
+
+func (s *FileSink) Write(e Event) error {
+	b, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	b = append(b, '\n')
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if s.size > 0 && s.size+int64(len(b)) > s.maxSize {
+		if err := s.rotate(); err != nil {
+			return fmt.Errorf("rotating audit log: %w", err)
+		}
+	}
+
+	n, err := s.file.Write(b)
+	s.size += int64(n)
+	return err
+}
+
+// rotate must be called with the lock held.
+func (s *FileSink) rotate() error {
+	if err := s.file.Close(); err != nil {
+		return err
+	}
+
+	os.Remove(fmt.Sprintf("%s.%d", s.path, s.maxBackups))
+	for i := s.maxBackups - 1; i > 0; i-- {
+		os.Rename(fmt.Sprintf("%s.%d", s.path, i), fmt.Sprintf("%s.%d", s.path, i+1))
+	}
+
+	if err := os.Rename(s.path, s.path+".1"); err != nil {
+		// Keep appending to the current file rather than losing events.
+		s.open()
+		return err
+	}
+
+	return s.open()
+}
+
+func (s *FileSink) Close() error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	return s.file.Close()
+}
@@ -0,0 +1,60 @@
+package audit
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func readEvents(t *testing.T, path string) []Event {
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	var events []Event
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var e Event
+		if err := json.Unmarshal(scanner.Bytes(), &e); err != nil {
+			t.Fatalf("Malformed line %q: %s", scanner.Text(), err)
+		}
+		events = append(events, e)
+	}
+
+	return events
+}
+
+func TestFileSink(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.jsonl")
+	// Room for about two events per file.
+	s, err := NewFileSink(path, 200, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	l := NewLogger(s)
+	for i := range 10 {
+		l.Log(Event{Kind: LoginSucceeded, User: fmt.Sprintf("user%d", i)})
+	}
+
+	current := readEvents(t, path)
+	if len(current) == 0 || current[len(current)-1].User != "user9" {
+		t.Errorf("Last event missing from %v", current)
+	}
+
+	for _, backup := range []string{path + ".1", path + ".2"} {
+		if len(readEvents(t, backup)) == 0 {
+			t.Errorf("Backup %s is empty", backup)
+		}
+	}
+
+	if _, err := os.Stat(path + ".3"); !os.IsNotExist(err) {
+		t.Errorf("Kept more than 2 backups: %v", err)
+	}
+}
@@ -0,0 +1,259 @@
+package auth
+
+import (
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/DanyPops/logues/domain/user"
+)
+
+const (
+	// APIKeyPrefix tells API keys apart from the tokens of the wrapped
+	// TokenAuthenticator, keys read "lgk_<id>_<secret>".
+	APIKeyPrefix = "lgk_"
+	// ServicePrefix can't appear in registered usernames, so a service user
+	// never impersonates a person.
+	ServicePrefix = "bot:"
+)
+
+type Scope string
+
+const (
+	ScopeRead  Scope = "read"
+	ScopePost  Scope = "post"
+	ScopeAdmin Scope = "admin"
+)
+
+var (
+	ErrAPIKeyNotFound = errors.New("API key not found")
+
+	scopes = []Scope{ScopeRead, ScopePost, ScopeAdmin}
+)
+
+type APIKey struct {
+	ID      string    `json:"id"`
+	Name    string    `json:"name"`
+	User    user.User `json:"user"`
+	Scopes  []Scope   `json:"scopes"`
+	Created time.Time `json:"created"`
+	Hash    string    `json:"-"`
+}
+
+func (k APIKey) Allows(scope Scope) bool {
+	return slices.Contains(k.Scopes, scope)
+}
+
+type APIKeyStore interface {
+	Add(APIKey) error
+	Get(id string) (APIKey, error)
+	List() ([]APIKey, error)
+	Delete(id string) error
+}
+
+type InMemoryAPIKeyStore struct {
+	lock *sync.RWMutex
+	keys map[string]APIKey
+}
+
+func NewInMemoryAPIKeyStore() InMemoryAPIKeyStore {
+	return InMemoryAPIKeyStore{
+		lock: new(sync.RWMutex),
+		keys: make(map[string]APIKey),
+	}
+}
+
+func (s InMemoryAPIKeyStore) Add(k APIKey) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if _, ok := s.keys[k.ID]; ok {
+		return fmt.Errorf("API key already exists: %s", k.ID)
+	}
+
+	s.keys[k.ID] = k
+	return nil
+}
+
+func (s InMemoryAPIKeyStore) Get(id string) (APIKey, error) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	k, ok := s.keys[id]
+	if !ok {
+		return APIKey{}, fmt.Errorf("%w: %s", ErrAPIKeyNotFound, id)
+	}
+
+	return k, nil
+}
+
+func (s InMemoryAPIKeyStore) List() ([]APIKey, error) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	keys := make([]APIKey, 0, len(s.keys))
+	for _, k := range s.keys {
+		keys = append(keys, k)
+	}
+
+	sort.Slice(keys, func(i, j int) bool {
+		return keys[i].Created.Before(keys[j].Created)
+	})
+
+	return keys, nil
+}
+
+func (s InMemoryAPIKeyStore) Delete(id string) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if _, ok := s.keys[id]; !ok {
+		return fmt.Errorf("%w: %s", ErrAPIKeyNotFound, id)
+	}
+
+	delete(s.keys, id)
+	return nil
+}
+
+// APIKeys accepts long lived API keys of service users and hands every other
+// token to the TokenAuthenticator it wraps.
+type APIKeys struct {
+	TokenAuthenticator
+	store APIKeyStore
+}
+
+func NewAPIKeys(ta TokenAuthenticator, store APIKeyStore) *APIKeys {
+	return &APIKeys{
+		TokenAuthenticator: ta,
+		store:              store,
+	}
+}
+
+// Create returns the new key along with its secret, which isn't kept and
+// can't be shown again.
+func (k *APIKeys) Create(name, service string, scopes []Scope) (APIKey, string, error) {
+	if strings.TrimSpace(name) == "" {
+		return APIKey{}, "", PolicyError{"name", "must not be empty"}
+	}
+
+	if !usernamePattern.MatchString(service) {
+		return APIKey{}, "", PolicyError{"service", "must be 3-32 letters, digits, '.', '_' or '-'"}
+	}
+
+	if err := validateScopes(scopes); err != nil {
+		return APIKey{}, "", err
+	}
+
+	id, err := randomKey(9)
+	if err != nil {
+		return APIKey{}, "", fmt.Errorf("generating API key id: %w", err)
+	}
+	// base64url may produce the separator.
+	id = strings.ReplaceAll(id, "_", "-")
+
+	secret, err := randomKey(32)
+	if err != nil {
+		return APIKey{}, "", fmt.Errorf("generating API key: %w", err)
+	}
+
+	raw := APIKeyPrefix + id + "_" + secret
+	key := APIKey{
+		ID:      id,
+		Name:    name,
+		User:    user.User{Name: ServicePrefix + service},
+		Scopes:  scopes,
+		Created: time.Now(),
+		Hash:    hashSecret(raw),
+	}
+
+	if err := k.store.Add(key); err != nil {
+		return APIKey{}, "", err
+	}
+
+	return key, raw, nil
+}
+
+func (k *APIKeys) List() ([]APIKey, error) {
+	return k.store.List()
+}
+
+func (k *APIKeys) Revoke(id string) error {
+	return k.store.Delete(id)
+}
+
+// Lookup returns the key t holds, ok is false for tokens that aren't API keys.
+func (k *APIKeys) Lookup(t Token) (key APIKey, ok bool, err error) {
+	rest, isKey := strings.CutPrefix(t.Key, APIKeyPrefix)
+	if !isKey {
+		return APIKey{}, false, nil
+	}
+
+	id, _, found := strings.Cut(rest, "_")
+	if !found {
+		return APIKey{}, true, fmt.Errorf("%w: API key", ErrTokenMalformed)
+	}
+
+	key, err = k.store.Get(id)
+	if err != nil {
+		return APIKey{}, true, err
+	}
+
+	if subtle.ConstantTimeCompare([]byte(hashSecret(t.Key)), []byte(key.Hash)) != 1 {
+		return APIKey{}, true, fmt.Errorf("%w: %s", ErrAPIKeyNotFound, id)
+	}
+
+	return key, true, nil
+}
+
+func (k *APIKeys) AuthenticateToken(t Token) (user.User, error) {
+	key, ok, err := k.Lookup(t)
+	if !ok {
+		return k.TokenAuthenticator.AuthenticateToken(t)
+	}
+	if err != nil {
+		return user.User{}, err
+	}
+
+	return key.User, nil
+}
+
+// RequireScope refuses API keys without scope. Other tokens belong to people,
+// who aren't limited by scopes.
+func (k *APIKeys) RequireScope(scope Scope) Policy {
+	return func(r *http.Request, u user.User) error {
+		t, _ := TokenFromContext(r.Context())
+		key, ok, err := k.Lookup(t)
+		if !ok {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		if !key.Allows(scope) {
+			return fmt.Errorf("API key %s lacks the %s scope", key.ID, scope)
+		}
+
+		return nil
+	}
+}
+
+func validateScopes(requested []Scope) error {
+	if len(requested) == 0 {
+		return PolicyError{"scopes", "must not be empty"}
+	}
+
+	for _, s := range requested {
+		if !slices.Contains(scopes, s) {
+			return PolicyError{"scopes", fmt.Sprintf("contain unknown scope %q", s)}
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,113 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/DanyPops/logues/domain/user"
+)
+
+func TestAPIKeys(t *testing.T) {
+	newAPIKeys := func(t *testing.T) (*APIKeys, OTPRetentionMap) {
+		ctx, cancel := context.WithCancel(context.Background())
+		t.Cleanup(cancel)
+		rm := NewOTPRetentionMap(ctx, NewOTPConfig())
+		return NewAPIKeys(rm, NewInMemoryAPIKeyStore()), rm
+	}
+
+	t.Run("Create & authenticate", func(t *testing.T) {
+		k, _ := newAPIKeys(t)
+		key, secret, err := k.Create("deploys", "ci-bot", []Scope{ScopePost})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if !strings.HasPrefix(secret, APIKeyPrefix) || strings.Contains(key.Hash, secret) {
+			t.Errorf("Unexpected key %s with hash %s", secret, key.Hash)
+		}
+
+		got, err := k.AuthenticateToken(Token{Key: secret})
+		if err != nil {
+			t.Fatalf("Failed to authenticate: %s", err)
+		}
+
+		if want := (user.User{Name: "bot:ci-bot"}); got != want {
+			t.Errorf("Got %v, Want %v", got, want)
+		}
+	})
+
+	t.Run("Other tokens reach the wrapped authenticator", func(t *testing.T) {
+		k, rm := newAPIKeys(t)
+		want := user.User{Name: "tester"}
+		token, _ := rm.NewToken(want)
+
+		got, err := k.AuthenticateToken(token)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if got != want {
+			t.Errorf("Got %v, Want %v", got, want)
+		}
+	})
+
+	t.Run("Wrong secret & revoked keys are refused", func(t *testing.T) {
+		k, _ := newAPIKeys(t)
+		key, secret, _ := k.Create("deploys", "ci-bot", []Scope{ScopeRead})
+
+		forged := secret[:len(secret)-4] + "AAAA"
+		if _, err := k.AuthenticateToken(Token{Key: forged}); !errors.Is(err, ErrAPIKeyNotFound) {
+			t.Errorf("Got %v, Want %v", err, ErrAPIKeyNotFound)
+		}
+
+		if err := k.Revoke(key.ID); err != nil {
+			t.Fatal(err)
+		}
+
+		if _, err := k.AuthenticateToken(Token{Key: secret}); !errors.Is(err, ErrAPIKeyNotFound) {
+			t.Errorf("Got %v, Want %v", err, ErrAPIKeyNotFound)
+		}
+
+		keys, _ := k.List()
+		if len(keys) != 0 {
+			t.Errorf("Got %d keys, Want 0", len(keys))
+		}
+	})
+
+	t.Run("Invalid requests", func(t *testing.T) {
+		k, _ := newAPIKeys(t)
+		for name, c := range map[string]struct {
+			name, service string
+			scopes        []Scope
+		}{
+			"no name":       {"", "ci-bot", []Scope{ScopeRead}},
+			"bad service":   {"deploys", "c i", []Scope{ScopeRead}},
+			"no scopes":     {"deploys", "ci-bot", nil},
+			"unknown scope": {"deploys", "ci-bot", []Scope{"root"}},
+		} {
+			var perr PolicyError
+			if _, _, err := k.Create(c.name, c.service, c.scopes); !errors.As(err, &perr) {
+				t.Errorf("%s: Got %v, Want PolicyError", name, err)
+			}
+		}
+	})
+
+	t.Run("Scopes only limit API keys", func(t *testing.T) {
+		k, rm := newAPIKeys(t)
+		_, secret, _ := k.Create("reader", "ci-bot", []Scope{ScopeRead})
+		token, _ := rm.NewToken(user.User{Name: "tester"})
+
+		for key, want := range map[string]bool{secret: false, token.Key: true} {
+			r := httptest.NewRequest("POST", "http://test.url/", nil)
+			r = r.WithContext(WithToken(r.Context(), Token{Key: key}))
+
+			err := k.RequireScope(ScopePost)(r, user.User{})
+			if (err == nil) != want {
+				t.Errorf("Got %v for %s", err, key)
+			}
+		}
+	})
+}
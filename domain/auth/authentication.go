@@ -1,10 +1,16 @@
 package auth
 
 import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
 	"errors"
 	"fmt"
 	"log/slog"
 	"net/http"
+	"sort"
 	"sync"
 	"time"
 
@@ -19,21 +25,27 @@ type Authenticator struct {
 type Credentials struct {
 	Username string `json:"username"`
 	Password string `json:"password"`
+	// Code is the TOTP or recovery code of users with a second factor, or
+	// the authorization code of an OIDC login.
+	Code string `json:"code,omitempty"`
+	// State identifies the OIDC login Code belongs to.
+	State string `json:"state,omitempty"`
+	// RemoteAddr is filled in by the server, never by the client.
+	RemoteAddr string `json:"-"`
 }
 
 type UserAuthenticator interface {
 	AuthenticateCredentials(Credentials) (user.User, error)
 }
 
-type EchoUserAuth struct {}
+type EchoUserAuth struct{}
 
 func (a EchoUserAuth) AuthenticateCredentials(creds Credentials) (user.User, error) {
-  return user.User{Name: creds.Username}, nil
+	return user.User{Name: creds.Username}, nil
 }
 
 type Token struct {
-  Key string `json:"key"`
-
+	Key string `json:"key"`
 }
 
 type TokenDecoder interface {
@@ -59,79 +71,179 @@ type OTPDecoder struct {
 func (o OTPDecoder) Decode(t *Token) error {
 	otp := o.r.URL.Query().Get("otp")
 	if otp == "" {
-		return errors.New("Empty OTP URL variable")
+		return fmt.Errorf("%w: empty otp URL variable", ErrNoToken)
 	}
 
 	t.Key = otp
 	return nil
 }
 
+const (
+	// Time an OTP stays valid after it was issued.
+	otpRetentionPeriod = 5 * time.Second
+	// Amount of random bytes behind every OTP key.
+	otpKeyLength = 32
+	// Smallest key length that is still unguessable.
+	otpMinKeyLength = 16
+	// Maximum amount of outstanding OTPs a single user may hold.
+	otpMaxPerUser = 5
+	// Interval in which expired OTPs are swept from the map.
+	otpSweepInterval = 400 * time.Millisecond
+)
+
+var ErrOTPNotFound = errors.New("OTP not found")
+
+type OTPConfig struct {
+	RetentionPeriod time.Duration
+	KeyLength       int
+	MaxPerUser      int
+}
+
+func NewOTPConfig() OTPConfig {
+	return OTPConfig{
+		RetentionPeriod: otpRetentionPeriod,
+		KeyLength:       otpKeyLength,
+		MaxPerUser:      otpMaxPerUser,
+	}
+}
+
 type OTPRetentionMap struct {
 	lock   *sync.RWMutex
 	otpMap map[string]OTP
+	config OTPConfig
 }
 
-func NewOTPRetentionMap(retentionPeriod time.Duration) OTPRetentionMap {
+// NewOTPRetentionMap starts sweeping expired OTPs until ctx is done.
+func NewOTPRetentionMap(ctx context.Context, config OTPConfig) OTPRetentionMap {
 	rm := OTPRetentionMap{
 		lock:   new(sync.RWMutex),
 		otpMap: make(map[string]OTP),
+		config: config,
 	}
 
-	go rm.Retention(retentionPeriod)
+	go rm.Retention(ctx)
 	return rm
 }
 
-func (rm OTPRetentionMap) Retention(retentionPeriod time.Duration) {
-	ticker := time.NewTicker(400 * time.Millisecond)
+func (rm OTPRetentionMap) Retention(ctx context.Context) {
+	ticker := time.NewTicker(otpSweepInterval)
+	defer ticker.Stop()
+
 	for {
 		select {
 		case <-ticker.C:
-      rm.lock.Lock()
-			for _, otp := range rm.otpMap {
-				if otp.Created.Add(retentionPeriod).Before(time.Now()) {
-					delete(rm.otpMap, otp.Key)
+			rm.lock.Lock()
+			for key, otp := range rm.otpMap {
+				if rm.expired(otp) {
+					delete(rm.otpMap, key)
 				}
 			}
-      rm.lock.Unlock()
-    // TODO - OTP Context
-		// case <-ctx.Done():
-		// 	return
-    // ODOT
+			rm.lock.Unlock()
+
+		case <-ctx.Done():
+			return
 		}
 	}
 }
 
+func (rm OTPRetentionMap) expired(otp OTP) bool {
+	return otp.Created.Add(rm.config.RetentionPeriod).Before(time.Now())
+}
+
 func (rm OTPRetentionMap) NewDecoder(r *http.Request) TokenDecoder {
-	return OTPDecoder{
-		r: r,
-	}
+	return NewDecoderChain(r)
 }
 
 func (rm OTPRetentionMap) NewToken(u user.User) (Token, error) {
-	key := "a"
+	if rm.config.KeyLength < otpMinKeyLength {
+		return Token{}, fmt.Errorf("OTP key length %d is below the minimum of %d", rm.config.KeyLength, otpMinKeyLength)
+	}
+
+	key, err := randomKey(rm.config.KeyLength)
+	if err != nil {
+		return Token{}, fmt.Errorf("generating OTP key: %w", err)
+	}
+
 	o := OTP{
 		Key:     key,
 		Created: time.Now(),
 		User:    u,
 	}
-  slog.Debug("created new otp","otp", o)
-  rm.lock.Lock()
+
+	rm.lock.Lock()
+	rm.evictExcess(u)
 	rm.otpMap[key] = o
-  rm.lock.Unlock()
-	return Token{key}, nil
+	rm.lock.Unlock()
+
+	slog.Debug("created new otp", "user", u.Name)
+	return Token{Key: key}, nil
+}
+
+// evictExcess drops the oldest OTPs of u until another one fits under the
+// per user cap. The caller must hold the lock.
+func (rm OTPRetentionMap) evictExcess(u user.User) {
+	if rm.config.MaxPerUser <= 0 {
+		return
+	}
+
+	owned := make([]OTP, 0, rm.config.MaxPerUser)
+	for _, otp := range rm.otpMap {
+		if otp.User == u {
+			owned = append(owned, otp)
+		}
+	}
+
+	if len(owned) < rm.config.MaxPerUser {
+		return
+	}
+
+	sort.Slice(owned, func(i, j int) bool {
+		return owned[i].Created.Before(owned[j].Created)
+	})
+
+	for _, otp := range owned[:len(owned)-rm.config.MaxPerUser+1] {
+		delete(rm.otpMap, otp.Key)
+	}
 }
 
 func (rm OTPRetentionMap) AuthenticateToken(t Token) (user.User, error) {
 	key := t.Key
-  rm.lock.Lock()
-  defer rm.lock.Unlock()
+	rm.lock.Lock()
+	defer rm.lock.Unlock()
+
 	otp, ok := rm.otpMap[key]
+	if !ok || rm.expired(otp) {
+		return user.User{}, fmt.Errorf("%w: %s", ErrOTPNotFound, key)
+	}
+
+	delete(rm.otpMap, key)
+	return otp.User, nil
+}
+
+// Expires returns when t is refused from on, unless it's used before.
+func (rm OTPRetentionMap) Expires(t Token) (time.Time, error) {
+	rm.lock.Lock()
+	defer rm.lock.Unlock()
+
+	otp, ok := rm.otpMap[t.Key]
 	if !ok {
-		return user.User{}, fmt.Errorf("OTP not found in map: %s", otp)
+		return time.Time{}, fmt.Errorf("%w: %s", ErrOTPNotFound, t.Key)
+	}
+
+	return otp.Created.Add(rm.config.RetentionPeriod), nil
+}
+
+func randomKey(length int) (string, error) {
+	b := make([]byte, length)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
 	}
-  
-  delete(rm.otpMap, key)
-	u := otp.User
 
-	return u, nil
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// hashSecret needs no salt or stretching, the secrets it hashes are random.
+func hashSecret(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
 }
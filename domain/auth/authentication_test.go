@@ -1,10 +1,13 @@
 package auth
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http/httptest"
 	"reflect"
+	"strings"
 	"testing"
 	"time"
 
@@ -13,33 +16,154 @@ import (
 
 func TestAuth(t *testing.T) {
 	t.Run("Request Token & Authenticate", func(t *testing.T) {
-    rp := time.Millisecond * 1
-    rm := NewOTPRetentionMap(rp)
-    w := httptest.NewRecorder()
-    want := user.User{Name: "tester"}
-
-    token1, _ := rm.NewToken(want)
-    json.NewEncoder(w).Encode(token1)
-    otp := token1.Key
-    if otp == "" {
-      t.Fatalf("Empty token value")
-    }
-
-    url := fmt.Sprintf("http://test.url/?otp=%s", otp)
-    r := httptest.NewRequest("POST", url, nil)
-
-    var token2 Token
-    if err := rm.NewDecoder(r).Decode(&token2); err != nil {
-      t.Fatalf("Failed to decode: %s", err)
-    }
-
-    got, err := rm.AuthenticateToken(token2)
-    if err != nil {
-      t.Fatalf("Failed to authenticate: %s", err)
-    }
-
-    if !reflect.DeepEqual(got, want) {
-      t.Errorf("Got %v, Want %v", got, want)
-    }
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		rm := NewOTPRetentionMap(ctx, NewOTPConfig())
+		w := httptest.NewRecorder()
+		want := user.User{Name: "tester"}
+
+		token1, _ := rm.NewToken(want)
+		json.NewEncoder(w).Encode(token1)
+		otp := token1.Key
+		if otp == "" {
+			t.Fatalf("Empty token value")
+		}
+
+		url := fmt.Sprintf("http://test.url/?otp=%s", otp)
+		r := httptest.NewRequest("POST", url, nil)
+
+		var token2 Token
+		if err := rm.NewDecoder(r).Decode(&token2); err != nil {
+			t.Fatalf("Failed to decode: %s", err)
+		}
+
+		got, err := rm.AuthenticateToken(token2)
+		if err != nil {
+			t.Fatalf("Failed to authenticate: %s", err)
+		}
+
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("Got %v, Want %v", got, want)
+		}
+	})
+}
+
+func TestOTPRetentionMap(t *testing.T) {
+	u := user.User{Name: "tester"}
+
+	t.Run("Keys are unique & of configured length", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		config := NewOTPConfig()
+		config.KeyLength = 24
+		config.MaxPerUser = 0
+		rm := NewOTPRetentionMap(ctx, config)
+
+		seen := make(map[string]bool)
+		for range 100 {
+			token, err := rm.NewToken(u)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if len(token.Key) != 32 {
+				t.Errorf("Got key length %d, Want %d", len(token.Key), 32)
+			}
+
+			if seen[token.Key] {
+				t.Fatalf("Duplicate key %s", token.Key)
+			}
+			seen[token.Key] = true
+		}
+	})
+
+	t.Run("Short keys are refused", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		config := NewOTPConfig()
+		config.KeyLength = 4
+		rm := NewOTPRetentionMap(ctx, config)
+
+		if _, err := rm.NewToken(u); err == nil {
+			t.Errorf("Expected error for key length %d", config.KeyLength)
+		}
+	})
+
+	t.Run("Tokens are single use", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		rm := NewOTPRetentionMap(ctx, NewOTPConfig())
+
+		token, _ := rm.NewToken(u)
+		if _, err := rm.AuthenticateToken(token); err != nil {
+			t.Fatal(err)
+		}
+
+		_, err := rm.AuthenticateToken(token)
+		if !errors.Is(err, ErrOTPNotFound) {
+			t.Fatalf("Got %v, Want %v", err, ErrOTPNotFound)
+		}
+
+		if !strings.Contains(err.Error(), token.Key) {
+			t.Errorf("Error %q doesn't name the missing key", err)
+		}
+	})
+
+	t.Run("Oldest tokens are evicted above the per user cap", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		config := NewOTPConfig()
+		config.MaxPerUser = 2
+		rm := NewOTPRetentionMap(ctx, config)
+
+		tokens := make([]Token, 3)
+		for i := range tokens {
+			tokens[i], _ = rm.NewToken(u)
+			time.Sleep(time.Millisecond)
+		}
+		other, _ := rm.NewToken(user.User{Name: "other"})
+
+		if _, err := rm.AuthenticateToken(tokens[0]); err == nil {
+			t.Errorf("Oldest token survived the cap")
+		}
+
+		for _, token := range append(tokens[1:], other) {
+			if _, err := rm.AuthenticateToken(token); err != nil {
+				t.Errorf("Failed to authenticate: %s", err)
+			}
+		}
+	})
+
+	t.Run("Expired tokens are rejected", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		config := NewOTPConfig()
+		config.RetentionPeriod = time.Millisecond
+		rm := NewOTPRetentionMap(ctx, config)
+
+		token, _ := rm.NewToken(u)
+		time.Sleep(5 * time.Millisecond)
+
+		if _, err := rm.AuthenticateToken(token); !errors.Is(err, ErrOTPNotFound) {
+			t.Errorf("Got %v, Want %v", err, ErrOTPNotFound)
+		}
+	})
+
+	t.Run("Retention stops with its context", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		rm := OTPRetentionMap{otpMap: make(map[string]OTP), config: NewOTPConfig()}
+
+		done := make(chan struct{})
+		go func() {
+			rm.Retention(ctx)
+			close(done)
+		}()
+		cancel()
+
+		select {
+		case <-done:
+		case <-time.After(time.Second):
+			t.Fatal("Retention didn't stop after cancel")
+		}
 	})
 }
@@ -0,0 +1,111 @@
+package auth
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+const (
+	// Subprotocol is offered alongside the token subprotocol, since browsers
+	// drop the connection unless the server echoes one of the offered ones.
+	Subprotocol = "logues"
+	// TokenSubprotocolPrefix carries a token in Sec-WebSocket-Protocol, e.g.
+	// "logues.token.<key>".
+	TokenSubprotocolPrefix = "logues.token."
+	TokenCookieName        = "logues_token"
+)
+
+var ErrNoToken = errors.New("no token in request")
+
+type BearerDecoder struct {
+	r *http.Request
+}
+
+func (d BearerDecoder) Decode(t *Token) error {
+	header := d.r.Header.Get("Authorization")
+	if header == "" {
+		return ErrNoToken
+	}
+
+	scheme, key, ok := strings.Cut(header, " ")
+	if !ok || !strings.EqualFold(scheme, "Bearer") || key == "" {
+		return fmt.Errorf("%w: Authorization header isn't a bearer token", ErrTokenMalformed)
+	}
+
+	t.Key = key
+	return nil
+}
+
+type CookieDecoder struct {
+	r    *http.Request
+	name string
+}
+
+func (d CookieDecoder) Decode(t *Token) error {
+	c, err := d.r.Cookie(d.name)
+	if err != nil || c.Value == "" {
+		return ErrNoToken
+	}
+
+	t.Key = c.Value
+	return nil
+}
+
+// TokenCookie stores t in an HttpOnly cookie CookieDecoder reads back.
+func TokenCookie(r *http.Request, t Token) *http.Cookie {
+	return &http.Cookie{
+		Name:     TokenCookieName,
+		Value:    t.Key,
+		Path:     "/",
+		HttpOnly: true,
+		Secure:   r.TLS != nil,
+		SameSite: http.SameSiteStrictMode,
+	}
+}
+
+type SubprotocolDecoder struct {
+	r *http.Request
+}
+
+func (d SubprotocolDecoder) Decode(t *Token) error {
+	for _, header := range d.r.Header.Values("Sec-WebSocket-Protocol") {
+		for _, protocol := range strings.Split(header, ",") {
+			key, ok := strings.CutPrefix(strings.TrimSpace(protocol), TokenSubprotocolPrefix)
+			if ok && key != "" {
+				t.Key = key
+				return nil
+			}
+		}
+	}
+
+	return ErrNoToken
+}
+
+// DecoderChain decodes with the first decoder that finds a token. A token that
+// is present but malformed fails the chain instead of falling through.
+type DecoderChain []TokenDecoder
+
+// NewDecoderChain prefers tokens the client sent explicitly over the cookie
+// the browser attaches on its own:
+// Authorization header, WebSocket subprotocol, otp query parameter, cookie.
+func NewDecoderChain(r *http.Request) DecoderChain {
+	return DecoderChain{
+		BearerDecoder{r: r},
+		SubprotocolDecoder{r: r},
+		OTPDecoder{r: r},
+		CookieDecoder{r: r, name: TokenCookieName},
+	}
+}
+
+func (c DecoderChain) Decode(t *Token) error {
+	for _, d := range c {
+		err := d.Decode(t)
+		if !errors.Is(err, ErrNoToken) {
+			return err
+		}
+	}
+
+	return ErrNoToken
+}
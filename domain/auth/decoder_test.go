@@ -0,0 +1,74 @@
+package auth
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestDecoderChain(t *testing.T) {
+	request := func(setup func(r *http.Request)) *http.Request {
+		r := httptest.NewRequest("GET", "http://test.url/ws", nil)
+		setup(r)
+		return r
+	}
+
+	withHeader := func(r *http.Request) { r.Header.Set("Authorization", "Bearer header") }
+	withProtocol := func(r *http.Request) {
+		r.Header.Set("Sec-WebSocket-Protocol", Subprotocol+", "+TokenSubprotocolPrefix+"protocol")
+	}
+	withQuery := func(r *http.Request) { r.URL.RawQuery = "otp=query" }
+	withCookie := func(r *http.Request) { r.AddCookie(&http.Cookie{Name: TokenCookieName, Value: "cookie"}) }
+	all := func(setups ...func(*http.Request)) func(*http.Request) {
+		return func(r *http.Request) {
+			for _, setup := range setups {
+				setup(r)
+			}
+		}
+	}
+
+	cases := map[string]struct {
+		r    *http.Request
+		want string
+	}{
+		"header":                       {request(withHeader), "header"},
+		"subprotocol":                  {request(withProtocol), "protocol"},
+		"query":                        {request(withQuery), "query"},
+		"cookie":                       {request(withCookie), "cookie"},
+		"header beats everything":      {request(all(withCookie, withQuery, withProtocol, withHeader)), "header"},
+		"subprotocol beats the rest":   {request(all(withCookie, withQuery, withProtocol)), "protocol"},
+		"query beats the cookie":       {request(all(withCookie, withQuery)), "query"},
+		"cookie is the last resort":    {request(all(withCookie)), "cookie"},
+		"lowercase scheme is accepted": {request(func(r *http.Request) { r.Header.Set("Authorization", "bearer header") }), "header"},
+	}
+
+	for name, c := range cases {
+		t.Run(name, func(t *testing.T) {
+			var token Token
+			if err := NewDecoderChain(c.r).Decode(&token); err != nil {
+				t.Fatalf("Failed to decode: %s", err)
+			}
+
+			if token.Key != c.want {
+				t.Errorf("Got %s, Want %s", token.Key, c.want)
+			}
+		})
+	}
+
+	t.Run("No token", func(t *testing.T) {
+		r := request(func(r *http.Request) { r.Header.Set("Sec-WebSocket-Protocol", Subprotocol) })
+		var token Token
+		if err := NewDecoderChain(r).Decode(&token); !errors.Is(err, ErrNoToken) {
+			t.Errorf("Got %v, Want %v", err, ErrNoToken)
+		}
+	})
+
+	t.Run("Malformed header doesn't fall through", func(t *testing.T) {
+		r := request(all(withCookie, func(r *http.Request) { r.Header.Set("Authorization", "Basic Zm9vOmJhcg==") }))
+		var token Token
+		if err := NewDecoderChain(r).Decode(&token); !errors.Is(err, ErrTokenMalformed) {
+			t.Errorf("Got %v, Want %v", err, ErrTokenMalformed)
+		}
+	})
+}
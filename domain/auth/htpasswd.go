@@ -0,0 +1,320 @@
+package auth
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+
+	"github.com/DanyPops/logues/domain/user"
+)
+
+const (
+	// Interval in which the file is checked for changes.
+	htpasswdPollInterval = 2 * time.Second
+
+	sha256CryptPrefix        = "$5$"
+	sha256CryptDefaultRounds = 5000
+	sha256CryptMinRounds     = 1000
+	sha256CryptMaxRounds     = 999999999
+	sha256CryptMaxSalt       = 16
+)
+
+var errUnsupportedHash = errors.New("unsupported hash")
+
+// HtpasswdAuth authenticates against a static htpasswd style file of
+// "user:hash" lines with bcrypt or SHA-256 crypt hashes. The file is reloaded
+// when it changes, which only affects later logins.
+type HtpasswdAuth struct {
+	path      string
+	lock      *sync.RWMutex
+	hashes    map[string]string
+	modTime   time.Time
+	size      int64
+	dummyHash []byte
+}
+
+// NewHtpasswdAuth loads path and watches it until ctx is done, checking every
+// interval or a default when it's zero.
+func NewHtpasswdAuth(ctx context.Context, path string, interval time.Duration) (*HtpasswdAuth, error) {
+	dummy, err := bcrypt.GenerateFromPassword([]byte("logues"), bcrypt.MinCost)
+	if err != nil {
+		return nil, err
+	}
+
+	a := &HtpasswdAuth{
+		path:      path,
+		lock:      new(sync.RWMutex),
+		dummyHash: dummy,
+	}
+
+	if err := a.reload(); err != nil {
+		return nil, err
+	}
+
+	if interval <= 0 {
+		interval = htpasswdPollInterval
+	}
+	go a.Watch(ctx, interval)
+	return a, nil
+}
+
+func (a *HtpasswdAuth) Watch(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			info, err := os.Stat(a.path)
+			if err != nil {
+				slog.Warn("htpasswd file unavailable, keeping loaded users", "path", a.path, "err", err)
+				continue
+			}
+
+			a.lock.RLock()
+			changed := !info.ModTime().Equal(a.modTime) || info.Size() != a.size
+			a.lock.RUnlock()
+
+			if !changed {
+				continue
+			}
+
+			if err := a.reload(); err != nil {
+				slog.Warn("htpasswd reload failed, keeping loaded users", "path", a.path, "err", err)
+			}
+
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+func (a *HtpasswdAuth) reload() error {
+	f, err := os.Open(a.path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+
+	hashes := make(map[string]string)
+	scanner := bufio.NewScanner(f)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+
+		name, hash, err := parseHtpasswdLine(text)
+		if err != nil {
+			slog.Warn("skipping malformed htpasswd line", "path", a.path, "line", line, "err", err)
+			continue
+		}
+
+		if _, ok := hashes[name]; ok {
+			slog.Warn("skipping duplicate htpasswd user", "path", a.path, "line", line, "user", name)
+			continue
+		}
+		hashes[name] = hash
+	}
+
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+
+	a.lock.Lock()
+	a.hashes = hashes
+	a.modTime = info.ModTime()
+	a.size = info.Size()
+	a.lock.Unlock()
+
+	slog.Info("loaded htpasswd file", "path", a.path, "users", len(hashes))
+	return nil
+}
+
+func parseHtpasswdLine(text string) (string, string, error) {
+	name, hash, ok := strings.Cut(text, ":")
+	if !ok || name == "" || hash == "" {
+		return "", "", errors.New("expected user:hash")
+	}
+
+	switch {
+	case strings.HasPrefix(hash, "$2"):
+		if _, err := bcrypt.Cost([]byte(hash)); err != nil {
+			return "", "", err
+		}
+
+	case strings.HasPrefix(hash, sha256CryptPrefix):
+		if _, _, _, err := parseSHA256Crypt(hash); err != nil {
+			return "", "", err
+		}
+
+	default:
+		return "", "", errUnsupportedHash
+	}
+
+	return name, hash, nil
+}
+
+func (a *HtpasswdAuth) AuthenticateCredentials(creds Credentials) (user.User, error) {
+	a.lock.RLock()
+	hash, ok := a.hashes[creds.Username]
+	a.lock.RUnlock()
+
+	if !ok {
+		bcrypt.CompareHashAndPassword(a.dummyHash, []byte(creds.Password))
+		return user.User{}, ErrInvalidCredentials
+	}
+
+	if !checkHtpasswdHash(hash, creds.Password) {
+		return user.User{}, ErrInvalidCredentials
+	}
+
+	return user.User{Name: creds.Username}, nil
+}
+
+func checkHtpasswdHash(hash, password string) bool {
+	if strings.HasPrefix(hash, sha256CryptPrefix) {
+		rounds, explicit, salt, err := parseSHA256Crypt(hash)
+		if err != nil {
+			return false
+		}
+
+		want := sha256Crypt(password, salt, rounds, explicit)
+		return subtle.ConstantTimeCompare([]byte(want), []byte(hash)) == 1
+	}
+
+	return bcrypt.CompareHashAndPassword([]byte(hash), []byte(password)) == nil
+}
+
+// parseSHA256Crypt splits "$5$[rounds=N$]salt$hash".
+func parseSHA256Crypt(hash string) (int, bool, string, error) {
+	rest := strings.TrimPrefix(hash, sha256CryptPrefix)
+	rounds, explicit := sha256CryptDefaultRounds, false
+
+	if spec, after, ok := strings.Cut(rest, "$"); ok && strings.HasPrefix(spec, "rounds=") {
+		n, err := strconv.Atoi(strings.TrimPrefix(spec, "rounds="))
+		if err != nil {
+			return 0, false, "", fmt.Errorf("SHA-256 crypt rounds: %w", err)
+		}
+		rounds, explicit, rest = min(max(n, sha256CryptMinRounds), sha256CryptMaxRounds), true, after
+	}
+
+	salt, sum, ok := strings.Cut(rest, "$")
+	if !ok || len(salt) > sha256CryptMaxSalt || len(sum) != 43 {
+		return 0, false, "", errors.New("malformed SHA-256 crypt hash")
+	}
+
+	return rounds, explicit, salt, nil
+}
+
+const cryptAlphabet = "./0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz"
+
+// sha256Crypt implements Ulrich Drepper's SHA-256 crypt as used by glibc.
+func sha256Crypt(password, salt string, rounds int, explicitRounds bool) string {
+	p, s := []byte(password), []byte(salt)
+	if len(s) > sha256CryptMaxSalt {
+		s = s[:sha256CryptMaxSalt]
+	}
+
+	b := sha256.New()
+	b.Write(p)
+	b.Write(s)
+	b.Write(p)
+	sumB := b.Sum(nil)
+
+	a := sha256.New()
+	a.Write(p)
+	a.Write(s)
+	a.Write(repeatTo(sumB, len(p)))
+	for n := len(p); n > 0; n >>= 1 {
+		if n&1 == 1 {
+			a.Write(sumB)
+		} else {
+			a.Write(p)
+		}
+	}
+	sumA := a.Sum(nil)
+
+	dp := sha256.New()
+	for range len(p) {
+		dp.Write(p)
+	}
+	seqP := repeatTo(dp.Sum(nil), len(p))
+
+	ds := sha256.New()
+	for range 16 + int(sumA[0]) {
+		ds.Write(s)
+	}
+	seqS := repeatTo(ds.Sum(nil), len(s))
+
+	sum := sumA
+	for i := range rounds {
+		c := sha256.New()
+		if i%2 == 1 {
+			c.Write(seqP)
+		} else {
+			c.Write(sum)
+		}
+		if i%3 != 0 {
+			c.Write(seqS)
+		}
+		if i%7 != 0 {
+			c.Write(seqP)
+		}
+		if i%2 == 1 {
+			c.Write(sum)
+		} else {
+			c.Write(seqP)
+		}
+		sum = c.Sum(nil)
+	}
+
+	var out strings.Builder
+	out.WriteString(sha256CryptPrefix)
+	if explicitRounds {
+		fmt.Fprintf(&out, "rounds=%d$", rounds)
+	}
+	out.Write(s)
+	out.WriteByte('$')
+
+	encode := func(b2, b1, b0 byte, n int) {
+		w := uint(b2)<<16 | uint(b1)<<8 | uint(b0)
+		for range n {
+			out.WriteByte(cryptAlphabet[w&0x3f])
+			w >>= 6
+		}
+	}
+	for i := range 10 {
+		// Bytes are taken in the order 0 10 20, 21 1 11, 12 22 2, ...
+		encode(sum[(i*21)%30], sum[(i*21+10)%30], sum[(i*21+20)%30], 4)
+	}
+	encode(0, sum[31], sum[30], 3)
+
+	return out.String()
+}
+
+// repeatTo repeats b until it's n bytes long.
+func repeatTo(b []byte, n int) []byte {
+	out := make([]byte, 0, n)
+	for len(out) < n {
+		out = append(out, b[:min(len(b), n-len(out))]...)
+	}
+
+	return out
+}
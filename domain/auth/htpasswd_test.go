@@ -0,0 +1,138 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+
+	"github.com/DanyPops/logues/domain/user"
+)
+
+func TestSHA256Crypt(t *testing.T) {
+	// Vectors from Drepper's SHA-crypt specification.
+	tests := []struct {
+		hash     string
+		password string
+	}{
+		{"$5$saltstring$5B8vYYiY.CVt1RlTTf8KbXBH3hsxY/GNooZaBBGWEc5", "Hello world!"},
+		{"$5$rounds=10000$saltstringsaltst$3xv.VbSHBb41AL9AvLeujZkZRBAwqFMz2.opqey6IcA", "Hello world!"},
+		{"$5$rounds=5000$toolongsaltstrin$Un/5jzAHMgOGZ5.mWJpuVolil07guHPvOW8mGRcvxa5", "This is just a test"},
+		{"$5$rounds=1400$anotherlongsalts$Rx.j8H.h8HjEDGomFU8bDkXm3XIUnzyxf12oP84Bnq1", "a very much longer text to encrypt.  This one even stretches over morethan one line."},
+		{"$5$rounds=1000$roundstoolow$yfvwcWrQ8l/K0DAWyuPMDNHpIVlTQebY9l/gL972bIC", "the minimum number is still observed"},
+	}
+
+	for _, test := range tests {
+		if !checkHtpasswdHash(test.hash, test.password) {
+			t.Errorf("%s doesn't match %q", test.hash, test.password)
+		}
+
+		if checkHtpasswdHash(test.hash, test.password+"!") {
+			t.Errorf("%s matches a wrong password", test.hash)
+		}
+	}
+}
+
+func writeHtpasswd(t *testing.T, path string, lines string, modTime time.Time) {
+	if err := os.WriteFile(path, []byte(lines), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := os.Chtimes(path, modTime, modTime); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestHtpasswdAuth(t *testing.T) {
+	bcryptHash, _ := bcrypt.GenerateFromPassword([]byte("hunter22"), bcrypt.MinCost)
+	shaHash := "$5$saltstring$5B8vYYiY.CVt1RlTTf8KbXBH3hsxY/GNooZaBBGWEc5"
+
+	newHtpasswdAuth := func(t *testing.T, lines string) (*HtpasswdAuth, string) {
+		ctx, cancel := context.WithCancel(context.Background())
+		t.Cleanup(cancel)
+
+		path := filepath.Join(t.TempDir(), "htpasswd")
+		writeHtpasswd(t, path, lines, time.Now().Add(-time.Hour))
+
+		a, err := NewHtpasswdAuth(ctx, path, 10*time.Millisecond)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		return a, path
+	}
+
+	t.Run("bcrypt & SHA-256 crypt entries", func(t *testing.T) {
+		a, _ := newHtpasswdAuth(t, "alice:"+string(bcryptHash)+"\nbob:"+shaHash+"\n")
+
+		for _, creds := range []Credentials{
+			{Username: "alice", Password: "hunter22"},
+			{Username: "bob", Password: "Hello world!"},
+		} {
+			got, err := a.AuthenticateCredentials(creds)
+			if err != nil {
+				t.Fatalf("Failed to authenticate %s: %s", creds.Username, err)
+			}
+
+			if want := (user.User{Name: creds.Username}); got != want {
+				t.Errorf("Got %v, Want %v", got, want)
+			}
+		}
+
+		for _, creds := range []Credentials{
+			{Username: "alice", Password: "Hello world!"},
+			{Username: "carol", Password: "hunter22"},
+		} {
+			if _, err := a.AuthenticateCredentials(creds); !errors.Is(err, ErrInvalidCredentials) {
+				t.Errorf("Got %v, Want %v", err, ErrInvalidCredentials)
+			}
+		}
+	})
+
+	t.Run("Malformed lines are skipped", func(t *testing.T) {
+		a, _ := newHtpasswdAuth(t, "# users\nnocolon\n:"+shaHash+"\ncarol:{SHA}W6ph5Mm5Pz8GgiULbPgzG37mj9g=\nbob:"+shaHash+"\n")
+
+		if _, err := a.AuthenticateCredentials(Credentials{Username: "bob", Password: "Hello world!"}); err != nil {
+			t.Errorf("Failed to authenticate after malformed lines: %s", err)
+		}
+
+		if len(a.hashes) != 1 {
+			t.Errorf("Got %d users, Want 1", len(a.hashes))
+		}
+	})
+
+	t.Run("Changes are reloaded", func(t *testing.T) {
+		a, path := newHtpasswdAuth(t, "alice:"+string(bcryptHash)+"\n")
+		writeHtpasswd(t, path, "bob:"+shaHash+"\n", time.Now())
+
+		deadline := time.Now().Add(time.Second)
+		for {
+			_, err := a.AuthenticateCredentials(Credentials{Username: "bob", Password: "Hello world!"})
+			if err == nil {
+				break
+			}
+			if time.Now().After(deadline) {
+				t.Fatal("File wasn't reloaded")
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+
+		if _, err := a.AuthenticateCredentials(Credentials{Username: "alice", Password: "hunter22"}); err == nil {
+			t.Error("Removed user still authenticates")
+		}
+	})
+
+	t.Run("Users are kept while the file is missing", func(t *testing.T) {
+		a, path := newHtpasswdAuth(t, "bob:"+shaHash+"\n")
+		os.Remove(path)
+		time.Sleep(50 * time.Millisecond)
+
+		if _, err := a.AuthenticateCredentials(Credentials{Username: "bob", Password: "Hello world!"}); err != nil {
+			t.Errorf("Lost users with the file: %s", err)
+		}
+	})
+}
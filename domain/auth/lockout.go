@@ -0,0 +1,228 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/DanyPops/logues/domain/user"
+)
+
+const (
+	// Failed attempts per username before it is locked.
+	lockoutUserThreshold = 5
+	// Failed attempts per address before it is locked, higher as users may
+	// share an address.
+	lockoutIPThreshold = 20
+	// Lockout after the first failure above a threshold, doubled with every
+	// further one.
+	lockoutBaseDelay = time.Second
+	lockoutMaxDelay  = 15 * time.Minute
+	// Failures are forgotten after this long without a new one.
+	lockoutWindow = 15 * time.Minute
+)
+
+// LockoutError reports credentials that weren't checked because there were
+// too many failed attempts.
+type LockoutError struct {
+	RetryAfter time.Duration
+}
+
+func (e LockoutError) Error() string {
+	return fmt.Sprintf("too many failed attempts, retry after %s", e.RetryAfter.Round(time.Second))
+}
+
+type LockoutConfig struct {
+	UserThreshold int
+	IPThreshold   int
+	BaseDelay     time.Duration
+	MaxDelay      time.Duration
+	Window        time.Duration
+}
+
+func NewLockoutConfig() LockoutConfig {
+	return LockoutConfig{
+		UserThreshold: lockoutUserThreshold,
+		IPThreshold:   lockoutIPThreshold,
+		BaseDelay:     lockoutBaseDelay,
+		MaxDelay:      lockoutMaxDelay,
+		Window:        lockoutWindow,
+	}
+}
+
+type attempts struct {
+	failures int
+	// inFlight counts attempts being checked, which count toward the
+	// threshold until their outcome is known.
+	inFlight    int
+	last        time.Time
+	lockedUntil time.Time
+}
+
+// LockoutAuth counts failed attempts per username and per remote address and
+// refuses to consult the wrapped UserAuthenticator while either is locked.
+type LockoutAuth struct {
+	UserAuthenticator
+	config LockoutConfig
+	lock   *sync.Mutex
+	users  map[string]*attempts
+	addrs  map[string]*attempts
+}
+
+// NewLockoutAuth wraps ua, using the defaults for any zero field of config.
+func NewLockoutAuth(ctx context.Context, ua UserAuthenticator, config LockoutConfig) *LockoutAuth {
+	defaults := NewLockoutConfig()
+	if config.UserThreshold <= 0 {
+		config.UserThreshold = defaults.UserThreshold
+	}
+	if config.IPThreshold <= 0 {
+		config.IPThreshold = defaults.IPThreshold
+	}
+	if config.BaseDelay <= 0 {
+		config.BaseDelay = defaults.BaseDelay
+	}
+	if config.MaxDelay <= 0 {
+		config.MaxDelay = defaults.MaxDelay
+	}
+	if config.Window <= 0 {
+		config.Window = defaults.Window
+	}
+
+	a := &LockoutAuth{
+		UserAuthenticator: ua,
+		config:            config,
+		lock:              new(sync.Mutex),
+		users:             make(map[string]*attempts),
+		addrs:             make(map[string]*attempts),
+	}
+
+	go a.Retention(ctx)
+	return a
+}
+
+func (a *LockoutAuth) Retention(ctx context.Context) {
+	ticker := time.NewTicker(a.config.Window)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			now := time.Now()
+			a.lock.Lock()
+			for _, m := range []map[string]*attempts{a.users, a.addrs} {
+				for key, at := range m {
+					if a.forgotten(at, now) {
+						delete(m, key)
+					}
+				}
+			}
+			a.lock.Unlock()
+
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+func (a *LockoutAuth) AuthenticateCredentials(creds Credentials) (user.User, error) {
+	now := time.Now()
+
+	a.lock.Lock()
+	byUser := a.entry(a.users, creds.Username, now)
+	byAddr := &attempts{}
+	if creds.RemoteAddr != "" {
+		byAddr = a.entry(a.addrs, creds.RemoteAddr, now)
+	}
+
+	wait := max(a.remaining(byUser, a.config.UserThreshold, now), a.remaining(byAddr, a.config.IPThreshold, now))
+	if wait > 0 {
+		a.lock.Unlock()
+		return user.User{}, LockoutError{RetryAfter: wait}
+	}
+	// Reserve the attempt, so guesses sent at once can't all get past the
+	// check before the first failure is counted.
+	byUser.inFlight++
+	byAddr.inFlight++
+	a.lock.Unlock()
+
+	u, err := a.UserAuthenticator.AuthenticateCredentials(creds)
+
+	a.lock.Lock()
+	defer a.lock.Unlock()
+
+	byUser.inFlight--
+	byAddr.inFlight--
+	switch {
+	case errors.Is(err, ErrInvalidCredentials):
+		a.fail(byUser, a.config.UserThreshold, now)
+		a.fail(byAddr, a.config.IPThreshold, now)
+
+	case err == nil:
+		// Only the username is forgiven, otherwise a single valid account
+		// would let an address keep guessing the passwords of others.
+		byUser.failures, byUser.lockedUntil = 0, time.Time{}
+		if byUser.inFlight == 0 {
+			delete(a.users, creds.Username)
+		}
+	}
+
+	return u, err
+}
+
+// entry returns the attempts under key, starting over when the previous ones
+// are forgotten. The caller must hold the lock.
+func (a *LockoutAuth) entry(m map[string]*attempts, key string, now time.Time) *attempts {
+	at, ok := m[key]
+	if !ok {
+		at = new(attempts)
+		m[key] = at
+	}
+	if a.forgotten(at, now) {
+		at.failures, at.lockedUntil = 0, time.Time{}
+	}
+
+	return at
+}
+
+// fail records a failed attempt. The caller must hold the lock.
+func (a *LockoutAuth) fail(at *attempts, threshold int, now time.Time) {
+	at.failures++
+	at.last = now
+
+	if over := at.failures - threshold; over >= 0 {
+		at.lockedUntil = now.Add(a.backoff(over))
+	}
+}
+
+func (a *LockoutAuth) backoff(over int) time.Duration {
+	delay := a.config.BaseDelay
+	for range over {
+		delay *= 2
+		if delay >= a.config.MaxDelay {
+			return a.config.MaxDelay
+		}
+	}
+
+	return min(delay, a.config.MaxDelay)
+}
+
+// remaining is how long at stays locked, or the base delay while the attempts
+// being checked could still reach the threshold. Once over it, attempts are
+// let through one at a time.
+func (a *LockoutAuth) remaining(at *attempts, threshold int, now time.Time) time.Duration {
+	if at.lockedUntil.After(now) {
+		return at.lockedUntil.Sub(now)
+	}
+
+	if at.inFlight > 0 && at.failures+at.inFlight >= threshold {
+		return a.config.BaseDelay
+	}
+
+	return 0
+}
+
+func (a *LockoutAuth) forgotten(at *attempts, now time.Time) bool {
+	return at.inFlight == 0 && at.last.Add(a.config.Window).Before(now) && !at.lockedUntil.After(now)
+}
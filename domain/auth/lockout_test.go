@@ -0,0 +1,170 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/DanyPops/logues/domain/user"
+	"golang.org/x/crypto/bcrypt"
+)
+
+func TestLockoutAuth(t *testing.T) {
+	creds := Credentials{Username: "tester", Password: "correct horse", RemoteAddr: "10.0.0.1"}
+	config := LockoutConfig{
+		UserThreshold: 3,
+		IPThreshold:   5,
+		BaseDelay:     100 * time.Millisecond,
+		MaxDelay:      5 * time.Second,
+		Window:        time.Minute,
+	}
+
+	newLockout := func(t *testing.T) *LockoutAuth {
+		ctx, cancel := context.WithCancel(context.Background())
+		t.Cleanup(cancel)
+		pa := NewPasswordAuth(NewInMemoryUserStore(), NewPasswordPolicy(), bcrypt.MinCost)
+		pa.Register(creds)
+		return NewLockoutAuth(ctx, pa, config)
+	}
+
+	wrong := func(c Credentials) Credentials {
+		c.Password = "wrong password"
+		return c
+	}
+
+	t.Run("Username locks after threshold", func(t *testing.T) {
+		a := newLockout(t)
+		for range config.UserThreshold {
+			if _, err := a.AuthenticateCredentials(wrong(creds)); !errors.Is(err, ErrInvalidCredentials) {
+				t.Fatalf("Got %v, Want %v", err, ErrInvalidCredentials)
+			}
+		}
+
+		// Locked even from another address and with the right password.
+		other := creds
+		other.RemoteAddr = "10.0.0.2"
+		var lockout LockoutError
+		if _, err := a.AuthenticateCredentials(other); !errors.As(err, &lockout) {
+			t.Fatalf("Got %v, Want LockoutError", err)
+		}
+
+		if lockout.RetryAfter <= 0 || lockout.RetryAfter > config.BaseDelay {
+			t.Errorf("Got retry after %s, Want up to %s", lockout.RetryAfter, config.BaseDelay)
+		}
+
+		time.Sleep(lockout.RetryAfter)
+		if _, err := a.AuthenticateCredentials(other); err != nil {
+			t.Errorf("Failed to authenticate after lockout: %s", err)
+		}
+	})
+
+	t.Run("Backoff doubles with every failure", func(t *testing.T) {
+		a := newLockout(t)
+		for range config.UserThreshold + 2 {
+			a.AuthenticateCredentials(wrong(creds))
+			time.Sleep(a.remaining(a.users[creds.Username], config.UserThreshold, time.Now()))
+		}
+
+		a.AuthenticateCredentials(wrong(creds))
+		var lockout LockoutError
+		if _, err := a.AuthenticateCredentials(creds); !errors.As(err, &lockout) {
+			t.Fatalf("Got %v, Want LockoutError", err)
+		}
+
+		if want := 8 * config.BaseDelay; lockout.RetryAfter <= want/2 || lockout.RetryAfter > want {
+			t.Errorf("Got retry after %s, Want about %s", lockout.RetryAfter, want)
+		}
+	})
+
+	t.Run("Address locks across usernames", func(t *testing.T) {
+		a := newLockout(t)
+		for i := range config.IPThreshold {
+			c := wrong(creds)
+			c.Username = string(rune('a'+i)) + "user"
+			a.AuthenticateCredentials(c)
+		}
+
+		if _, err := a.AuthenticateCredentials(creds); !errors.As(err, new(LockoutError)) {
+			t.Errorf("Got %v, Want LockoutError", err)
+		}
+	})
+
+	t.Run("Success forgives the username", func(t *testing.T) {
+		a := newLockout(t)
+		for range config.UserThreshold - 1 {
+			a.AuthenticateCredentials(wrong(creds))
+		}
+
+		if _, err := a.AuthenticateCredentials(creds); err != nil {
+			t.Fatal(err)
+		}
+
+		if _, ok := a.users[creds.Username]; ok {
+			t.Errorf("Failures survived a successful login")
+		}
+	})
+
+	t.Run("Concurrent guesses count toward the threshold", func(t *testing.T) {
+		a := newLockout(t)
+		a.UserAuthenticator = slowAuth{a.UserAuthenticator}
+		guessed := make(chan error, 4*config.UserThreshold)
+		var wg sync.WaitGroup
+		for range cap(guessed) {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				_, err := a.AuthenticateCredentials(wrong(creds))
+				guessed <- err
+			}()
+		}
+		wg.Wait()
+		close(guessed)
+
+		checked := 0
+		for err := range guessed {
+			if errors.Is(err, ErrInvalidCredentials) {
+				checked++
+			}
+		}
+		if checked > config.UserThreshold {
+			t.Errorf("Got %d guesses checked, Want at most %d", checked, config.UserThreshold)
+		}
+	})
+
+	t.Run("Backoff is capped", func(t *testing.T) {
+		a := newLockout(t)
+		if got := a.backoff(64); got != config.MaxDelay {
+			t.Errorf("Got %s, Want %s", got, config.MaxDelay)
+		}
+	})
+
+	t.Run("Zero config uses the defaults", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		t.Cleanup(cancel)
+		pa := NewPasswordAuth(NewInMemoryUserStore(), NewPasswordPolicy(), bcrypt.MinCost)
+		pa.Register(creds)
+		a := NewLockoutAuth(ctx, pa, LockoutConfig{})
+
+		if a.config != NewLockoutConfig() {
+			t.Errorf("Got %+v, Want %+v", a.config, NewLockoutConfig())
+		}
+
+		// The first failure mustn't lock the account.
+		a.AuthenticateCredentials(wrong(creds))
+		if _, err := a.AuthenticateCredentials(creds); err != nil {
+			t.Errorf("Failed to authenticate after one failure: %s", err)
+		}
+	})
+}
+
+// slowAuth takes its time like a costly password hash would.
+type slowAuth struct {
+	UserAuthenticator
+}
+
+func (a slowAuth) AuthenticateCredentials(creds Credentials) (user.User, error) {
+	time.Sleep(20 * time.Millisecond)
+	return a.UserAuthenticator.AuthenticateCredentials(creds)
+}
@@ -0,0 +1,142 @@
+package auth
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+
+	"github.com/DanyPops/logues/domain/audit"
+	"github.com/DanyPops/logues/domain/user"
+)
+
+// ErrForbidden marks authentication errors of callers that are known but not
+// allowed in, they are answered with 403 instead of 401.
+var ErrForbidden = errors.New("forbidden")
+
+type contextKey int
+
+const (
+	userContextKey contextKey = iota
+	tokenContextKey
+)
+
+func WithUser(ctx context.Context, u user.User) context.Context {
+	return context.WithValue(ctx, userContextKey, u)
+}
+
+func UserFromContext(ctx context.Context) (user.User, bool) {
+	u, ok := ctx.Value(userContextKey).(user.User)
+	return u, ok
+}
+
+func WithToken(ctx context.Context, t Token) context.Context {
+	return context.WithValue(ctx, tokenContextKey, t)
+}
+
+// TokenFromContext returns the token Authorize accepted.
+func TokenFromContext(ctx context.Context) (Token, bool) {
+	t, ok := ctx.Value(tokenContextKey).(Token)
+	return t, ok
+}
+
+type ErrorResponse struct {
+	Error string `json:"error"`
+	Code  string `json:"code"`
+}
+
+func WriteError(w http.ResponseWriter, status int, code, msg string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(ErrorResponse{Error: msg, Code: code})
+}
+
+// Policy decides whether an authenticated user may continue, a non nil error
+// is answered with 403.
+type Policy func(*http.Request, user.User) error
+
+// Authorize only lets requests through that carry a token ta accepts and that
+// satisfy every policy. The token and its user are stored in the request
+// context.
+func Authorize(ta TokenAuthenticator, policies ...Policy) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			var token Token
+			if err := ta.NewDecoder(r).Decode(&token); err != nil {
+				unauthorized(w, r, err)
+				return
+			}
+
+			u, err := ta.AuthenticateToken(token)
+			if errors.Is(err, ErrForbidden) {
+				forbidden(w, r, u, err)
+				return
+			}
+			if err != nil {
+				unauthorized(w, r, err)
+				return
+			}
+
+			r = r.WithContext(WithToken(WithUser(r.Context(), u), token))
+			for _, policy := range policies {
+				if err := policy(r, u); err != nil {
+					forbidden(w, r, u, err)
+					return
+				}
+			}
+
+			e := audit.RequestEvent(audit.TokenConsumed, r)
+			e.User = u.Name
+			audit.FromContext(r.Context()).Log(e)
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// The audit events and responses carry codes only, errors may contain the
+// token.
+func unauthorized(w http.ResponseWriter, r *http.Request, err error) {
+	code, msg := "invalid_token", "invalid or expired token"
+	if errors.Is(err, ErrNoToken) {
+		code, msg = "missing_token", "missing token"
+	}
+	slog.Debug("token rejected", "reason", rejection(err))
+
+	e := audit.RequestEvent(audit.TokenRejected, r)
+	e.Reason = code
+	audit.FromContext(r.Context()).Log(e)
+
+	w.Header().Set("WWW-Authenticate", `Bearer realm="logues"`)
+	WriteError(w, http.StatusUnauthorized, code, msg)
+}
+
+// rejection names why a token was refused by the error it matches, as the
+// errors wrapping it may contain the token.
+func rejection(err error) string {
+	for _, known := range []error{
+		ErrNoToken,
+		ErrOTPNotFound,
+		ErrTokenMalformed,
+		ErrTokenSignature,
+		ErrTokenExpired,
+		ErrUnknownKey,
+		ErrSessionNotFound,
+		ErrSessionRevoked,
+		ErrAPIKeyNotFound,
+	} {
+		if errors.Is(err, known) {
+			return known.Error()
+		}
+	}
+
+	return "unknown"
+}
+
+func forbidden(w http.ResponseWriter, r *http.Request, u user.User, err error) {
+	e := audit.RequestEvent(audit.TokenRejected, r)
+	e.User, e.Reason = u.Name, "forbidden"
+	audit.FromContext(r.Context()).Log(e)
+
+	WriteError(w, http.StatusForbidden, "forbidden", err.Error())
+}
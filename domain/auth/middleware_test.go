@@ -0,0 +1,141 @@
+package auth
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/DanyPops/logues/domain/audit"
+	"github.com/DanyPops/logues/domain/user"
+)
+
+func TestAuthorize(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	rm := NewOTPRetentionMap(ctx, NewOTPConfig())
+	tester := user.User{Name: "tester"}
+
+	echoUser := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		u, ok := UserFromContext(r.Context())
+		if !ok {
+			t.Errorf("No user in context")
+		}
+		json.NewEncoder(w).Encode(u)
+	})
+
+	onlyTester := func(r *http.Request, u user.User) error {
+		if u != tester {
+			return fmt.Errorf("%s isn't tester", u.Name)
+		}
+		return nil
+	}
+
+	request := func(u *user.User) *http.Request {
+		r := httptest.NewRequest("GET", "http://test.url/", nil)
+		if u != nil {
+			token, _ := rm.NewToken(*u)
+			r.Header.Set("Authorization", "Bearer "+token.Key)
+		}
+		return r
+	}
+
+	cases := map[string]struct {
+		r      *http.Request
+		status int
+		code   string
+	}{
+		"authenticated":  {request(&tester), http.StatusOK, ""},
+		"missing token":  {request(nil), http.StatusUnauthorized, "missing_token"},
+		"unknown token":  {httptest.NewRequest("GET", "http://test.url/?otp=nope", nil), http.StatusUnauthorized, "invalid_token"},
+		"policy refuses": {request(&user.User{Name: "other"}), http.StatusForbidden, "forbidden"},
+	}
+
+	for name, c := range cases {
+		t.Run(name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			Authorize(rm, onlyTester)(echoUser).ServeHTTP(w, c.r)
+
+			if w.Code != c.status {
+				t.Fatalf("Got status %d, Want %d", w.Code, c.status)
+			}
+
+			if c.status == http.StatusOK {
+				var got user.User
+				json.NewDecoder(w.Body).Decode(&got)
+				if got != tester {
+					t.Errorf("Got %v, Want %v", got, tester)
+				}
+				return
+			}
+
+			var body ErrorResponse
+			if err := json.NewDecoder(w.Body).Decode(&body); err != nil {
+				t.Fatal(err)
+			}
+
+			if body.Code != c.code {
+				t.Errorf("Got code %s, Want %s", body.Code, c.code)
+			}
+			if strings.Contains(body.Error, "nope") {
+				t.Errorf("Error %q echoes the token", body.Error)
+			}
+		})
+	}
+
+	t.Run("Debug logs leave out the token", func(t *testing.T) {
+		var logs bytes.Buffer
+		defer slog.SetDefault(slog.Default())
+		slog.SetDefault(slog.New(slog.NewTextHandler(&logs, &slog.HandlerOptions{Level: slog.LevelDebug})))
+
+		r := httptest.NewRequest("GET", "http://test.url/", nil)
+		r.Header.Set("Authorization", "Bearer s3cret-otp")
+		Authorize(rm)(echoUser).ServeHTTP(httptest.NewRecorder(), r)
+
+		if strings.Contains(logs.String(), "s3cret-otp") {
+			t.Errorf("Logs %q contain the token", logs.String())
+		}
+		if !strings.Contains(logs.String(), ErrOTPNotFound.Error()) {
+			t.Errorf("Logs %q don't say why the token was refused", logs.String())
+		}
+	})
+
+	t.Run("Audit events", func(t *testing.T) {
+		sink := audit.NewMemorySink()
+		h := audit.NewLogger(sink).Middleware(Authorize(rm, onlyTester)(echoUser))
+		h.ServeHTTP(httptest.NewRecorder(), request(&tester))
+		h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "http://test.url/?otp=secret", nil))
+
+		consumed := sink.Events(audit.TokenConsumed)
+		if len(consumed) != 1 || consumed[0].User != tester.Name {
+			t.Errorf("Unexpected consumed events %v", consumed)
+		}
+
+		rejected := sink.Events(audit.TokenRejected)
+		if len(rejected) != 1 || rejected[0].Reason != "invalid_token" {
+			t.Errorf("Unexpected rejected events %v", rejected)
+		}
+	})
+
+	t.Run("Forbidden authentication error", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		Authorize(forbiddingAuth{rm})(echoUser).ServeHTTP(w, request(&tester))
+
+		if w.Code != http.StatusForbidden {
+			t.Errorf("Got status %d, Want %d", w.Code, http.StatusForbidden)
+		}
+	})
+}
+
+type forbiddingAuth struct {
+	TokenAuthenticator
+}
+
+func (a forbiddingAuth) AuthenticateToken(t Token) (user.User, error) {
+	return user.User{}, fmt.Errorf("%w: banned", ErrForbidden)
+}
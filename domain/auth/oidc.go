@@ -0,0 +1,497 @@
+package auth
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/DanyPops/logues/domain/user"
+)
+
+const (
+	// Time a user has to finish logging in at the provider.
+	oidcLoginTTL = 10 * time.Minute
+	// The provider's keys are refetched at most this often when a token
+	// names an unknown one.
+	jwksRefreshInterval = time.Minute
+	OIDCStateCookieName = "logues_oidc_state"
+)
+
+var ErrOIDCState = errors.New("unknown or expired OIDC login")
+
+type OIDCConfig struct {
+	// Issuer is the provider's URL, its metadata is discovered under
+	// /.well-known/openid-configuration.
+	Issuer       string
+	ClientID     string
+	ClientSecret string
+	RedirectURL  string
+	Scopes       []string
+}
+
+func NewOIDCConfig(issuer, clientID, clientSecret, redirectURL string) OIDCConfig {
+	return OIDCConfig{
+		Issuer:       issuer,
+		ClientID:     clientID,
+		ClientSecret: clientSecret,
+		RedirectURL:  redirectURL,
+		Scopes:       []string{"openid", "profile", "email"},
+	}
+}
+
+// OIDCNamePrefix starts the names of users logged in with OIDC, which local
+// names can't contain, so an IdP account never becomes a local one.
+const OIDCNamePrefix = "oidc:"
+
+// OIDCName is the name of the user issuer knows as subject.
+func OIDCName(issuer, subject string) string {
+	return OIDCNamePrefix + issuer + "/" + subject
+}
+
+// IsOIDCName tells if name belongs to a user logged in with OIDC.
+func IsOIDCName(name string) bool {
+	return strings.HasPrefix(name, OIDCNamePrefix)
+}
+
+type oidcMetadata struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+}
+
+type oidcLogin struct {
+	verifier string
+	nonce    string
+	created  time.Time
+}
+
+// OIDCProvider is a UserAuthenticator signing users in with an OpenID Connect
+// provider using the authorization code flow with PKCE.
+type OIDCProvider struct {
+	config   OIDCConfig
+	client   *http.Client
+	metadata oidcMetadata
+	keys     *jwks
+	lock     *sync.Mutex
+	logins   map[string]oidcLogin
+}
+
+func NewOIDCProvider(ctx context.Context, config OIDCConfig, client *http.Client) (*OIDCProvider, error) {
+	if client == nil {
+		client = http.DefaultClient
+	}
+
+	p := &OIDCProvider{
+		config: config,
+		client: client,
+		lock:   new(sync.Mutex),
+		logins: make(map[string]oidcLogin),
+	}
+
+	discovery := strings.TrimSuffix(config.Issuer, "/") + "/.well-known/openid-configuration"
+	if err := getJSON(ctx, client, discovery, &p.metadata); err != nil {
+		return nil, fmt.Errorf("discovering OIDC provider: %w", err)
+	}
+
+	if p.metadata.Issuer != config.Issuer {
+		return nil, fmt.Errorf("OIDC provider claims issuer %s instead of %s", p.metadata.Issuer, config.Issuer)
+	}
+
+	p.keys = newJWKS(client, p.metadata.JWKSURI)
+	go p.Retention(ctx)
+	return p, nil
+}
+
+func (p *OIDCProvider) Retention(ctx context.Context) {
+	ticker := time.NewTicker(oidcLoginTTL)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			p.lock.Lock()
+			for state, login := range p.logins {
+				if login.created.Add(oidcLoginTTL).Before(time.Now()) {
+					delete(p.logins, state)
+				}
+			}
+			p.lock.Unlock()
+
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// LoginURL starts a login, the user is sent to the returned URL and comes
+// back to the redirect URL with state.
+func (p *OIDCProvider) LoginURL() (string, string, error) {
+	state, err := randomKey(16)
+	if err != nil {
+		return "", "", err
+	}
+
+	nonce, err := randomKey(16)
+	if err != nil {
+		return "", "", err
+	}
+
+	verifier, err := randomKey(32)
+	if err != nil {
+		return "", "", err
+	}
+
+	p.lock.Lock()
+	p.logins[state] = oidcLogin{verifier: verifier, nonce: nonce, created: time.Now()}
+	p.lock.Unlock()
+
+	challenge := sha256.Sum256([]byte(verifier))
+	query := url.Values{
+		"response_type":         {"code"},
+		"client_id":             {p.config.ClientID},
+		"redirect_uri":          {p.config.RedirectURL},
+		"scope":                 {strings.Join(p.config.Scopes, " ")},
+		"state":                 {state},
+		"nonce":                 {nonce},
+		"code_challenge":        {base64.RawURLEncoding.EncodeToString(challenge[:])},
+		"code_challenge_method": {"S256"},
+	}
+
+	return p.metadata.AuthorizationEndpoint + "?" + query.Encode(), state, nil
+}
+
+type oidcTokenResponse struct {
+	IDToken string `json:"id_token"`
+}
+
+type idTokenClaims struct {
+	Issuer          string   `json:"iss"`
+	Subject         string   `json:"sub"`
+	Audience        audience `json:"aud"`
+	AuthorizedParty string   `json:"azp"`
+	ExpiresAt       int64    `json:"exp"`
+	Nonce           string   `json:"nonce"`
+	// Standard profile claims, the IdP may leave any of them out.
+	Name              string `json:"name"`
+	PreferredUsername string `json:"preferred_username"`
+	Picture           string `json:"picture"`
+}
+
+// audience is either a single string or a list of them.
+type audience []string
+
+func (a *audience) UnmarshalJSON(b []byte) error {
+	var single string
+	if err := json.Unmarshal(b, &single); err == nil {
+		*a = audience{single}
+		return nil
+	}
+
+	var list []string
+	if err := json.Unmarshal(b, &list); err != nil {
+		return err
+	}
+
+	*a = list
+	return nil
+}
+
+// Exchange finishes the login state started by redeeming code for an ID
+// token and returns the profile it describes, see user.ProvisionProfile.
+func (p *OIDCProvider) Exchange(ctx context.Context, code, state string) (user.Profile, error) {
+	p.lock.Lock()
+	login, ok := p.logins[state]
+	delete(p.logins, state)
+	p.lock.Unlock()
+
+	if !ok || login.created.Add(oidcLoginTTL).Before(time.Now()) {
+		return user.Profile{}, ErrOIDCState
+	}
+
+	form := url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"redirect_uri":  {p.config.RedirectURL},
+		"client_id":     {p.config.ClientID},
+		"code_verifier": {login.verifier},
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "POST", p.metadata.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return user.Profile{}, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	if p.config.ClientSecret != "" {
+		req.SetBasicAuth(url.QueryEscape(p.config.ClientID), url.QueryEscape(p.config.ClientSecret))
+	}
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return user.Profile{}, fmt.Errorf("redeeming OIDC code: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return user.Profile{}, fmt.Errorf("%w: OIDC token endpoint answered %s", ErrInvalidCredentials, resp.Status)
+	}
+
+	var tokens oidcTokenResponse
+	if err := json.NewDecoder(resp.Body).Decode(&tokens); err != nil {
+		return user.Profile{}, fmt.Errorf("decoding OIDC token response: %w", err)
+	}
+
+	return p.verify(ctx, tokens.IDToken, login.nonce)
+}
+
+// AuthenticateCredentials is Exchange for the authorization code and state
+// the provider redirected back with.
+func (p *OIDCProvider) AuthenticateCredentials(creds Credentials) (user.User, error) {
+	profile, err := p.Exchange(context.Background(), creds.Code, creds.State)
+	return profile.User, err
+}
+
+func (p *OIDCProvider) verify(ctx context.Context, idToken, nonce string) (user.Profile, error) {
+	lookup := func(id, algorithm string) (Verifier, error) {
+		return p.keys.lookup(ctx, id, algorithm)
+	}
+
+	var payload json.RawMessage
+	if err := parseJWT(idToken, lookup, &payload); err != nil {
+		return user.Profile{}, err
+	}
+
+	var claims idTokenClaims
+	if json.Unmarshal(payload, &claims) != nil {
+		return user.Profile{}, fmt.Errorf("%w: ID token claims", ErrTokenMalformed)
+	}
+
+	switch {
+	case claims.Issuer != p.metadata.Issuer:
+		return user.Profile{}, fmt.Errorf("%w: ID token issuer %s", ErrTokenMalformed, claims.Issuer)
+
+	case !containsString(claims.Audience, p.config.ClientID):
+		return user.Profile{}, fmt.Errorf("%w: ID token audience %v", ErrTokenMalformed, claims.Audience)
+
+	case len(claims.Audience) > 1 && claims.AuthorizedParty != p.config.ClientID:
+		return user.Profile{}, fmt.Errorf("%w: ID token authorized party %s", ErrTokenMalformed, claims.AuthorizedParty)
+
+	case time.Unix(claims.ExpiresAt, 0).Add(signedTokenLeeway).Before(time.Now()):
+		return user.Profile{}, ErrTokenExpired
+
+	case claims.Nonce != nonce:
+		return user.Profile{}, fmt.Errorf("%w: ID token nonce", ErrTokenMalformed)
+
+	case claims.Subject == "":
+		return user.Profile{}, fmt.Errorf("%w: ID token without subject", ErrTokenMalformed)
+	}
+
+	// Claims like preferred_username are up to the IdP's users, only the
+	// subject is unique within the issuer. The rest only fills in the
+	// profile, the username as the handle to mention them with.
+	return user.Profile{
+		User:        user.User{Name: OIDCName(claims.Issuer, claims.Subject)},
+		Handle:      claims.PreferredUsername,
+		DisplayName: claims.Name,
+		AvatarURL:   claims.Picture,
+	}, nil
+}
+
+func containsString(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+
+	return false
+}
+
+// jwks caches a provider's JSON Web Key Set.
+type jwks struct {
+	client  *http.Client
+	uri     string
+	lock    *sync.Mutex
+	keys    map[string]Verifier
+	fetched time.Time
+}
+
+func newJWKS(client *http.Client, uri string) *jwks {
+	return &jwks{
+		client: client,
+		uri:    uri,
+		lock:   new(sync.Mutex),
+		keys:   make(map[string]Verifier),
+	}
+}
+
+// lookup refetches the key set when id is unknown, as the provider may have
+// rotated its keys.
+func (j *jwks) lookup(ctx context.Context, id, algorithm string) (Verifier, error) {
+	j.lock.Lock()
+	defer j.lock.Unlock()
+
+	key, ok := j.keys[id]
+	if !ok && time.Since(j.fetched) > jwksRefreshInterval {
+		if err := j.fetch(ctx); err != nil {
+			return nil, err
+		}
+		key, ok = j.keys[id]
+	}
+
+	if !ok || key.Algorithm() != algorithm {
+		return nil, fmt.Errorf("%w: %s", ErrUnknownKey, id)
+	}
+
+	return key, nil
+}
+
+type jsonWebKey struct {
+	KeyType   string `json:"kty"`
+	KeyID     string `json:"kid"`
+	Use       string `json:"use"`
+	Algorithm string `json:"alg"`
+	N         string `json:"n"`
+	E         string `json:"e"`
+	Curve     string `json:"crv"`
+	X         string `json:"x"`
+	Y         string `json:"y"`
+}
+
+// fetch replaces the cached keys. The caller must hold the lock.
+func (j *jwks) fetch(ctx context.Context) error {
+	var set struct {
+		Keys []jsonWebKey `json:"keys"`
+	}
+	if err := getJSON(ctx, j.client, j.uri, &set); err != nil {
+		return fmt.Errorf("fetching JWKS: %w", err)
+	}
+
+	keys := make(map[string]Verifier)
+	for _, k := range set.Keys {
+		if k.Use != "" && k.Use != "sig" {
+			continue
+		}
+
+		v, err := k.verifier()
+		if err != nil {
+			continue
+		}
+		keys[k.KeyID] = v
+	}
+
+	j.keys = keys
+	j.fetched = time.Now()
+	return nil
+}
+
+func (k jsonWebKey) verifier() (Verifier, error) {
+	dec := base64.RawURLEncoding
+	switch k.KeyType {
+	case "RSA":
+		n, err := dec.DecodeString(k.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := dec.DecodeString(k.E)
+		if err != nil {
+			return nil, err
+		}
+
+		public := &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}
+		return rsaVerifier{public}, nil
+
+	case "EC":
+		if k.Curve != "P-256" {
+			return nil, fmt.Errorf("unsupported curve %s", k.Curve)
+		}
+		x, err := dec.DecodeString(k.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := dec.DecodeString(k.Y)
+		if err != nil {
+			return nil, err
+		}
+
+		public := &ecdsa.PublicKey{Curve: elliptic.P256(), X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}
+		return ecdsaVerifier{public}, nil
+
+	case "OKP":
+		if k.Curve != "Ed25519" {
+			return nil, fmt.Errorf("unsupported curve %s", k.Curve)
+		}
+		x, err := dec.DecodeString(k.X)
+		if err != nil || len(x) != ed25519.PublicKeySize {
+			return nil, fmt.Errorf("invalid Ed25519 key %s", k.KeyID)
+		}
+
+		return NewEd25519VerifyKey(k.KeyID, x), nil
+	}
+
+	return nil, fmt.Errorf("unsupported key type %s", k.KeyType)
+}
+
+type rsaVerifier struct {
+	public *rsa.PublicKey
+}
+
+func (v rsaVerifier) Algorithm() string { return "RS256" }
+
+func (v rsaVerifier) Verify(payload, signature []byte) bool {
+	sum := sha256.Sum256(payload)
+	return rsa.VerifyPKCS1v15(v.public, crypto.SHA256, sum[:], signature) == nil
+}
+
+type ecdsaVerifier struct {
+	public *ecdsa.PublicKey
+}
+
+func (v ecdsaVerifier) Algorithm() string { return "ES256" }
+
+// Verify expects the JWS encoding of the signature, r and s as 32 bytes each.
+func (v ecdsaVerifier) Verify(payload, signature []byte) bool {
+	if len(signature) != 64 {
+		return false
+	}
+
+	sum := sha256.Sum256(payload)
+	r := new(big.Int).SetBytes(signature[:32])
+	s := new(big.Int).SetBytes(signature[32:])
+	return ecdsa.Verify(v.public, sum[:], r, s)
+}
+
+func getJSON(ctx context.Context, client *http.Client, url string, v any) error {
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("GET %s: %s", url, resp.Status)
+	}
+
+	return json.NewDecoder(resp.Body).Decode(v)
+}
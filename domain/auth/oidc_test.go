@@ -0,0 +1,242 @@
+package auth
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/DanyPops/logues/domain/user"
+)
+
+// stubIdP is a minimal OpenID provider issuing RS256 ID tokens.
+type stubIdP struct {
+	*httptest.Server
+	key    *rsa.PrivateKey
+	lock   sync.Mutex
+	codes  map[string]url.Values
+	claims func(claims map[string]any)
+}
+
+func newStubIdP(t *testing.T) *stubIdP {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	idp := &stubIdP{key: key, codes: make(map[string]url.Values), claims: func(map[string]any) {}}
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(oidcMetadata{
+			Issuer:                idp.URL,
+			AuthorizationEndpoint: idp.URL + "/authorize",
+			TokenEndpoint:         idp.URL + "/token",
+			JWKSURI:               idp.URL + "/jwks",
+		})
+	})
+	mux.HandleFunc("GET /jwks", func(w http.ResponseWriter, r *http.Request) {
+		enc := base64.RawURLEncoding
+		json.NewEncoder(w).Encode(map[string]any{"keys": []jsonWebKey{{
+			KeyType: "RSA",
+			KeyID:   "idp-1",
+			Use:     "sig",
+			N:       enc.EncodeToString(key.N.Bytes()),
+			E:       enc.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+		}}})
+	})
+	// The user is always signed in and consents.
+	mux.HandleFunc("GET /authorize", func(w http.ResponseWriter, r *http.Request) {
+		code, _ := randomKey(16)
+		idp.lock.Lock()
+		idp.codes[code] = r.URL.Query()
+		idp.lock.Unlock()
+
+		redirect, _ := url.Parse(r.URL.Query().Get("redirect_uri"))
+		redirect.RawQuery = url.Values{"code": {code}, "state": {r.URL.Query().Get("state")}}.Encode()
+		http.Redirect(w, r, redirect.String(), http.StatusFound)
+	})
+	mux.HandleFunc("POST /token", func(w http.ResponseWriter, r *http.Request) {
+		idp.lock.Lock()
+		login, ok := idp.codes[r.FormValue("code")]
+		delete(idp.codes, r.FormValue("code"))
+		idp.lock.Unlock()
+
+		id, secret, _ := r.BasicAuth()
+		challenge := sha256.Sum256([]byte(r.FormValue("code_verifier")))
+		if !ok || id != "logues" || secret != "s3cret" ||
+			base64.RawURLEncoding.EncodeToString(challenge[:]) != login.Get("code_challenge") {
+			http.Error(w, `{"error":"invalid_grant"}`, http.StatusBadRequest)
+			return
+		}
+
+		claims := map[string]any{
+			"iss":                idp.URL,
+			"sub":                "248289761001",
+			"aud":                login.Get("client_id"),
+			"exp":                time.Now().Add(time.Minute).Unix(),
+			"iat":                time.Now().Unix(),
+			"nonce":              login.Get("nonce"),
+			"preferred_username": "jane",
+			"email":              "jane@example.com",
+		}
+		idp.claims(claims)
+		json.NewEncoder(w).Encode(oidcTokenResponse{IDToken: idp.sign(t, claims)})
+	})
+
+	idp.Server = httptest.NewServer(mux)
+	t.Cleanup(idp.Close)
+	return idp
+}
+
+func (idp *stubIdP) sign(t *testing.T, claims map[string]any) string {
+	enc := base64.RawURLEncoding
+	header, _ := json.Marshal(tokenHeader{Algorithm: "RS256", KeyID: "idp-1", Type: "JWT"})
+	payload, _ := json.Marshal(claims)
+	signed := enc.EncodeToString(header) + "." + enc.EncodeToString(payload)
+
+	sum := sha256.Sum256([]byte(signed))
+	signature, err := rsa.SignPKCS1v15(rand.Reader, idp.key, crypto.SHA256, sum[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return signed + "." + enc.EncodeToString(signature)
+}
+
+// login walks through the provider like a browser and returns the callback
+// query.
+func (idp *stubIdP) login(t *testing.T, p *OIDCProvider) url.Values {
+	loginURL, _, err := p.LoginURL()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	client := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error {
+		return http.ErrUseLastResponse
+	}}
+	resp, err := client.Get(loginURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	callback, err := url.Parse(resp.Header.Get("Location"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return callback.Query()
+}
+
+func TestOIDCProvider(t *testing.T) {
+	newProvider := func(t *testing.T) (*OIDCProvider, *stubIdP) {
+		ctx, cancel := context.WithCancel(context.Background())
+		t.Cleanup(cancel)
+
+		idp := newStubIdP(t)
+		config := NewOIDCConfig(idp.URL, "logues", "s3cret", "http://logues.test/auth/oidc/callback")
+		p, err := NewOIDCProvider(ctx, config, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		return p, idp
+	}
+
+	t.Run("Login names the user after the issuer and subject", func(t *testing.T) {
+		p, idp := newProvider(t)
+		callback := idp.login(t, p)
+
+		var ua UserAuthenticator = p
+		got, err := ua.AuthenticateCredentials(Credentials{Code: callback.Get("code"), State: callback.Get("state")})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if want := (user.User{Name: "oidc:" + idp.URL + "/248289761001"}); got != want {
+			t.Errorf("Got %v, Want %v", got, want)
+		}
+		if !IsOIDCName(got.Name) || IsOIDCName("jane") {
+			t.Errorf("IsOIDCName doesn't tell OIDC names from local ones")
+		}
+	})
+
+	t.Run("Profile claims fill in the profile", func(t *testing.T) {
+		p, idp := newProvider(t)
+		idp.claims = func(c map[string]any) {
+			c["name"] = "Jane Doe"
+			c["picture"] = "https://idp.test/jane.png"
+		}
+		callback := idp.login(t, p)
+
+		got, err := p.Exchange(context.Background(), callback.Get("code"), callback.Get("state"))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		want := user.Profile{
+			User:        user.User{Name: "oidc:" + idp.URL + "/248289761001"},
+			Handle:      "jane",
+			DisplayName: "Jane Doe",
+			AvatarURL:   "https://idp.test/jane.png",
+		}
+		if got != want {
+			t.Errorf("Got %+v, Want %+v", got, want)
+		}
+	})
+
+	t.Run("State is single use", func(t *testing.T) {
+		p, idp := newProvider(t)
+		callback := idp.login(t, p)
+		p.Exchange(context.Background(), callback.Get("code"), callback.Get("state"))
+
+		_, err := p.Exchange(context.Background(), callback.Get("code"), callback.Get("state"))
+		if !errors.Is(err, ErrOIDCState) {
+			t.Errorf("Got %v, Want %v", err, ErrOIDCState)
+		}
+	})
+
+	t.Run("Invalid ID tokens are refused", func(t *testing.T) {
+		tests := map[string]func(map[string]any){
+			"audience": func(c map[string]any) { c["aud"] = "someone-else" },
+			"issuer":   func(c map[string]any) { c["iss"] = "https://evil.test" },
+			"nonce":    func(c map[string]any) { c["nonce"] = "replayed" },
+			"expired":  func(c map[string]any) { c["exp"] = time.Now().Add(-time.Hour).Unix() },
+		}
+
+		for name, tamper := range tests {
+			t.Run(name, func(t *testing.T) {
+				p, idp := newProvider(t)
+				idp.claims = tamper
+				callback := idp.login(t, p)
+
+				if _, err := p.Exchange(context.Background(), callback.Get("code"), callback.Get("state")); err == nil {
+					t.Error("Accepted tampered ID token")
+				}
+			})
+		}
+	})
+
+	t.Run("Tokens signed by other keys are refused", func(t *testing.T) {
+		p, idp := newProvider(t)
+		other, _ := rsa.GenerateKey(rand.Reader, 2048)
+		idp.key = other
+		callback := idp.login(t, p)
+
+		_, err := p.Exchange(context.Background(), callback.Get("code"), callback.Get("state"))
+		if !errors.Is(err, ErrTokenSignature) {
+			t.Errorf("Got %v, Want %v", err, ErrTokenSignature)
+		}
+	})
+}
@@ -0,0 +1,144 @@
+package auth
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+
+	"golang.org/x/crypto/bcrypt"
+
+	"github.com/DanyPops/logues/domain/user"
+)
+
+const (
+	// Shortest password accepted at registration.
+	passwordMinLength = 8
+	// bcrypt ignores everything past 72 bytes.
+	passwordMaxLength = 72
+)
+
+const (
+	DefaultPasswordCost = bcrypt.DefaultCost
+	MaxPasswordCost     = bcrypt.MaxCost
+)
+
+var (
+	ErrInvalidCredentials = errors.New("invalid username or password")
+
+	usernamePattern = regexp.MustCompile(`^[a-zA-Z0-9_.-]{3,32}$`)
+)
+
+// PolicyError reports why a username or password was refused at registration.
+type PolicyError struct {
+	Field  string
+	Reason string
+}
+
+func (e PolicyError) Error() string {
+	return fmt.Sprintf("%s %s", e.Field, e.Reason)
+}
+
+type PasswordPolicy struct {
+	MinLength int
+	MaxLength int
+}
+
+func NewPasswordPolicy() PasswordPolicy {
+	return PasswordPolicy{
+		MinLength: passwordMinLength,
+		MaxLength: passwordMaxLength,
+	}
+}
+
+func (p PasswordPolicy) Validate(creds Credentials) error {
+	if !usernamePattern.MatchString(creds.Username) {
+		return PolicyError{"username", "must be 3-32 letters, digits, '.', '_' or '-'"}
+	}
+
+	if utf8.RuneCountInString(creds.Password) < p.MinLength {
+		return PolicyError{"password", fmt.Sprintf("must be at least %d characters", p.MinLength)}
+	}
+
+	if len(creds.Password) > p.MaxLength {
+		return PolicyError{"password", fmt.Sprintf("must be at most %d bytes", p.MaxLength)}
+	}
+
+	if strings.EqualFold(creds.Password, creds.Username) {
+		return PolicyError{"password", "must differ from the username"}
+	}
+
+	return nil
+}
+
+type UserRegistrar interface {
+	Register(Credentials) (user.User, error)
+}
+
+// PasswordAuth authenticates credentials against bcrypt hashes kept in a
+// UserStore.
+type PasswordAuth struct {
+	store     UserStore
+	policy    PasswordPolicy
+	cost      int
+	dummyHash []byte
+}
+
+// NewPasswordAuth hashes passwords with the given bcrypt cost, see
+// DefaultPasswordCost.
+func NewPasswordAuth(store UserStore, policy PasswordPolicy, cost int) PasswordAuth {
+	// Unknown users are compared against a throwaway hash, so they take as
+	// long to reject as a wrong password.
+	dummy, err := bcrypt.GenerateFromPassword([]byte("logues"), cost)
+	if err != nil {
+		panic(err)
+	}
+
+	return PasswordAuth{
+		store:     store,
+		policy:    policy,
+		cost:      cost,
+		dummyHash: dummy,
+	}
+}
+
+func (a PasswordAuth) AuthenticateCredentials(creds Credentials) (user.User, error) {
+	account, err := a.store.Get(creds.Username)
+	if errors.Is(err, ErrUserNotFound) {
+		bcrypt.CompareHashAndPassword(a.dummyHash, []byte(creds.Password))
+		return user.User{}, ErrInvalidCredentials
+	}
+	if err != nil {
+		return user.User{}, err
+	}
+
+	if err := bcrypt.CompareHashAndPassword([]byte(account.PasswordHash), []byte(creds.Password)); err != nil {
+		return user.User{}, ErrInvalidCredentials
+	}
+
+	return account.User, nil
+}
+
+func (a PasswordAuth) Register(creds Credentials) (user.User, error) {
+	if err := a.policy.Validate(creds); err != nil {
+		return user.User{}, err
+	}
+
+	hash, err := bcrypt.GenerateFromPassword([]byte(creds.Password), a.cost)
+	if err != nil {
+		return user.User{}, fmt.Errorf("hashing password: %w", err)
+	}
+
+	id, err := user.NewID()
+	if err != nil {
+		return user.User{}, err
+	}
+
+	u := user.User{ID: id, Name: creds.Username}
+	if err := a.store.Add(Account{User: u, PasswordHash: string(hash)}); err != nil {
+		return user.User{}, err
+	}
+
+	return u, nil
+}
@@ -0,0 +1,100 @@
+package auth
+
+import (
+	"errors"
+	"testing"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+func TestPasswordAuth(t *testing.T) {
+	creds := Credentials{Username: "tester", Password: "correct horse"}
+
+	t.Run("Register & authenticate", func(t *testing.T) {
+		a := NewPasswordAuth(NewInMemoryUserStore(), NewPasswordPolicy(), bcrypt.MinCost)
+
+		want, err := a.Register(creds)
+		if err != nil {
+			t.Fatalf("Failed to register: %s", err)
+		}
+
+		got, err := a.AuthenticateCredentials(creds)
+		if err != nil {
+			t.Fatalf("Failed to authenticate: %s", err)
+		}
+
+		if got != want {
+			t.Errorf("Got %v, Want %v", got, want)
+		}
+	})
+
+	t.Run("Wrong password & unknown user are rejected alike", func(t *testing.T) {
+		a := NewPasswordAuth(NewInMemoryUserStore(), NewPasswordPolicy(), bcrypt.MinCost)
+		a.Register(creds)
+
+		for _, c := range []Credentials{
+			{Username: creds.Username, Password: "wrong password"},
+			{Username: "nobody", Password: creds.Password},
+		} {
+			if _, err := a.AuthenticateCredentials(c); !errors.Is(err, ErrInvalidCredentials) {
+				t.Errorf("Got %v, Want %v", err, ErrInvalidCredentials)
+			}
+		}
+	})
+
+	t.Run("Usernames are unique", func(t *testing.T) {
+		a := NewPasswordAuth(NewInMemoryUserStore(), NewPasswordPolicy(), bcrypt.MinCost)
+		a.Register(creds)
+
+		if _, err := a.Register(creds); !errors.Is(err, ErrUserExists) {
+			t.Errorf("Got %v, Want %v", err, ErrUserExists)
+		}
+	})
+
+	t.Run("Password is stored hashed", func(t *testing.T) {
+		store := NewInMemoryUserStore()
+		a := NewPasswordAuth(store, NewPasswordPolicy(), bcrypt.MinCost)
+		a.Register(creds)
+
+		account, _ := store.Get(creds.Username)
+		if account.PasswordHash == creds.Password {
+			t.Errorf("Password stored in plain text")
+		}
+	})
+}
+
+func TestPasswordPolicy(t *testing.T) {
+	policy := NewPasswordPolicy()
+	cases := map[string]struct {
+		creds Credentials
+		field string
+	}{
+		"valid":            {Credentials{Username: "tester", Password: "correct horse"}, ""},
+		"short username":   {Credentials{Username: "te", Password: "correct horse"}, "username"},
+		"invalid username": {Credentials{Username: "te ster", Password: "correct horse"}, "username"},
+		"short password":   {Credentials{Username: "tester", Password: "short"}, "password"},
+		"long password":    {Credentials{Username: "tester", Password: string(make([]byte, 73))}, "password"},
+		"password is name": {Credentials{Username: "testtester", Password: "TestTester"}, "password"},
+	}
+
+	for name, c := range cases {
+		t.Run(name, func(t *testing.T) {
+			err := policy.Validate(c.creds)
+			if c.field == "" {
+				if err != nil {
+					t.Errorf("Unexpected error: %s", err)
+				}
+				return
+			}
+
+			var perr PolicyError
+			if !errors.As(err, &perr) {
+				t.Fatalf("Got %v, Want PolicyError", err)
+			}
+
+			if perr.Field != c.field {
+				t.Errorf("Got field %s, Want %s", perr.Field, c.field)
+			}
+		})
+	}
+}
@@ -0,0 +1,361 @@
+package auth
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/DanyPops/logues/domain/user"
+)
+
+const (
+	// Lifetime of a refresh session.
+	sessionTTL = 30 * 24 * time.Hour
+	// Interval in which expired sessions are swept.
+	sessionSweepInterval = time.Minute
+	RefreshCookieName    = "logues_refresh"
+)
+
+var (
+	ErrSessionNotFound = errors.New("session not found")
+	ErrSessionRevoked  = errors.New("session revoked")
+)
+
+type Session struct {
+	ID          string
+	User        user.User
+	RefreshHash string
+	Created     time.Time
+	Expires     time.Time
+	Revoked     bool
+}
+
+type SessionStore interface {
+	Add(Session) error
+	Get(id string) (Session, error)
+	// Change updates the session with id to what f makes of it in one go,
+	// nothing changes when f fails.
+	Change(id string, f func(Session) (Session, error)) (Session, error)
+	DeleteExpired(now time.Time) error
+}
+
+type InMemorySessionStore struct {
+	lock     *sync.RWMutex
+	sessions map[string]Session
+}
+
+func NewInMemorySessionStore() InMemorySessionStore {
+	return InMemorySessionStore{
+		lock:     new(sync.RWMutex),
+		sessions: make(map[string]Session),
+	}
+}
+
+func (s InMemorySessionStore) Add(sess Session) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if _, ok := s.sessions[sess.ID]; ok {
+		return fmt.Errorf("session already exists: %s", sess.ID)
+	}
+
+	s.sessions[sess.ID] = sess
+	return nil
+}
+
+func (s InMemorySessionStore) Get(id string) (Session, error) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	sess, ok := s.sessions[id]
+	if !ok {
+		return Session{}, fmt.Errorf("%w: %s", ErrSessionNotFound, id)
+	}
+
+	return sess, nil
+}
+
+func (s InMemorySessionStore) Change(id string, f func(Session) (Session, error)) (Session, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	old, ok := s.sessions[id]
+	if !ok {
+		return Session{}, fmt.Errorf("%w: %s", ErrSessionNotFound, id)
+	}
+
+	sess, err := f(old)
+	if err != nil {
+		return old, err
+	}
+
+	s.sessions[id] = sess
+	return sess, nil
+}
+
+func (s InMemorySessionStore) DeleteExpired(now time.Time) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	for id, sess := range s.sessions {
+		if sess.Expires.Before(now) {
+			delete(s.sessions, id)
+		}
+	}
+
+	return nil
+}
+
+// Grant is handed out at login and refresh. The embedded Token connects a
+// client while the refresh token mints new ones until the session ends.
+type Grant struct {
+	Token
+	RefreshToken string    `json:"refresh_token"`
+	Expires      time.Time `json:"expires_at"`
+	User         user.User `json:"user"`
+	Session      string    `json:"-"`
+}
+
+type binding struct {
+	session string
+	expires time.Time
+}
+
+// expiringTokens are implemented by TokenAuthenticators whose tokens expire
+// on their own, their bindings are dropped then instead of with the session.
+type expiringTokens interface {
+	Expires(Token) (time.Time, error)
+}
+
+// Sessions keeps long lived refresh sessions and binds every token it issues
+// to one, so revoking a session also refuses the tokens it minted. It's a
+// TokenAuthenticator wrapping the one that actually issues the tokens.
+type Sessions struct {
+	TokenAuthenticator
+	store    SessionStore
+	ttl      time.Duration
+	lock     *sync.RWMutex
+	bindings map[string]binding
+}
+
+func NewSessions(ctx context.Context, store SessionStore, ta TokenAuthenticator, ttl time.Duration) *Sessions {
+	if ttl <= 0 {
+		ttl = sessionTTL
+	}
+
+	s := &Sessions{
+		TokenAuthenticator: ta,
+		store:              store,
+		ttl:                ttl,
+		lock:               new(sync.RWMutex),
+		bindings:           make(map[string]binding),
+	}
+
+	go s.Retention(ctx)
+	return s
+}
+
+func (s *Sessions) Retention(ctx context.Context) {
+	ticker := time.NewTicker(sessionSweepInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			now := time.Now()
+			s.store.DeleteExpired(now)
+
+			s.lock.Lock()
+			for key, b := range s.bindings {
+				if b.expires.Before(now) {
+					delete(s.bindings, key)
+				}
+			}
+			s.lock.Unlock()
+
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// Start opens a session for u and issues its first token.
+func (s *Sessions) Start(u user.User) (Grant, error) {
+	id, err := randomKey(16)
+	if err != nil {
+		return Grant{}, fmt.Errorf("generating session id: %w", err)
+	}
+
+	refresh, hash, err := newRefreshToken(id)
+	if err != nil {
+		return Grant{}, err
+	}
+
+	now := time.Now()
+	sess := Session{
+		ID:          id,
+		User:        u,
+		RefreshHash: hash,
+		Created:     now,
+		Expires:     now.Add(s.ttl),
+	}
+
+	if err := s.store.Add(sess); err != nil {
+		return Grant{}, err
+	}
+
+	return s.grant(sess, refresh)
+}
+
+// Refresh issues a new token and replaces the refresh token. Presenting a
+// refresh token that was already replaced revokes the session, as it means
+// two parties hold it.
+func (s *Sessions) Refresh(refresh string) (Grant, error) {
+	var next string
+	sess, err := s.change(refresh, func(sess Session) (Session, error) {
+		var err error
+		next, sess.RefreshHash, err = newRefreshToken(sess.ID)
+		return sess, err
+	})
+	if err != nil {
+		return Grant{}, err
+	}
+
+	return s.grant(sess, next)
+}
+
+// Revoke ends the session refresh belongs to and returns its ID.
+func (s *Sessions) Revoke(refresh string) (string, error) {
+	// Bindings stay until they expire, AuthenticateToken needs them to tell
+	// the tokens of a revoked session apart from unbound ones.
+	sess, err := s.change(refresh, func(sess Session) (Session, error) {
+		sess.Revoked = true
+		return sess, nil
+	})
+	if err != nil {
+		return "", err
+	}
+
+	return sess.ID, nil
+}
+
+// change checks refresh against its session and applies f to it, both under
+// the store's lock so only one of two parties presenting the same refresh
+// token gets through.
+func (s *Sessions) change(refresh string, f func(Session) (Session, error)) (Session, error) {
+	id, _, ok := strings.Cut(refresh, ".")
+	if !ok {
+		return Session{}, fmt.Errorf("%w: refresh token", ErrTokenMalformed)
+	}
+
+	reused := false
+	sess, err := s.store.Change(id, func(sess Session) (Session, error) {
+		if sess.Revoked {
+			return sess, fmt.Errorf("%w: %s", ErrSessionRevoked, id)
+		}
+
+		if sess.Expires.Before(time.Now()) {
+			return sess, fmt.Errorf("%w: session %s", ErrTokenExpired, id)
+		}
+
+		if subtle.ConstantTimeCompare([]byte(hashSecret(refresh)), []byte(sess.RefreshHash)) != 1 {
+			reused = true
+			sess.Revoked = true
+			return sess, nil
+		}
+
+		return f(sess)
+	})
+	if err != nil {
+		return Session{}, err
+	}
+
+	if reused {
+		return Session{}, fmt.Errorf("%w: refresh token reused", ErrSessionRevoked)
+	}
+
+	return sess, nil
+}
+
+func (s *Sessions) grant(sess Session, refresh string) (Grant, error) {
+	token, err := s.TokenAuthenticator.NewToken(sess.User)
+	if err != nil {
+		return Grant{}, err
+	}
+
+	expires := sess.Expires
+	if et, ok := s.TokenAuthenticator.(expiringTokens); ok {
+		if e, err := et.Expires(token); err == nil && e.Before(expires) {
+			expires = e
+		}
+	}
+
+	s.lock.Lock()
+	s.bindings[token.Key] = binding{session: sess.ID, expires: expires}
+	s.lock.Unlock()
+
+	return Grant{Token: token, RefreshToken: refresh, Expires: sess.Expires, User: sess.User, Session: sess.ID}, nil
+}
+
+// SessionOf returns the session t was issued for.
+func (s *Sessions) SessionOf(t Token) (string, bool) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	b, ok := s.bindings[t.Key]
+	return b.session, ok
+}
+
+// AuthenticateToken refuses tokens whose session was revoked on top of what
+// the wrapped authenticator checks.
+func (s *Sessions) AuthenticateToken(t Token) (user.User, error) {
+	u, err := s.TokenAuthenticator.AuthenticateToken(t)
+	if err != nil {
+		return user.User{}, err
+	}
+
+	id, ok := s.SessionOf(t)
+	if !ok {
+		return u, nil
+	}
+
+	sess, err := s.store.Get(id)
+	if err != nil {
+		return user.User{}, err
+	}
+
+	if sess.Revoked {
+		return user.User{}, fmt.Errorf("%w: %s", ErrSessionRevoked, id)
+	}
+
+	return u, nil
+}
+
+// RefreshCookie stores the refresh token of g where only the /auth endpoints
+// see it.
+func RefreshCookie(r *http.Request, g Grant) *http.Cookie {
+	return &http.Cookie{
+		Name:     RefreshCookieName,
+		Value:    g.RefreshToken,
+		Path:     "/auth",
+		Expires:  g.Expires,
+		HttpOnly: true,
+		Secure:   r.TLS != nil,
+		SameSite: http.SameSiteStrictMode,
+	}
+}
+
+func newRefreshToken(session string) (string, string, error) {
+	secret, err := randomKey(32)
+	if err != nil {
+		return "", "", fmt.Errorf("generating refresh token: %w", err)
+	}
+
+	refresh := session + "." + secret
+	return refresh, hashSecret(refresh), nil
+}
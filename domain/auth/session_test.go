@@ -0,0 +1,173 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/DanyPops/logues/domain/user"
+)
+
+func TestSessions(t *testing.T) {
+	u := user.User{Name: "tester"}
+	newSessions := func(t *testing.T, ttl time.Duration) *Sessions {
+		ctx, cancel := context.WithCancel(context.Background())
+		t.Cleanup(cancel)
+		keys := NewKeyRing(newTestHMACKey(t, "h1"))
+		return NewSessions(ctx, NewInMemorySessionStore(), NewSignedTokenAuth(keys, time.Minute, ""), ttl)
+	}
+
+	t.Run("Refresh mints new tokens", func(t *testing.T) {
+		s := newSessions(t, time.Hour)
+		grant, err := s.Start(u)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		refreshed, err := s.Refresh(grant.RefreshToken)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		for _, g := range []Grant{grant, refreshed} {
+			got, err := s.AuthenticateToken(g.Token)
+			if err != nil {
+				t.Fatalf("Failed to authenticate: %s", err)
+			}
+
+			if got != u {
+				t.Errorf("Got %v, Want %v", got, u)
+			}
+		}
+
+		first, _ := s.SessionOf(grant.Token)
+		second, _ := s.SessionOf(refreshed.Token)
+		if first == "" || first != second {
+			t.Errorf("Got sessions %q & %q, Want the same one", first, second)
+		}
+	})
+
+	t.Run("Bindings expire with their tokens", func(t *testing.T) {
+		s := newSessions(t, time.Hour)
+		grant, _ := s.Start(u)
+
+		b := s.bindings[grant.Key]
+		if want := time.Now().Add(time.Minute + signedTokenLeeway); b.expires.After(want) {
+			t.Errorf("Got binding until %s, Want until the token expires at %s", b.expires, want)
+		}
+	})
+
+	t.Run("Revoked session refuses its tokens", func(t *testing.T) {
+		s := newSessions(t, time.Hour)
+		grant, _ := s.Start(u)
+		other, _ := s.Start(u)
+
+		id, err := s.Revoke(grant.RefreshToken)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if want, _ := s.SessionOf(grant.Token); id != want {
+			t.Errorf("Got session %s, Want %s", id, want)
+		}
+
+		if _, err := s.AuthenticateToken(grant.Token); !errors.Is(err, ErrSessionRevoked) {
+			t.Errorf("Got %v, Want %v", err, ErrSessionRevoked)
+		}
+
+		if _, err := s.Refresh(grant.RefreshToken); !errors.Is(err, ErrSessionRevoked) {
+			t.Errorf("Got %v, Want %v", err, ErrSessionRevoked)
+		}
+
+		if _, err := s.AuthenticateToken(other.Token); err != nil {
+			t.Errorf("Other session was affected: %s", err)
+		}
+	})
+
+	t.Run("Reused refresh token revokes the session", func(t *testing.T) {
+		s := newSessions(t, time.Hour)
+		grant, _ := s.Start(u)
+		refreshed, _ := s.Refresh(grant.RefreshToken)
+
+		if _, err := s.Refresh(grant.RefreshToken); !errors.Is(err, ErrSessionRevoked) {
+			t.Errorf("Got %v, Want %v", err, ErrSessionRevoked)
+		}
+
+		if _, err := s.Refresh(refreshed.RefreshToken); !errors.Is(err, ErrSessionRevoked) {
+			t.Errorf("Got %v, Want %v", err, ErrSessionRevoked)
+		}
+	})
+
+	t.Run("Concurrent refreshes with one token revoke the session", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		t.Cleanup(cancel)
+		keys := NewKeyRing(newTestHMACKey(t, "h1"))
+		store := slowSessionStore{NewInMemorySessionStore()}
+		s := NewSessions(ctx, store, NewSignedTokenAuth(keys, time.Minute, ""), time.Hour)
+		grant, _ := s.Start(u)
+
+		var wg sync.WaitGroup
+		errs := make([]error, 2)
+		for i := range errs {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				_, errs[i] = s.Refresh(grant.RefreshToken)
+			}()
+		}
+		wg.Wait()
+
+		if (errs[0] == nil) == (errs[1] == nil) {
+			t.Fatalf("Got %v and %v, Want exactly one refresh to succeed", errs[0], errs[1])
+		}
+
+		for _, err := range errs {
+			if err != nil && !errors.Is(err, ErrSessionRevoked) {
+				t.Errorf("Got %v, Want %v", err, ErrSessionRevoked)
+			}
+		}
+
+		if _, err := s.AuthenticateToken(grant.Token); !errors.Is(err, ErrSessionRevoked) {
+			t.Errorf("Got %v, Want %v", err, ErrSessionRevoked)
+		}
+	})
+
+	t.Run("Expired session can't be refreshed", func(t *testing.T) {
+		s := newSessions(t, time.Millisecond)
+		grant, _ := s.Start(u)
+		time.Sleep(5 * time.Millisecond)
+
+		if _, err := s.Refresh(grant.RefreshToken); !errors.Is(err, ErrTokenExpired) {
+			t.Errorf("Got %v, Want %v", err, ErrTokenExpired)
+		}
+	})
+
+	t.Run("Unknown refresh tokens are refused", func(t *testing.T) {
+		s := newSessions(t, time.Hour)
+		for _, refresh := range []string{"", "nodot", "unknown.secret"} {
+			if _, err := s.Refresh(refresh); err == nil {
+				t.Errorf("Refreshed %q", refresh)
+			}
+		}
+	})
+}
+
+// slowSessionStore takes its time like a remote store would.
+type slowSessionStore struct {
+	InMemorySessionStore
+}
+
+func (s slowSessionStore) Get(id string) (Session, error) {
+	sess, err := s.InMemorySessionStore.Get(id)
+	time.Sleep(20 * time.Millisecond)
+	return sess, err
+}
+
+func (s slowSessionStore) Change(id string, f func(Session) (Session, error)) (Session, error) {
+	return s.InMemorySessionStore.Change(id, func(sess Session) (Session, error) {
+		time.Sleep(20 * time.Millisecond)
+		return f(sess)
+	})
+}
@@ -0,0 +1,383 @@
+package auth
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/DanyPops/logues/domain/user"
+)
+
+const (
+	AlgorithmHS256 = "HS256"
+	AlgorithmEdDSA = "EdDSA"
+
+	// Lifetime of a signed token.
+	signedTokenTTL = 5 * time.Minute
+	// Clock difference tolerated between instances.
+	signedTokenLeeway = 30 * time.Second
+	// Shortest HMAC secret accepted, in bytes.
+	hmacMinSecretLength = 32
+)
+
+var (
+	ErrTokenMalformed = errors.New("malformed token")
+	ErrTokenSignature = errors.New("invalid token signature")
+	ErrTokenExpired   = errors.New("token expired")
+	ErrUnknownKey     = errors.New("unknown signing key")
+)
+
+type Verifier interface {
+	Algorithm() string
+	Verify(payload, signature []byte) bool
+}
+
+type SigningKey interface {
+	Verifier
+	ID() string
+	// Sign fails for keys that may only verify.
+	Sign(payload []byte) ([]byte, error)
+}
+
+type HMACKey struct {
+	id     string
+	secret []byte
+}
+
+func NewHMACKey(id string, secret []byte) (HMACKey, error) {
+	if len(secret) < hmacMinSecretLength {
+		return HMACKey{}, fmt.Errorf("HMAC secret of key %s is shorter than %d bytes", id, hmacMinSecretLength)
+	}
+
+	return HMACKey{id: id, secret: secret}, nil
+}
+
+func (k HMACKey) ID() string        { return k.id }
+func (k HMACKey) Algorithm() string { return AlgorithmHS256 }
+
+func (k HMACKey) Sign(payload []byte) ([]byte, error) {
+	mac := hmac.New(sha256.New, k.secret)
+	mac.Write(payload)
+	return mac.Sum(nil), nil
+}
+
+func (k HMACKey) Verify(payload, signature []byte) bool {
+	want, _ := k.Sign(payload)
+	return hmac.Equal(want, signature)
+}
+
+type Ed25519Key struct {
+	id      string
+	private ed25519.PrivateKey
+	public  ed25519.PublicKey
+}
+
+func NewEd25519Key(id string, private ed25519.PrivateKey) Ed25519Key {
+	return Ed25519Key{
+		id:      id,
+		private: private,
+		public:  private.Public().(ed25519.PublicKey),
+	}
+}
+
+// NewEd25519VerifyKey lets an instance accept tokens it can't issue itself.
+func NewEd25519VerifyKey(id string, public ed25519.PublicKey) Ed25519Key {
+	return Ed25519Key{id: id, public: public}
+}
+
+func (k Ed25519Key) ID() string        { return k.id }
+func (k Ed25519Key) Algorithm() string { return AlgorithmEdDSA }
+
+func (k Ed25519Key) Sign(payload []byte) ([]byte, error) {
+	if k.private == nil {
+		return nil, fmt.Errorf("key %s is verify only", k.id)
+	}
+
+	return ed25519.Sign(k.private, payload), nil
+}
+
+func (k Ed25519Key) Verify(payload, signature []byte) bool {
+	return ed25519.Verify(k.public, payload, signature)
+}
+
+// ParseSigningKey reads a key from "<id>:<type>:<base64 material>", where type
+// is hmac, ed25519 (32 byte seed) or ed25519-public.
+func ParseSigningKey(spec string) (SigningKey, error) {
+	id, rest, ok := strings.Cut(spec, ":")
+	kind, material, ok2 := strings.Cut(rest, ":")
+	if !ok || !ok2 || id == "" {
+		return nil, fmt.Errorf("key spec must be <id>:<type>:<base64>")
+	}
+
+	b, err := base64.StdEncoding.DecodeString(material)
+	if err != nil {
+		return nil, fmt.Errorf("decoding key %s: %w", id, err)
+	}
+
+	switch kind {
+	case "hmac":
+		return NewHMACKey(id, b)
+
+	case "ed25519":
+		if len(b) != ed25519.SeedSize {
+			return nil, fmt.Errorf("ed25519 key %s must be a %d byte seed", id, ed25519.SeedSize)
+		}
+		return NewEd25519Key(id, ed25519.NewKeyFromSeed(b)), nil
+
+	case "ed25519-public":
+		if len(b) != ed25519.PublicKeySize {
+			return nil, fmt.Errorf("ed25519 public key %s must be %d bytes", id, ed25519.PublicKeySize)
+		}
+		return NewEd25519VerifyKey(id, b), nil
+	}
+
+	return nil, fmt.Errorf("unknown key type %s", kind)
+}
+
+// KeyRing signs with a single key and verifies with every key it holds, so
+// tokens issued before a rotation stay valid until their key is retired.
+type KeyRing struct {
+	lock    *sync.RWMutex
+	signing SigningKey
+	keys    map[string]SigningKey
+}
+
+func NewKeyRing(signing SigningKey, verification ...SigningKey) *KeyRing {
+	k := &KeyRing{
+		lock:    new(sync.RWMutex),
+		signing: signing,
+		keys:    make(map[string]SigningKey),
+	}
+
+	k.keys[signing.ID()] = signing
+	for _, key := range verification {
+		k.keys[key.ID()] = key
+	}
+
+	return k
+}
+
+// Rotate signs new tokens with next while keeping the previous key around
+// for verification.
+func (k *KeyRing) Rotate(next SigningKey) {
+	k.lock.Lock()
+	defer k.lock.Unlock()
+
+	k.keys[next.ID()] = next
+	k.signing = next
+}
+
+func (k *KeyRing) Retire(id string) error {
+	k.lock.Lock()
+	defer k.lock.Unlock()
+
+	if k.signing.ID() == id {
+		return fmt.Errorf("can't retire signing key %s", id)
+	}
+
+	if _, ok := k.keys[id]; !ok {
+		return fmt.Errorf("%w: %s", ErrUnknownKey, id)
+	}
+
+	delete(k.keys, id)
+	return nil
+}
+
+func (k *KeyRing) Signer() SigningKey {
+	k.lock.RLock()
+	defer k.lock.RUnlock()
+	return k.signing
+}
+
+func (k *KeyRing) Lookup(id, algorithm string) (Verifier, error) {
+	k.lock.RLock()
+	defer k.lock.RUnlock()
+
+	key, ok := k.keys[id]
+	if !ok || key.Algorithm() != algorithm {
+		return nil, fmt.Errorf("%w: %s", ErrUnknownKey, id)
+	}
+
+	return key, nil
+}
+
+type tokenHeader struct {
+	Algorithm string `json:"alg"`
+	KeyID     string `json:"kid"`
+	Type      string `json:"typ"`
+}
+
+// TokenClaims is the payload of a signed token.
+type TokenClaims struct {
+	ID        string    `json:"jti"`
+	Issuer    string    `json:"iss,omitempty"`
+	Subject   string    `json:"sub"`
+	User      user.User `json:"usr"`
+	IssuedAt  int64     `json:"iat"`
+	ExpiresAt int64     `json:"exp"`
+}
+
+// signJWT encodes claims as a compact JSON Web Token.
+func signJWT(key SigningKey, claims any) (string, error) {
+	header, err := json.Marshal(tokenHeader{Algorithm: key.Algorithm(), KeyID: key.ID(), Type: "JWT"})
+	if err != nil {
+		return "", err
+	}
+
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		return "", err
+	}
+
+	enc := base64.RawURLEncoding
+	signed := enc.EncodeToString(header) + "." + enc.EncodeToString(payload)
+
+	signature, err := key.Sign([]byte(signed))
+	if err != nil {
+		return "", err
+	}
+
+	return signed + "." + enc.EncodeToString(signature), nil
+}
+
+// parseJWT verifies the signature of token with the key lookup resolves and
+// decodes its payload into claims.
+func parseJWT(token string, lookup func(id, algorithm string) (Verifier, error), claims any) error {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return ErrTokenMalformed
+	}
+
+	enc := base64.RawURLEncoding
+	rawHeader, err := enc.DecodeString(parts[0])
+	if err != nil {
+		return fmt.Errorf("%w: header: %s", ErrTokenMalformed, err)
+	}
+
+	var header tokenHeader
+	if err := json.Unmarshal(rawHeader, &header); err != nil {
+		return fmt.Errorf("%w: header: %s", ErrTokenMalformed, err)
+	}
+
+	signature, err := enc.DecodeString(parts[2])
+	if err != nil {
+		return fmt.Errorf("%w: signature: %s", ErrTokenMalformed, err)
+	}
+
+	key, err := lookup(header.KeyID, header.Algorithm)
+	if err != nil {
+		return err
+	}
+
+	if !key.Verify([]byte(parts[0]+"."+parts[1]), signature) {
+		return ErrTokenSignature
+	}
+
+	payload, err := enc.DecodeString(parts[1])
+	if err != nil {
+		return fmt.Errorf("%w: payload: %s", ErrTokenMalformed, err)
+	}
+
+	dec := json.NewDecoder(bytes.NewReader(payload))
+	if err := dec.Decode(claims); err != nil {
+		return fmt.Errorf("%w: payload: %s", ErrTokenMalformed, err)
+	}
+
+	return nil
+}
+
+// SignedTokenAuth issues self contained tokens any instance sharing the key
+// ring can verify. Unlike OTPs they may be used until they expire.
+type SignedTokenAuth struct {
+	keys   *KeyRing
+	ttl    time.Duration
+	issuer string
+}
+
+func NewSignedTokenAuth(keys *KeyRing, ttl time.Duration, issuer string) SignedTokenAuth {
+	if ttl <= 0 {
+		ttl = signedTokenTTL
+	}
+
+	return SignedTokenAuth{
+		keys:   keys,
+		ttl:    ttl,
+		issuer: issuer,
+	}
+}
+
+// NewDecoder reads the same places as the OTP decoder, so clients don't
+// depend on the configured authenticator.
+func (a SignedTokenAuth) NewDecoder(r *http.Request) TokenDecoder {
+	return NewDecoderChain(r)
+}
+
+func (a SignedTokenAuth) NewToken(u user.User) (Token, error) {
+	id, err := randomKey(16)
+	if err != nil {
+		return Token{}, fmt.Errorf("generating token id: %w", err)
+	}
+
+	now := time.Now()
+	claims := TokenClaims{
+		ID:        id,
+		Issuer:    a.issuer,
+		Subject:   u.Name,
+		User:      u,
+		IssuedAt:  now.Unix(),
+		ExpiresAt: now.Add(a.ttl).Unix(),
+	}
+
+	key, err := signJWT(a.keys.Signer(), claims)
+	if err != nil {
+		return Token{}, fmt.Errorf("signing token: %w", err)
+	}
+
+	return Token{Key: key}, nil
+}
+
+func (a SignedTokenAuth) AuthenticateToken(t Token) (user.User, error) {
+	claims, err := a.Claims(t)
+	if err != nil {
+		return user.User{}, err
+	}
+
+	return claims.User, nil
+}
+
+// Expires returns when t is refused from on.
+func (a SignedTokenAuth) Expires(t Token) (time.Time, error) {
+	claims, err := a.Claims(t)
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	return time.Unix(claims.ExpiresAt, 0).Add(signedTokenLeeway), nil
+}
+
+// Claims verifies t and returns everything it holds.
+func (a SignedTokenAuth) Claims(t Token) (TokenClaims, error) {
+	var claims TokenClaims
+	if err := parseJWT(t.Key, a.keys.Lookup, &claims); err != nil {
+		return TokenClaims{}, err
+	}
+
+	if a.issuer != "" && claims.Issuer != a.issuer {
+		return TokenClaims{}, fmt.Errorf("%w: issuer %s", ErrTokenMalformed, claims.Issuer)
+	}
+
+	if time.Unix(claims.ExpiresAt, 0).Add(signedTokenLeeway).Before(time.Now()) {
+		return TokenClaims{}, ErrTokenExpired
+	}
+
+	return claims, nil
+}
@@ -0,0 +1,187 @@
+package auth
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"encoding/base64"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DanyPops/logues/domain/user"
+)
+
+func newTestHMACKey(t *testing.T, id string) HMACKey {
+	key, err := NewHMACKey(id, bytes.Repeat([]byte(id), 32))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return key
+}
+
+func newTestEd25519Key(id string) Ed25519Key {
+	return NewEd25519Key(id, ed25519.NewKeyFromSeed(bytes.Repeat([]byte{1}, ed25519.SeedSize)))
+}
+
+func TestSignedTokenAuth(t *testing.T) {
+	want := user.User{Name: "tester"}
+
+	for name, key := range map[string]SigningKey{
+		"HMAC":    newTestHMACKey(t, "h1"),
+		"Ed25519": newTestEd25519Key("e1"),
+	} {
+		t.Run(name+" Request Token & Authenticate", func(t *testing.T) {
+			a := NewSignedTokenAuth(NewKeyRing(key), time.Minute, "logues")
+
+			token, err := a.NewToken(want)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			for range 2 {
+				got, err := a.AuthenticateToken(token)
+				if err != nil {
+					t.Fatalf("Failed to authenticate: %s", err)
+				}
+
+				if got != want {
+					t.Errorf("Got %v, Want %v", got, want)
+				}
+			}
+		})
+	}
+
+	t.Run("Tampered token is rejected", func(t *testing.T) {
+		a := NewSignedTokenAuth(NewKeyRing(newTestHMACKey(t, "h1")), time.Minute, "logues")
+		token, _ := a.NewToken(want)
+		other, _ := a.NewToken(user.User{Name: "admin"})
+
+		parts := strings.Split(token.Key, ".")
+		forged := strings.Split(other.Key, ".")
+		parts[1] = forged[1]
+
+		_, err := a.AuthenticateToken(Token{Key: strings.Join(parts, ".")})
+		if !errors.Is(err, ErrTokenSignature) {
+			t.Errorf("Got %v, Want %v", err, ErrTokenSignature)
+		}
+	})
+
+	t.Run("Expired token is rejected", func(t *testing.T) {
+		key := newTestHMACKey(t, "h1")
+		a := NewSignedTokenAuth(NewKeyRing(key), time.Minute, "logues")
+		expired, _ := signJWT(key, TokenClaims{
+			Issuer:    "logues",
+			User:      want,
+			ExpiresAt: time.Now().Add(-time.Hour).Unix(),
+		})
+
+		if _, err := a.AuthenticateToken(Token{Key: expired}); !errors.Is(err, ErrTokenExpired) {
+			t.Errorf("Got %v, Want %v", err, ErrTokenExpired)
+		}
+	})
+
+	t.Run("Foreign issuer is rejected", func(t *testing.T) {
+		key := newTestHMACKey(t, "h1")
+		token, _ := NewSignedTokenAuth(NewKeyRing(key), time.Minute, "other").NewToken(want)
+
+		a := NewSignedTokenAuth(NewKeyRing(key), time.Minute, "logues")
+		if _, err := a.AuthenticateToken(token); !errors.Is(err, ErrTokenMalformed) {
+			t.Errorf("Got %v, Want %v", err, ErrTokenMalformed)
+		}
+	})
+
+	t.Run("Algorithm must match the key", func(t *testing.T) {
+		ed := newTestEd25519Key("k1")
+		a := NewSignedTokenAuth(NewKeyRing(ed), time.Minute, "")
+
+		// An HMAC token keyed with the public key must not pass as EdDSA.
+		forger, _ := NewHMACKey("k1", append(ed.public, ed.public...))
+		forged, _ := signJWT(forger, TokenClaims{User: want, ExpiresAt: time.Now().Add(time.Hour).Unix()})
+
+		if _, err := a.AuthenticateToken(Token{Key: forged}); !errors.Is(err, ErrUnknownKey) {
+			t.Errorf("Got %v, Want %v", err, ErrUnknownKey)
+		}
+	})
+
+	t.Run("Rotated keys verify until retired", func(t *testing.T) {
+		ring := NewKeyRing(newTestHMACKey(t, "old"))
+		a := NewSignedTokenAuth(ring, time.Minute, "logues")
+		old, _ := a.NewToken(want)
+
+		ring.Rotate(newTestEd25519Key("new"))
+		fresh, _ := a.NewToken(want)
+
+		for _, token := range []Token{old, fresh} {
+			if _, err := a.AuthenticateToken(token); err != nil {
+				t.Errorf("Failed to authenticate: %s", err)
+			}
+		}
+
+		if err := ring.Retire("new"); err == nil {
+			t.Errorf("Retired the signing key")
+		}
+
+		if err := ring.Retire("old"); err != nil {
+			t.Fatal(err)
+		}
+
+		if _, err := a.AuthenticateToken(old); !errors.Is(err, ErrUnknownKey) {
+			t.Errorf("Got %v, Want %v", err, ErrUnknownKey)
+		}
+	})
+
+	t.Run("Verify only instance accepts tokens", func(t *testing.T) {
+		signer := newTestEd25519Key("e1")
+		token, _ := NewSignedTokenAuth(NewKeyRing(signer), time.Minute, "logues").NewToken(want)
+
+		verifier := NewSignedTokenAuth(NewKeyRing(NewEd25519VerifyKey("e1", signer.public)), time.Minute, "logues")
+		if _, err := verifier.AuthenticateToken(token); err != nil {
+			t.Errorf("Failed to authenticate: %s", err)
+		}
+
+		if _, err := verifier.NewToken(want); err == nil {
+			t.Errorf("Verify only key signed a token")
+		}
+	})
+}
+
+func TestParseSigningKey(t *testing.T) {
+	b64 := func(n int) string {
+		return base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{7}, n))
+	}
+
+	cases := map[string]struct {
+		spec      string
+		algorithm string
+	}{
+		"hmac":           {"k1:hmac:" + b64(32), AlgorithmHS256},
+		"ed25519":        {"k1:ed25519:" + b64(32), AlgorithmEdDSA},
+		"ed25519-public": {"k1:ed25519-public:" + b64(32), AlgorithmEdDSA},
+		"short hmac":     {"k1:hmac:" + b64(8), ""},
+		"unknown type":   {"k1:rsa:" + b64(32), ""},
+		"missing id":     {":hmac:" + b64(32), ""},
+		"bad base64":     {"k1:hmac:%%%", ""},
+	}
+
+	for name, c := range cases {
+		t.Run(name, func(t *testing.T) {
+			key, err := ParseSigningKey(c.spec)
+			if c.algorithm == "" {
+				if err == nil {
+					t.Errorf("Expected error for %s", c.spec)
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if key.ID() != "k1" || key.Algorithm() != c.algorithm {
+				t.Errorf("Got %s/%s, Want k1/%s", key.ID(), key.Algorithm(), c.algorithm)
+			}
+		})
+	}
+}
@@ -0,0 +1,152 @@
+package auth
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/DanyPops/logues/domain/user"
+)
+
+var (
+	ErrUserNotFound = errors.New("user not found")
+	ErrUserExists   = errors.New("username already taken")
+)
+
+type Account struct {
+	User         user.User `json:"user"`
+	PasswordHash string    `json:"password_hash"`
+}
+
+type UserStore interface {
+	Get(username string) (Account, error)
+	Add(Account) error
+}
+
+type InMemoryUserStore struct {
+	lock     *sync.RWMutex
+	accounts map[string]Account
+}
+
+func NewInMemoryUserStore() InMemoryUserStore {
+	return InMemoryUserStore{
+		lock:     new(sync.RWMutex),
+		accounts: make(map[string]Account),
+	}
+}
+
+func (s InMemoryUserStore) Get(username string) (Account, error) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	a, ok := s.accounts[username]
+	if !ok {
+		return Account{}, fmt.Errorf("%w: %s", ErrUserNotFound, username)
+	}
+
+	return a, nil
+}
+
+func (s InMemoryUserStore) Add(a Account) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	return s.add(a)
+}
+
+func (s InMemoryUserStore) add(a Account) error {
+	name := a.User.Name
+	if _, ok := s.accounts[name]; ok {
+		return fmt.Errorf("%w: %s", ErrUserExists, name)
+	}
+
+	s.accounts[name] = a
+	return nil
+}
+
+// FileUserStore keeps its accounts in memory and rewrites the whole JSON file
+// on every change.
+type FileUserStore struct {
+	InMemoryUserStore
+	path string
+}
+
+func NewFileUserStore(path string) (*FileUserStore, error) {
+	s := &FileUserStore{
+		InMemoryUserStore: NewInMemoryUserStore(),
+		path:              path,
+	}
+
+	f, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("opening user file: %w", err)
+	}
+	defer f.Close()
+
+	var accounts []Account
+	if err := json.NewDecoder(f).Decode(&accounts); err != nil {
+		return nil, fmt.Errorf("decoding user file %s: %w", path, err)
+	}
+
+	for _, a := range accounts {
+		if err := s.add(a); err != nil {
+			return nil, fmt.Errorf("loading user file %s: %w", path, err)
+		}
+	}
+
+	return s, nil
+}
+
+func (s *FileUserStore) Add(a Account) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if err := s.add(a); err != nil {
+		return err
+	}
+
+	if err := s.persist(); err != nil {
+		delete(s.accounts, a.User.Name)
+		return err
+	}
+
+	return nil
+}
+
+// persist writes to a temporary file first so a crash never leaves a
+// truncated user file behind. The caller must hold the lock.
+func (s *FileUserStore) persist() error {
+	accounts := make([]Account, 0, len(s.accounts))
+	for _, a := range s.accounts {
+		accounts = append(accounts, a)
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(s.path), filepath.Base(s.path)+".*")
+	if err != nil {
+		return fmt.Errorf("creating user file: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	enc := json.NewEncoder(tmp)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(accounts); err != nil {
+		tmp.Close()
+		return fmt.Errorf("encoding user file: %w", err)
+	}
+
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("writing user file: %w", err)
+	}
+
+	if err := os.Rename(tmp.Name(), s.path); err != nil {
+		return fmt.Errorf("replacing user file: %w", err)
+	}
+
+	return nil
+}
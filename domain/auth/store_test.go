@@ -0,0 +1,50 @@
+package auth
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+
+	"github.com/DanyPops/logues/domain/user"
+)
+
+func TestFileUserStore(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "users.json")
+	account := Account{User: user.User{Name: "tester"}, PasswordHash: "hash"}
+
+	t.Run("Missing file starts empty", func(t *testing.T) {
+		s, err := NewFileUserStore(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if _, err := s.Get(account.User.Name); !errors.Is(err, ErrUserNotFound) {
+			t.Errorf("Got %v, Want %v", err, ErrUserNotFound)
+		}
+	})
+
+	t.Run("Accounts survive a reload", func(t *testing.T) {
+		s, _ := NewFileUserStore(path)
+		if err := s.Add(account); err != nil {
+			t.Fatal(err)
+		}
+
+		reloaded, err := NewFileUserStore(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		got, err := reloaded.Get(account.User.Name)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if got != account {
+			t.Errorf("Got %v, Want %v", got, account)
+		}
+
+		if err := reloaded.Add(account); !errors.Is(err, ErrUserExists) {
+			t.Errorf("Got %v, Want %v", err, ErrUserExists)
+		}
+	})
+}
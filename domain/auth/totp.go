@@ -0,0 +1,332 @@
+package auth
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/DanyPops/logues/domain/user"
+)
+
+const (
+	totpDigits = 6
+	totpPeriod = 30 * time.Second
+	// Time steps accepted either side of the current one, for clock drift.
+	totpSkew = 1
+	// RFC 4226 recommends 160 bit secrets.
+	totpSecretLength  = 20
+	recoveryCodeCount = 10
+	// Random bytes behind a recovery code, 80 bits make 16 base32 characters.
+	recoveryCodeLength = 10
+)
+
+var (
+	ErrSecondFactorRequired = errors.New("second factor required")
+	ErrTOTPNotEnrolled      = errors.New("TOTP not enrolled")
+	ErrTOTPEnrolled         = errors.New("TOTP already enrolled")
+
+	totpEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+)
+
+type TOTPConfig struct {
+	Issuer    string
+	Digits    int
+	Period    time.Duration
+	Skew      int
+	Algorithm string
+}
+
+func NewTOTPConfig(issuer string) TOTPConfig {
+	return TOTPConfig{
+		Issuer:    issuer,
+		Digits:    totpDigits,
+		Period:    totpPeriod,
+		Skew:      totpSkew,
+		Algorithm: "SHA1",
+	}
+}
+
+func (c TOTPConfig) hash() (func() hash.Hash, error) {
+	switch c.Algorithm {
+	case "SHA1":
+		return sha1.New, nil
+	case "SHA256":
+		return sha256.New, nil
+	case "SHA512":
+		return sha512.New, nil
+	}
+
+	return nil, fmt.Errorf("unknown TOTP algorithm %s", c.Algorithm)
+}
+
+func (c TOTPConfig) step(t time.Time) int64 {
+	return t.Unix() / int64(c.Period/time.Second)
+}
+
+// TOTP returns the RFC 6238 code of secret at t.
+func TOTP(secret []byte, t time.Time, c TOTPConfig) (string, error) {
+	h, err := c.hash()
+	if err != nil {
+		return "", err
+	}
+
+	return hotp(secret, c.step(t), c.Digits, h), nil
+}
+
+// hotp implements RFC 4226.
+func hotp(secret []byte, counter int64, digits int, h func() hash.Hash) string {
+	mac := hmac.New(h, secret)
+	binary.Write(mac, binary.BigEndian, counter)
+	sum := mac.Sum(nil)
+
+	offset := sum[len(sum)-1] & 0x0f
+	code := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+
+	mod := uint32(1)
+	for range digits {
+		mod *= 10
+	}
+
+	return fmt.Sprintf("%0*d", digits, code%mod)
+}
+
+type TOTPRecord struct {
+	Username       string
+	Secret         []byte
+	Active         bool
+	RecoveryHashes []string
+	// LastStep is the time step of the last accepted code, a code is never
+	// accepted twice.
+	LastStep int64
+}
+
+type TOTPStore interface {
+	Get(username string) (TOTPRecord, error)
+	Put(TOTPRecord) error
+}
+
+type InMemoryTOTPStore struct {
+	lock    *sync.RWMutex
+	records map[string]TOTPRecord
+}
+
+func NewInMemoryTOTPStore() InMemoryTOTPStore {
+	return InMemoryTOTPStore{
+		lock:    new(sync.RWMutex),
+		records: make(map[string]TOTPRecord),
+	}
+}
+
+func (s InMemoryTOTPStore) Get(username string) (TOTPRecord, error) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	r, ok := s.records[username]
+	if !ok {
+		return TOTPRecord{}, fmt.Errorf("%w: %s", ErrTOTPNotEnrolled, username)
+	}
+
+	return r, nil
+}
+
+func (s InMemoryTOTPStore) Put(r TOTPRecord) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.records[r.Username] = r
+	return nil
+}
+
+// TOTPEnrollment is shown to the user once, neither the secret nor the
+// recovery codes can be retrieved later.
+type TOTPEnrollment struct {
+	Secret        string   `json:"secret"`
+	URI           string   `json:"uri"`
+	RecoveryCodes []string `json:"recovery_codes"`
+}
+
+// TOTPAuth asks users who enrolled a TOTP secret for a code, or one of their
+// recovery codes, after the wrapped UserAuthenticator accepted them.
+type TOTPAuth struct {
+	UserAuthenticator
+	store  TOTPStore
+	config TOTPConfig
+	lock   *sync.Mutex
+}
+
+func NewTOTPAuth(ua UserAuthenticator, store TOTPStore, config TOTPConfig) *TOTPAuth {
+	return &TOTPAuth{
+		UserAuthenticator: ua,
+		store:             store,
+		config:            config,
+		lock:              new(sync.Mutex),
+	}
+}
+
+func (a *TOTPAuth) AuthenticateCredentials(creds Credentials) (user.User, error) {
+	u, err := a.UserAuthenticator.AuthenticateCredentials(creds)
+	if err != nil {
+		return user.User{}, err
+	}
+
+	a.lock.Lock()
+	defer a.lock.Unlock()
+
+	record, err := a.store.Get(u.Name)
+	if errors.Is(err, ErrTOTPNotEnrolled) || (err == nil && !record.Active) {
+		return u, nil
+	}
+	if err != nil {
+		return user.User{}, err
+	}
+
+	if creds.Code == "" {
+		return user.User{}, ErrSecondFactorRequired
+	}
+
+	if err := a.verify(&record, creds.Code, time.Now()); err != nil {
+		return user.User{}, err
+	}
+
+	return u, a.store.Put(record)
+}
+
+// Enroll creates a new secret for u that only takes effect once Confirm saw
+// a code generated from it.
+func (a *TOTPAuth) Enroll(u user.User) (TOTPEnrollment, error) {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+
+	if record, err := a.store.Get(u.Name); err == nil && record.Active {
+		return TOTPEnrollment{}, ErrTOTPEnrolled
+	}
+
+	secret := make([]byte, totpSecretLength)
+	if _, err := rand.Read(secret); err != nil {
+		return TOTPEnrollment{}, fmt.Errorf("generating TOTP secret: %w", err)
+	}
+
+	codes := make([]string, recoveryCodeCount)
+	hashes := make([]string, recoveryCodeCount)
+	for i := range codes {
+		b := make([]byte, recoveryCodeLength)
+		if _, err := rand.Read(b); err != nil {
+			return TOTPEnrollment{}, fmt.Errorf("generating recovery code: %w", err)
+		}
+
+		code := strings.ToLower(totpEncoding.EncodeToString(b))
+		codes[i] = code[0:4] + "-" + code[4:8] + "-" + code[8:12] + "-" + code[12:16]
+		hashes[i] = hashRecoveryCode(code)
+	}
+
+	err := a.store.Put(TOTPRecord{
+		Username:       u.Name,
+		Secret:         secret,
+		RecoveryHashes: hashes,
+	})
+	if err != nil {
+		return TOTPEnrollment{}, err
+	}
+
+	encoded := totpEncoding.EncodeToString(secret)
+	return TOTPEnrollment{
+		Secret:        encoded,
+		URI:           a.uri(u, encoded),
+		RecoveryCodes: codes,
+	}, nil
+}
+
+// Confirm activates the pending enrollment of u.
+func (a *TOTPAuth) Confirm(u user.User, code string) error {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+
+	record, err := a.store.Get(u.Name)
+	if err != nil {
+		return err
+	}
+
+	if record.Active {
+		return ErrTOTPEnrolled
+	}
+
+	if !a.verifyTOTP(&record, code, time.Now()) {
+		return fmt.Errorf("%w: TOTP code", ErrInvalidCredentials)
+	}
+
+	record.Active = true
+	return a.store.Put(record)
+}
+
+// verify checks code against the TOTP secret and then against the unused
+// recovery codes, consuming the one that matched. The caller must hold the
+// lock and store the record.
+func (a *TOTPAuth) verify(record *TOTPRecord, code string, now time.Time) error {
+	if a.verifyTOTP(record, code, now) {
+		return nil
+	}
+
+	hash := hashRecoveryCode(code)
+	for i, h := range record.RecoveryHashes {
+		if subtle.ConstantTimeCompare([]byte(h), []byte(hash)) == 1 {
+			record.RecoveryHashes = append(record.RecoveryHashes[:i:i], record.RecoveryHashes[i+1:]...)
+			return nil
+		}
+	}
+
+	return fmt.Errorf("%w: second factor", ErrInvalidCredentials)
+}
+
+func (a *TOTPAuth) verifyTOTP(record *TOTPRecord, code string, now time.Time) bool {
+	h, err := a.config.hash()
+	if err != nil || len(code) != a.config.Digits {
+		return false
+	}
+
+	current := a.config.step(now)
+	for step := current - int64(a.config.Skew); step <= current+int64(a.config.Skew); step++ {
+		if step <= record.LastStep {
+			continue
+		}
+
+		want := hotp(record.Secret, step, a.config.Digits, h)
+		if subtle.ConstantTimeCompare([]byte(want), []byte(code)) == 1 {
+			record.LastStep = step
+			return true
+		}
+	}
+
+	return false
+}
+
+// uri follows the key URI format authenticator apps scan from a QR code.
+func (a *TOTPAuth) uri(u user.User, secret string) string {
+	label := url.PathEscape(a.config.Issuer + ":" + u.Name)
+	query := url.Values{
+		"secret":    {secret},
+		"issuer":    {a.config.Issuer},
+		"algorithm": {a.config.Algorithm},
+		"digits":    {fmt.Sprint(a.config.Digits)},
+		"period":    {fmt.Sprint(int(a.config.Period / time.Second))},
+	}
+
+	return "otpauth://totp/" + label + "?" + query.Encode()
+}
+
+// hashRecoveryCode ignores case and dashes, recovery codes are typed by hand.
+func hashRecoveryCode(code string) string {
+	normalized := strings.ToLower(strings.ReplaceAll(code, "-", ""))
+	return hashSecret(normalized)
+}
@@ -0,0 +1,168 @@
+package auth
+
+import (
+	"errors"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+func TestTOTPVectors(t *testing.T) {
+	// RFC 6238 appendix B.
+	secrets := map[string][]byte{
+		"SHA1":   []byte("12345678901234567890"),
+		"SHA256": []byte("12345678901234567890123456789012"),
+		"SHA512": []byte("1234567890123456789012345678901234567890123456789012345678901234"),
+	}
+
+	vectors := []struct {
+		time int64
+		want map[string]string
+	}{
+		{59, map[string]string{"SHA1": "94287082", "SHA256": "46119246", "SHA512": "90693936"}},
+		{1111111109, map[string]string{"SHA1": "07081804", "SHA256": "68084774", "SHA512": "25091201"}},
+		{1111111111, map[string]string{"SHA1": "14050471", "SHA256": "67062674", "SHA512": "99943326"}},
+		{1234567890, map[string]string{"SHA1": "89005924", "SHA256": "91819424", "SHA512": "93441116"}},
+		{2000000000, map[string]string{"SHA1": "69279037", "SHA256": "90698825", "SHA512": "38618901"}},
+		{20000000000, map[string]string{"SHA1": "65353130", "SHA256": "77737706", "SHA512": "47863826"}},
+	}
+
+	for _, v := range vectors {
+		for algorithm, want := range v.want {
+			config := NewTOTPConfig("logues")
+			config.Algorithm = algorithm
+			config.Digits = 8
+
+			got, err := TOTP(secrets[algorithm], time.Unix(v.time, 0), config)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if got != want {
+				t.Errorf("%s at %d: Got %s, Want %s", algorithm, v.time, got, want)
+			}
+		}
+	}
+}
+
+func TestTOTPAuth(t *testing.T) {
+	creds := Credentials{Username: "tester", Password: "correct horse"}
+
+	newTOTPAuth := func(t *testing.T) (*TOTPAuth, TOTPEnrollment) {
+		pa := NewPasswordAuth(NewInMemoryUserStore(), NewPasswordPolicy(), bcrypt.MinCost)
+		u, err := pa.Register(creds)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		a := NewTOTPAuth(pa, NewInMemoryTOTPStore(), NewTOTPConfig("logues"))
+		enrollment, err := a.Enroll(u)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		return a, enrollment
+	}
+
+	code := func(t *testing.T, enrollment TOTPEnrollment, at time.Time) string {
+		secret, err := totpEncoding.DecodeString(enrollment.Secret)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		c, _ := TOTP(secret, at, NewTOTPConfig("logues"))
+		return c
+	}
+
+	t.Run("Enrollment URI", func(t *testing.T) {
+		_, enrollment := newTOTPAuth(t)
+		uri, err := url.Parse(enrollment.URI)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if uri.Scheme != "otpauth" || uri.Host != "totp" || uri.Path != "/logues:tester" {
+			t.Errorf("Unexpected URI %s", enrollment.URI)
+		}
+
+		if got := uri.Query().Get("secret"); got != enrollment.Secret {
+			t.Errorf("Got secret %s, Want %s", got, enrollment.Secret)
+		}
+
+		if len(enrollment.RecoveryCodes) != recoveryCodeCount {
+			t.Errorf("Got %d recovery codes, Want %d", len(enrollment.RecoveryCodes), recoveryCodeCount)
+		}
+	})
+
+	t.Run("Pending enrollment doesn't require a code", func(t *testing.T) {
+		a, _ := newTOTPAuth(t)
+		if _, err := a.AuthenticateCredentials(creds); err != nil {
+			t.Errorf("Failed to authenticate: %s", err)
+		}
+	})
+
+	t.Run("Confirmed enrollment requires a code", func(t *testing.T) {
+		a, enrollment := newTOTPAuth(t)
+		u, _ := a.UserAuthenticator.AuthenticateCredentials(creds)
+		now := time.Now()
+
+		if err := a.Confirm(u, "abcdef"); !errors.Is(err, ErrInvalidCredentials) {
+			t.Fatalf("Got %v, Want %v", err, ErrInvalidCredentials)
+		}
+
+		if err := a.Confirm(u, code(t, enrollment, now.Add(-totpPeriod))); err != nil {
+			t.Fatal(err)
+		}
+
+		if _, err := a.AuthenticateCredentials(creds); !errors.Is(err, ErrSecondFactorRequired) {
+			t.Errorf("Got %v, Want %v", err, ErrSecondFactorRequired)
+		}
+
+		withCode := creds
+		withCode.Code = "123456"
+		if _, err := a.AuthenticateCredentials(withCode); !errors.Is(err, ErrInvalidCredentials) {
+			t.Errorf("Got %v, Want %v", err, ErrInvalidCredentials)
+		}
+
+		withCode.Code = code(t, enrollment, now)
+		if _, err := a.AuthenticateCredentials(withCode); err != nil {
+			t.Errorf("Failed to authenticate: %s", err)
+		}
+
+		if _, err := a.AuthenticateCredentials(withCode); !errors.Is(err, ErrInvalidCredentials) {
+			t.Errorf("Replayed code: Got %v, Want %v", err, ErrInvalidCredentials)
+		}
+
+		if _, err := a.Enroll(u); !errors.Is(err, ErrTOTPEnrolled) {
+			t.Errorf("Got %v, Want %v", err, ErrTOTPEnrolled)
+		}
+	})
+
+	t.Run("Recovery codes are single use", func(t *testing.T) {
+		a, enrollment := newTOTPAuth(t)
+		u, _ := a.UserAuthenticator.AuthenticateCredentials(creds)
+		a.Confirm(u, code(t, enrollment, time.Now()))
+
+		withCode := creds
+		withCode.Code = strings.ToUpper(enrollment.RecoveryCodes[3])
+		if _, err := a.AuthenticateCredentials(withCode); err != nil {
+			t.Fatalf("Failed to authenticate: %s", err)
+		}
+
+		if _, err := a.AuthenticateCredentials(withCode); !errors.Is(err, ErrInvalidCredentials) {
+			t.Errorf("Got %v, Want %v", err, ErrInvalidCredentials)
+		}
+	})
+
+	t.Run("Wrong password never reaches the second factor", func(t *testing.T) {
+		a, _ := newTOTPAuth(t)
+		wrong := creds
+		wrong.Password = "wrong password"
+		if _, err := a.AuthenticateCredentials(wrong); !errors.Is(err, ErrInvalidCredentials) {
+			t.Errorf("Got %v, Want %v", err, ErrInvalidCredentials)
+		}
+	})
+}
@@ -3,6 +3,7 @@ package channel
 import (
 	"bytes"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log/slog"
 	"time"
@@ -14,6 +15,37 @@ type Receiver interface {
 	Receive() chan<- []byte
 }
 
+// Identified receivers belong to a user, which lets a Filter decide what
+// they get.
+type Identified interface {
+	UserID() string
+}
+
+// Sent events come from a user and are filtered like their messages.
+type Sent interface {
+	SentBy() string
+}
+
+// Threaded events are about a reply, they only reach the subscribers of the
+// thread with the ID InThread returns, or everyone when it's empty.
+type Threaded interface {
+	InThread() string
+}
+
+// Verdict is how a receiver gets a message.
+type Verdict int
+
+const (
+	Deliver Verdict = iota
+	// Flag delivers the message marked as muted.
+	Flag
+	Skip
+)
+
+// Filter decides per receiver how a message of sender reaches them, both are
+// user IDs.
+type Filter func(receiver, sender string) Verdict
+
 type Registrar interface {
 	Register(Receiver) error
 	Unregister(Receiver) error
@@ -133,55 +165,214 @@ func (e *InMemoryEvictor) Start() {
 
 type Broadcaster interface {
 	Broadcast(message.Message)
+	// BroadcastTo sends the message to rcvs only, like the subscribers of a
+	// thread.
+	BroadcastTo(rcvs []Receiver, msg message.Message)
+	// Publish sends anything else than a message, like presence changes.
+	// Its JSON carries a "type" so clients can tell it from messages.
+	Publish(event any)
+	// PublishTo sends the event to rcvs only.
+	PublishTo(rcvs []Receiver, event any)
 }
 
 type DefaultBroadcaster struct {
-	list  func() ([]Receiver, error)
-	evict func(Receiver) error
+	list   func() ([]Receiver, error)
+	evict  func(Receiver) error
+	filter Filter
+}
+
+type BroadcasterOption func(*DefaultBroadcaster)
+
+// WithFilter runs every message through f for each Identified receiver.
+func WithFilter(f Filter) BroadcasterOption {
+	return func(b *DefaultBroadcaster) {
+		b.filter = f
+	}
 }
 
-func NewDefaultBroadcaster(listReceivers func() ([]Receiver, error), evictReceiver func(Receiver) error) *DefaultBroadcaster {
-	return &DefaultBroadcaster{
+func NewDefaultBroadcaster(listReceivers func() ([]Receiver, error), evictReceiver func(Receiver) error, opts ...BroadcasterOption) *DefaultBroadcaster {
+	b := &DefaultBroadcaster{
 		list:  listReceivers,
 		evict: evictReceiver,
 	}
+
+	for _, opt := range opts {
+		opt(b)
+	}
+
+	return b
 }
 
 func (b *DefaultBroadcaster) Broadcast(msg message.Message) {
+	b.BroadcastTo(b.receivers(), msg)
+}
+
+func (b *DefaultBroadcaster) BroadcastTo(rcvs []Receiver, msg message.Message) {
+	data := b.encode(msg)
+	if b.filter == nil || msg.SenderID == "" {
+		b.send(rcvs, data, nil)
+		return
+	}
+
+	var flagged []byte
+	b.send(rcvs, data, func(rcv Receiver) []byte {
+		id, ok := rcv.(Identified)
+		if !ok {
+			return data
+		}
+
+		switch b.filter(id.UserID(), msg.SenderID) {
+		case Skip:
+			return nil
+		case Flag:
+			if flagged == nil {
+				msg.Muted = true
+				flagged = b.encode(msg)
+			}
+			return flagged
+		default:
+			return data
+		}
+	})
+}
+
+// Publish skips receivers a Filter keeps from the sender of Sent events,
+// muting doesn't flag events though.
+func (b *DefaultBroadcaster) Publish(event any) {
+	b.PublishTo(b.receivers(), event)
+}
+
+func (b *DefaultBroadcaster) PublishTo(rcvs []Receiver, event any) {
+	data := b.encode(event)
+	sent, ok := event.(Sent)
+	if b.filter == nil || !ok || sent.SentBy() == "" {
+		b.send(rcvs, data, nil)
+		return
+	}
+
+	b.send(rcvs, data, func(rcv Receiver) []byte {
+		if id, ok := rcv.(Identified); ok && b.filter(id.UserID(), sent.SentBy()) == Skip {
+			return nil
+		}
+		return data
+	})
+}
+
+func (b *DefaultBroadcaster) receivers() []Receiver {
 	rcvs, err := b.list()
 	if err != nil {
-		fmt.Println("broadcast receivers list error")
+		slog.Error("listing broadcast receivers failed", "err", err)
 	}
 
+	return rcvs
+}
+
+// send hands data to every receiver in rcvs, or what pick returns for them
+// when it's set, nothing if that's nil.
+func (b *DefaultBroadcaster) send(rcvs []Receiver, data []byte, pick func(Receiver) []byte) {
 	for _, rcv := range rcvs {
+		d := data
+		if pick != nil {
+			if d = pick(rcv); d == nil {
+				continue
+			}
+		}
+
 		select {
-		case rcv.Receive() <- b.encode(msg):
+		case rcv.Receive() <- d:
 		default:
 			if err := b.evict(rcv); err != nil {
-				fmt.Println("evict unresponsive receiver error")
+				slog.Error("evicting unresponsive receiver failed", "err", err)
 			}
 		}
 	}
 }
 
-func (b DefaultBroadcaster) encode(m message.Message) []byte {
+func (b DefaultBroadcaster) encode(v any) []byte {
 	var data bytes.Buffer
 
-	err := json.NewEncoder(&data).Encode(m)
+	err := json.NewEncoder(&data).Encode(v)
 	if err != nil {
-		slog.Error("encoding error:", err)
+		slog.Error("encoding error", "err", err)
 	}
 
 	return data.Bytes()
 }
 
+// Time a receiver has to take an ack before it's dropped.
+const ackTimeout = 5 * time.Second
+
+// Post is a message sent by From, which gets an Ack for it once the channel
+// took it. From may be nil when there's nobody to ack. Result, when set, gets
+// the outcome whether or not the message has a ClientID, it needs room for
+// it as the channel doesn't wait.
+type Post struct {
+	Message message.Message
+	From    Receiver
+	Result  chan<- PostResult
+}
+
+// PostResult is the message as the channel took it, or why it refused it.
+// Duplicate messages are the ones the channel already had.
+type PostResult struct {
+	Message   message.Message
+	Duplicate bool
+	Err       error
+}
+
+const AckEventType = "ack"
+
+// Ack tells the sender of a message with a ClientID under which ID and
+// sequence number the channel took it, replies are numbered within their
+// thread. Duplicate acks resent messages the channel already had.
+type Ack struct {
+	Type      string `json:"type"`
+	ClientID  string `json:"client_id"`
+	ID        string `json:"id"`
+	Seq       uint64 `json:"seq"`
+	Duplicate bool   `json:"duplicate,omitempty"`
+}
+
+const ErrorEventType = "error"
+
+// ErrorEvent tells a receiver the channel refused what it sent, Code is the
+// one the REST API uses for the same problem.
+type ErrorEvent struct {
+	Type     string `json:"type"`
+	ClientID string `json:"client_id,omitempty"`
+	Code     string `json:"code"`
+	Message  string `json:"message"`
+}
+
+// Subscription adds Receiver to the subscribers of the thread of the
+// message with ID Thread, or removes it from them with Unsubscribe.
+type Subscription struct {
+	Receiver    Receiver
+	Thread      string
+	Unsubscribe bool
+}
+
 type Channel struct {
 	Broadcaster
 	Registrar
+	// History gets every message once it's stamped, nil keeps none.
+	History message.Store
+	// OnAccept gets every message once it's broadcast, like to notify those
+	// it mentions. It's called by the channel's loop and mustn't block.
+	OnAccept           func(message.Message)
+	// OnEvict gets every receiver the channel drops and why, like to audit
+	// it. It's called by the channel's loop and mustn't block.
+	OnEvict            func(rcv Receiver, reason string)
 	BroadcastMessage   chan message.Message
+	PostMessage        chan Post
+	BroadcastEvent     chan any
 	RegisterReceiver   chan Receiver
 	UnregisterReceiver chan Receiver
+	Subscribe          chan Subscription
 	stopChannel        chan struct{}
+	seq                uint64
+	recent             *window
+	threads            map[string]map[Receiver]struct{}
 }
 
 func NewChannel(reg Registrar, bcast Broadcaster) *Channel {
@@ -189,17 +380,39 @@ func NewChannel(reg Registrar, bcast Broadcaster) *Channel {
 		Registrar:          reg,
 		Broadcaster:        bcast,
 		BroadcastMessage:   make(chan message.Message),
+		PostMessage:        make(chan Post),
+		BroadcastEvent:     make(chan any),
 		RegisterReceiver:   make(chan Receiver),
 		UnregisterReceiver: make(chan Receiver),
+		Subscribe:          make(chan Subscription),
 		stopChannel:        make(chan struct{}),
+		recent:             newWindow(DefaultDedupeWindow),
+		threads:            make(map[string]map[Receiver]struct{}),
 	}
 }
 
-func NewDefaultChannel() *Channel {
+func NewDefaultChannel(opts ...BroadcasterOption) *Channel {
 	reg := make(InMemoryRegistrar)
-	evi := NewInMemoryEvictor(reg.Unregister, 10, 10*time.Second)
-	bcast := NewDefaultBroadcaster(reg.List, evi.Evict)
-	return NewChannel(reg, bcast)
+	var c *Channel
+	evi := NewInMemoryEvictor(func(rcv Receiver) error { return c.evict(rcv) }, 10, 10*time.Second)
+	bcast := NewDefaultBroadcaster(reg.List, evi.Evict, opts...)
+	c = NewChannel(reg, bcast)
+	return c
+}
+
+// EvictUnresponsive is why receivers that don't keep up are evicted.
+const EvictUnresponsive = "unresponsive"
+
+// evict unregisters rcv for not keeping up with the channel.
+func (c *Channel) evict(rcv Receiver) error {
+	if err := c.Unregister(rcv); err != nil {
+		return err
+	}
+
+	if c.OnEvict != nil {
+		c.OnEvict(rcv, EvictUnresponsive)
+	}
+	return nil
 }
 
 func (c *Channel) Start() {
@@ -210,9 +423,23 @@ func (c *Channel) Start() {
 
 		case rcv := <-c.UnregisterReceiver:
 			c.Unregister(rcv)
+			for thread := range c.threads {
+				c.unsubscribe(rcv, thread)
+			}
 
 		case msg := <-c.BroadcastMessage:
-			c.Broadcast(msg)
+			if _, err := c.accept(msg); err != nil {
+				slog.Warn("refused message", "err", err)
+			}
+
+		case sub := <-c.Subscribe:
+			c.subscribe(sub)
+
+		case post := <-c.PostMessage:
+			c.post(post)
+
+		case event := <-c.BroadcastEvent:
+			c.publish(event)
 
 		case <-c.stopChannel:
 			slog.Debug("Received stop signal")
@@ -221,6 +448,210 @@ func (c *Channel) Start() {
 	}
 }
 
+// accept stamps msg, records and broadcasts it.
+func (c *Channel) accept(msg message.Message) (message.Message, error) {
+	if msg.IsReply() {
+		return c.acceptReply(msg)
+	}
+
+	msg = c.stamp(msg)
+	if c.History != nil {
+		if err := c.History.Add(msg); err != nil {
+			slog.Error("recording message failed", "err", err)
+		}
+	}
+	c.Broadcast(msg)
+	c.accepted(msg)
+
+	return msg, nil
+}
+
+// acceptReply numbers msg within the thread of its parent and sends it to
+// the thread's subscribers only, everyone gets the new thread summary.
+func (c *Channel) acceptReply(msg message.Message) (message.Message, error) {
+	if c.History == nil {
+		return msg, fmt.Errorf("%w: %s", message.ErrNotFound, msg.ParentID)
+	}
+
+	parent, err := c.History.Get(msg.ParentID)
+	switch {
+	case err != nil:
+		return msg, err
+	case parent.IsReply():
+		return msg, message.ErrNestedThread
+	case parent.Deleted:
+		return msg, message.ErrDeleted
+	}
+
+	now := time.Now()
+	msg.ID, msg.Seq, msg.Time = message.NewID(now), parent.NextReply(), now
+	if err := c.History.Add(msg); err != nil {
+		return msg, err
+	}
+	c.BroadcastTo(c.subscribers(msg.ParentID), msg)
+
+	if parent, err = c.History.Get(msg.ParentID); err == nil {
+		c.Publish(parent.ThreadEvent())
+	}
+	c.accepted(msg)
+
+	return msg, nil
+}
+
+// publish sends Threaded events to the subscribers of their thread only,
+// like the replies they're about.
+func (c *Channel) publish(event any) {
+	if t, ok := event.(Threaded); ok && t.InThread() != "" {
+		c.PublishTo(c.subscribers(t.InThread()), event)
+		return
+	}
+
+	c.Publish(event)
+}
+
+func (c *Channel) accepted(msg message.Message) {
+	if c.OnAccept != nil {
+		c.OnAccept(msg)
+	}
+}
+
+// post accepts messages with a ClientID only once within the recent window.
+func (c *Channel) post(p Post) {
+	msg := p.Message
+	if msg.ClientID != "" {
+		if prev, ok := c.recent.get(msg); ok {
+			c.ack(p.From, prev, true)
+			p.result(PostResult{Message: prev, Duplicate: true})
+			return
+		}
+	}
+
+	msg, err := c.accept(msg)
+	if err != nil {
+		c.refuse(p.From, msg.ClientID, err)
+		p.result(PostResult{Message: msg, Err: err})
+		return
+	}
+
+	if msg.ClientID != "" {
+		c.recent.add(msg)
+		c.ack(p.From, msg, false)
+	}
+	p.result(PostResult{Message: msg})
+}
+
+func (p Post) result(r PostResult) {
+	if p.Result == nil {
+		return
+	}
+
+	select {
+	case p.Result <- r:
+	default:
+		slog.Warn("dropped post result without room")
+	}
+}
+
+func (c *Channel) ack(rcv Receiver, msg message.Message, duplicate bool) {
+	c.reply(rcv, Ack{Type: AckEventType, ClientID: msg.ClientID, ID: msg.ID, Seq: msg.Seq, Duplicate: duplicate})
+}
+
+// refuse tells rcv why the channel didn't take its message.
+func (c *Channel) refuse(rcv Receiver, clientID string, err error) {
+	code := "internal"
+	switch {
+	case errors.Is(err, message.ErrNotFound):
+		code = "message_not_found"
+	case errors.Is(err, message.ErrNestedThread):
+		code = "nested_thread"
+	case errors.Is(err, message.ErrDeleted):
+		code = "message_deleted"
+	}
+
+	c.reply(rcv, ErrorEvent{Type: ErrorEventType, ClientID: clientID, Code: code, Message: err.Error()})
+}
+
+// reply hands v to rcv without holding up the channel, it follows what was
+// broadcast before unless rcv is too slow to take either.
+func (c *Channel) reply(rcv Receiver, v any) {
+	if rcv == nil {
+		return
+	}
+
+	data, err := json.Marshal(v)
+	if err != nil {
+		slog.Error("encoding error", "err", err)
+		return
+	}
+
+	go func() {
+		select {
+		case rcv.Receive() <- append(data, '\n'):
+		case <-time.After(ackTimeout):
+			slog.Warn("dropped reply to unresponsive receiver")
+		}
+	}()
+}
+
+// subscribe only takes threads of messages the channel still has.
+func (c *Channel) subscribe(sub Subscription) {
+	if sub.Unsubscribe {
+		c.unsubscribe(sub.Receiver, sub.Thread)
+		return
+	}
+
+	if c.History == nil {
+		c.refuse(sub.Receiver, "", fmt.Errorf("%w: %s", message.ErrNotFound, sub.Thread))
+		return
+	}
+
+	parent, err := c.History.Get(sub.Thread)
+	if err == nil && parent.IsReply() {
+		err = message.ErrNestedThread
+	}
+	if err != nil {
+		c.refuse(sub.Receiver, "", err)
+		return
+	}
+
+	if c.threads[sub.Thread] == nil {
+		c.threads[sub.Thread] = make(map[Receiver]struct{})
+	}
+	c.threads[sub.Thread][sub.Receiver] = struct{}{}
+}
+
+func (c *Channel) unsubscribe(rcv Receiver, thread string) {
+	delete(c.threads[thread], rcv)
+	if len(c.threads[thread]) == 0 {
+		delete(c.threads, thread)
+	}
+}
+
+// subscribers lists the registered subscribers of thread, dropping those
+// evicted since they subscribed.
+func (c *Channel) subscribers(thread string) []Receiver {
+	rcvs := make([]Receiver, 0, len(c.threads[thread]))
+	for rcv := range c.threads[thread] {
+		if ok, _ := c.Check(rcv); !ok {
+			c.unsubscribe(rcv, thread)
+			continue
+		}
+		rcvs = append(rcvs, rcv)
+	}
+
+	return rcvs
+}
+
+// stamp gives msg its ID, the time the channel got it and the next
+// sequence number, so clients can order messages and notice gaps.
+func (c *Channel) stamp(msg message.Message) message.Message {
+	now := time.Now()
+	c.seq++
+	msg.ID, msg.Seq, msg.Time = message.NewID(now), c.seq, now
+
+	return msg
+}
+
 func (c *Channel) Stop() {
 	c.stopChannel <- struct{}{}
 }
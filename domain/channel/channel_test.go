@@ -3,6 +3,7 @@ package channel
 import (
 	"bytes"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"sync"
 	"testing"
@@ -193,6 +194,35 @@ func TestChannelEvictUnresponsiveReceiver(t *testing.T) {
 	})
 }
 
+func TestChannelOnEvict(t *testing.T) {
+	chann := NewDefaultChannel()
+	evicted := make(chan string, 1)
+	stuck := make(bufferedReceiver)
+	chann.OnEvict = func(rcv Receiver, reason string) {
+		if rcv != Receiver(stuck) {
+			t.Errorf("Evicted %v, Want the stuck receiver", rcv)
+		}
+		evicted <- reason
+	}
+
+	go chann.Start()
+	defer chann.Stop()
+
+	chann.RegisterReceiver <- stuck
+	for range 11 {
+		chann.BroadcastMessage <- message.Message{Content: "anyone?"}
+	}
+
+	select {
+	case reason := <-evicted:
+		if reason != EvictUnresponsive {
+			t.Errorf("Got reason %q, Want %q", reason, EvictUnresponsive)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Stuck receiver wasn't evicted")
+	}
+}
+
 func TestChannelBroadcasting(t *testing.T) {
 	t.Run("Test broadcasting", func(t *testing.T) {
 		reg := NewWaitingRegistrar()
@@ -221,3 +251,317 @@ func TestChannelBroadcasting(t *testing.T) {
 		RegistrarReceiversAmountEquelsTo(t, chann, amount)
 	})
 }
+
+type bufferedReceiver chan []byte
+
+func (r bufferedReceiver) Receive() chan<- []byte {
+	return r
+}
+
+func TestChannelPublish(t *testing.T) {
+	t.Run("Events reach every receiver", func(t *testing.T) {
+		reg := NewWaitingRegistrar()
+		evi := NewInMemoryEvictor(reg.Unregister, 10, 10*time.Second)
+		chann := NewChannel(reg, NewDefaultBroadcaster(reg.List, evi.Evict))
+
+		go chann.Start()
+		defer chann.Stop()
+
+		rcv := make(bufferedReceiver, 1)
+		reg.Add(1)
+		chann.RegisterReceiver <- rcv
+		reg.Wait()
+
+		chann.BroadcastEvent <- map[string]string{"type": "presence", "status": "online"}
+
+		select {
+		case got := <-rcv:
+			if want := `{"status":"online","type":"presence"}` + "\n"; string(got) != want {
+				t.Errorf("Got %s, Want %s", got, want)
+			}
+		case <-time.After(time.Second):
+			t.Fatal("Event wasn't published")
+		}
+	})
+}
+
+type userReceiver struct {
+	bufferedReceiver
+	id string
+}
+
+func (r userReceiver) UserID() string {
+	return r.id
+}
+
+func TestBroadcastFilter(t *testing.T) {
+	reg := make(InMemoryRegistrar)
+	filter := func(receiver, sender string) Verdict {
+		switch receiver {
+		case "blocker":
+			return Skip
+		case "muter":
+			return Flag
+		}
+		return Deliver
+	}
+	bro := NewDefaultBroadcaster(reg.List, func(Receiver) error { return nil }, WithFilter(filter))
+
+	rcvs := map[string]Receiver{
+		"anonymous": make(bufferedReceiver, 1),
+		"friend":    userReceiver{make(bufferedReceiver, 1), "friend"},
+		"blocker":   userReceiver{make(bufferedReceiver, 1), "blocker"},
+		"muter":     userReceiver{make(bufferedReceiver, 1), "muter"},
+	}
+	for _, rcv := range rcvs {
+		reg.Register(rcv)
+	}
+
+	bro.Broadcast(message.Message{SenderID: "sender", Content: "hi"})
+
+	received := func(rcv Receiver) (message.Message, bool) {
+		var ch bufferedReceiver
+		switch r := rcv.(type) {
+		case bufferedReceiver:
+			ch = r
+		case userReceiver:
+			ch = r.bufferedReceiver
+		}
+
+		select {
+		case data := <-ch:
+			var msg message.Message
+			if err := json.Unmarshal(data, &msg); err != nil {
+				t.Fatal(err)
+			}
+			return msg, true
+		default:
+			return message.Message{}, false
+		}
+	}
+
+	for name, want := range map[string]struct{ got, muted bool }{
+		"anonymous": {true, false},
+		"friend":    {true, false},
+		"blocker":   {false, false},
+		"muter":     {true, true},
+	} {
+		msg, got := received(rcvs[name])
+		if got != want.got || msg.Muted != want.muted {
+			t.Errorf("%s got message %t muted %t, Want %t muted %t", name, got, msg.Muted, want.got, want.muted)
+		}
+	}
+}
+
+func TestChannelStamp(t *testing.T) {
+	reg := NewWaitingRegistrar()
+	evi := NewInMemoryEvictor(reg.Unregister, 10, 10*time.Second)
+	chann := NewChannel(reg, NewDefaultBroadcaster(reg.List, evi.Evict))
+	chann.History = message.NewInMemoryStore(0)
+	accepted := make(chan message.Message, 3)
+	chann.OnAccept = func(msg message.Message) { accepted <- msg }
+
+	go chann.Start()
+	defer chann.Stop()
+
+	rcv := make(bufferedReceiver, 3)
+	reg.Add(1)
+	chann.RegisterReceiver <- rcv
+	reg.Wait()
+
+	var last message.Message
+	for i := range 3 {
+		chann.BroadcastMessage <- message.Message{Content: "hi"}
+
+		var got message.Message
+		if err := json.Unmarshal(<-rcv, &got); err != nil {
+			t.Fatal(err)
+		}
+
+		if got.Seq != uint64(i+1) || got.ID <= last.ID || got.Time.Before(last.Time) {
+			t.Errorf("Got %+v after %+v", got, last)
+		}
+		last = got
+	}
+
+	if got, err := chann.History.Get(last.ID); err != nil || got.Seq != last.Seq {
+		t.Errorf("Got %+v %v from history, Want %+v", got, err, last)
+	}
+
+	if len(accepted) != 3 {
+		t.Errorf("OnAccept got %d messages, Want 3", len(accepted))
+	}
+}
+
+func TestChannelPost(t *testing.T) {
+	reg := NewWaitingRegistrar()
+	evi := NewInMemoryEvictor(reg.Unregister, 10, 10*time.Second)
+	chann := NewChannel(reg, NewDefaultBroadcaster(reg.List, evi.Evict))
+
+	go chann.Start()
+	defer chann.Stop()
+
+	rcv := make(bufferedReceiver, 4)
+	reg.Add(1)
+	chann.RegisterReceiver <- rcv
+	reg.Wait()
+
+	next := func() map[string]any {
+		select {
+		case data := <-rcv:
+			var frame map[string]any
+			if err := json.Unmarshal(data, &frame); err != nil {
+				t.Fatal(err)
+			}
+			return frame
+		case <-time.After(time.Second):
+			t.Fatal("Nothing received")
+			return nil
+		}
+	}
+
+	post := Post{Message: message.Message{SenderID: "sender", ClientID: "once", Content: "hi"}, From: rcv}
+	chann.PostMessage <- post
+	msg, ack := next(), next()
+	if msg["client_id"] != "once" || ack["type"] != AckEventType || ack["id"] != msg["id"] || ack["duplicate"] != nil {
+		t.Fatalf("Got %v & %v, Want the message & its ack", msg, ack)
+	}
+
+	chann.PostMessage <- post
+	if dup := next(); dup["type"] != AckEventType || dup["id"] != msg["id"] || dup["duplicate"] != true {
+		t.Errorf("Got %v, Want a duplicate ack of %v", dup, msg["id"])
+	}
+
+	select {
+	case data := <-rcv:
+		t.Errorf("Resent message was broadcast again: %s", data)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	// Results come without a ClientID too, and for refused messages.
+	results := make(chan PostResult, 1)
+	chann.PostMessage <- Post{Message: message.Message{Content: "no id"}, Result: results}
+	if r := <-results; r.Err != nil || r.Message.ID == "" || r.Message.Seq == 0 {
+		t.Errorf("Got %+v, Want the stamped message", r)
+	}
+	next()
+
+	chann.PostMessage <- Post{Message: message.Message{ParentID: "unknown", Content: "reply"}, Result: results}
+	if r := <-results; !errors.Is(r.Err, message.ErrNotFound) {
+		t.Errorf("Got %v, Want %v", r.Err, message.ErrNotFound)
+	}
+}
+
+func TestChannelThreads(t *testing.T) {
+	reg := NewWaitingRegistrar()
+	evi := NewInMemoryEvictor(reg.Unregister, 10, 10*time.Second)
+	chann := NewChannel(reg, NewDefaultBroadcaster(reg.List, evi.Evict))
+	chann.History = message.NewInMemoryStore(0)
+
+	go chann.Start()
+	defer chann.Stop()
+
+	subscriber, other := make(bufferedReceiver, 4), make(bufferedReceiver, 4)
+	reg.Add(2)
+	chann.RegisterReceiver <- subscriber
+	chann.RegisterReceiver <- other
+	reg.Wait()
+
+	next := func(rcv bufferedReceiver) map[string]any {
+		select {
+		case data := <-rcv:
+			var frame map[string]any
+			if err := json.Unmarshal(data, &frame); err != nil {
+				t.Fatal(err)
+			}
+			return frame
+		case <-time.After(time.Second):
+			t.Fatal("Nothing received")
+			return nil
+		}
+	}
+
+	chann.BroadcastMessage <- message.Message{Content: "parent"}
+	parent := next(subscriber)["id"].(string)
+	next(other)
+
+	chann.Subscribe <- Subscription{Receiver: subscriber, Thread: parent}
+
+	reply := message.Message{ClientID: "reply", ParentID: parent, Content: "reply"}
+	chann.PostMessage <- Post{Message: reply, From: other}
+
+	first := next(subscriber)
+	if first["parent_id"] != parent || first["seq"] != 1.0 {
+		t.Errorf("Subscriber got %v, Want the first reply", first)
+	}
+	if got := next(subscriber); got["type"] != message.ThreadEventType {
+		t.Errorf("Subscriber got %v, Want the thread summary", got)
+	}
+
+	types := map[any]bool{}
+	for range 2 {
+		types[next(other)["type"]] = true
+	}
+	if !types[message.ThreadEventType] || !types[AckEventType] {
+		t.Errorf("Got %v, Want the thread summary and the ack only", types)
+	}
+
+	edited := message.Message{ID: first["id"].(string), ParentID: parent, Content: "edited"}
+	chann.BroadcastEvent <- edited.Event()
+	if got := next(subscriber); got["type"] != message.EditedEventType || got["parent_id"] != parent {
+		t.Errorf("Subscriber got %v, Want the edit of the reply", got)
+	}
+	select {
+	case data := <-other:
+		t.Errorf("Non-subscriber got %s", data)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	chann.Subscribe <- Subscription{Receiver: subscriber, Thread: parent, Unsubscribe: true}
+	chann.PostMessage <- Post{Message: message.Message{ParentID: parent, Content: "again"}}
+	if got := next(subscriber); got["type"] != message.ThreadEventType {
+		t.Errorf("Unsubscribed receiver got %v", got)
+	}
+	next(other)
+
+	chann.PostMessage <- Post{Message: message.Message{ClientID: "lost", ParentID: "unknown", Content: "reply"}, From: other}
+	if got := next(other); got["type"] != ErrorEventType || got["code"] != "message_not_found" || got["client_id"] != "lost" {
+		t.Errorf("Got %v, Want an error for the unknown parent", got)
+	}
+}
+
+type sentEvent struct {
+	Type   string `json:"type"`
+	Sender string `json:"sender"`
+}
+
+func (e sentEvent) SentBy() string {
+	return e.Sender
+}
+
+func TestPublishFilter(t *testing.T) {
+	reg := make(InMemoryRegistrar)
+	filter := func(receiver, sender string) Verdict {
+		if receiver == "blocker" {
+			return Skip
+		}
+		return Flag
+	}
+	bro := NewDefaultBroadcaster(reg.List, func(Receiver) error { return nil }, WithFilter(filter))
+
+	blocker := userReceiver{make(bufferedReceiver, 2), "blocker"}
+	muter := userReceiver{make(bufferedReceiver, 2), "muter"}
+	reg.Register(blocker)
+	reg.Register(muter)
+
+	bro.Publish(sentEvent{Type: "edit", Sender: "sender"})
+	bro.Publish(map[string]string{"type": "presence"})
+
+	if got := len(blocker.bufferedReceiver); got != 1 {
+		t.Errorf("Blocker got %d events, Want only the unsent one", got)
+	}
+
+	if got := len(muter.bufferedReceiver); got != 2 {
+		t.Errorf("Muter got %d events, Want both", got)
+	}
+}
@@ -0,0 +1,46 @@
+package channel
+
+import (
+	"github.com/DanyPops/logues/domain/message"
+)
+
+// Recent client IDs the channel remembers to drop resent messages.
+const DefaultDedupeWindow = 1024
+
+type dedupeKey struct {
+	sender, clientID string
+}
+
+// window remembers the messages of the latest client IDs, forgetting the
+// oldest once it's full.
+type window struct {
+	keys     []dedupeKey
+	next     int
+	messages map[dedupeKey]message.Message
+}
+
+func newWindow(size int) *window {
+	return &window{
+		keys:     make([]dedupeKey, 0, size),
+		messages: make(map[dedupeKey]message.Message, size),
+	}
+}
+
+// get returns the message the sender already posted under its ClientID.
+func (w *window) get(msg message.Message) (message.Message, bool) {
+	prev, ok := w.messages[dedupeKey{msg.SenderID, msg.ClientID}]
+	return prev, ok
+}
+
+func (w *window) add(msg message.Message) {
+	key := dedupeKey{msg.SenderID, msg.ClientID}
+	if len(w.keys) < cap(w.keys) {
+		w.keys = append(w.keys, key)
+	} else {
+		delete(w.messages, w.keys[w.next])
+		w.keys[w.next] = key
+		w.next = (w.next + 1) % len(w.keys)
+	}
+
+	w.messages[key] = msg
+}
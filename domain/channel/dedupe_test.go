@@ -0,0 +1,26 @@
+package channel
+
+import (
+	"testing"
+
+	"github.com/DanyPops/logues/domain/message"
+)
+
+func TestWindow(t *testing.T) {
+	w := newWindow(2)
+	for _, id := range []string{"a", "b", "c"} {
+		w.add(message.Message{SenderID: "sender", ClientID: id, ID: "server-" + id})
+	}
+
+	if _, ok := w.get(message.Message{SenderID: "sender", ClientID: "a"}); ok {
+		t.Error("Oldest client id wasn't forgotten")
+	}
+
+	if got, ok := w.get(message.Message{SenderID: "sender", ClientID: "c"}); !ok || got.ID != "server-c" {
+		t.Errorf("Got %+v %t, Want server-c", got, ok)
+	}
+
+	if _, ok := w.get(message.Message{SenderID: "other", ClientID: "c"}); ok {
+		t.Error("Client ids of other senders collided")
+	}
+}
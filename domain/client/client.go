@@ -3,11 +3,15 @@ package client
 import (
 	"encoding/json"
 	"errors"
+	"fmt"
 	"io"
 	"log/slog"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/DanyPops/logues/domain/channel"
+	"github.com/DanyPops/logues/domain/connection"
 	"github.com/DanyPops/logues/domain/message"
 	"github.com/DanyPops/logues/domain/user"
 	"github.com/gorilla/websocket"
@@ -20,21 +24,20 @@ const (
 	pongWait = 60 * time.Second
 	// Send pings to peer with this period. Must be less than pongWait.
 	pingPeriod = (pongWait * 9) / 10
-	// Maximum message size allowed from peer.
-	maxMessageSize = 512
+	// Frames a client holds while writing, so frames broadcast back to
+	// back aren't dropped.
+	receiveBufferSize = 256
 )
 
 type ConnectionConfig struct {
 	writeWait, pongWait, pingPeriod time.Duration
-	maxMessageSize                  int64
 }
 
 func NewConnectionConfig() ConnectionConfig {
 	return ConnectionConfig{
-		writeWait:      writeWait,
-		pongWait:       pongWait,
-		pingPeriod:     pingPeriod,
-		maxMessageSize: maxMessageSize,
+		writeWait:  writeWait,
+		pongWait:   pongWait,
+		pingPeriod: pingPeriod,
 	}
 }
 
@@ -45,24 +48,85 @@ func (c ConnectionConfig) SetShortDeadline() ConnectionConfig {
 	return c
 }
 
+// Types of frames asking for thread updates, frames without a type are
+// messages.
+const (
+	SubscribeFrameType   = "subscribe"
+	UnsubscribeFrameType = "unsubscribe"
+)
+
+// frame is anything a client sends.
+type frame struct {
+	Type     string `json:"type"`
+	ThreadID string `json:"thread_id"`
+	message.Message
+}
+
 type Client struct {
 	connection           io.ReadWriteCloser
 	user                 user.User
+	session              string
 	communicationChannel *channel.Channel
 	receiverChannel      chan []byte
 	receiverTicker       *time.Ticker
 	stopChannel          chan struct{}
+	stopOnce             *sync.Once
+	done                 chan struct{}
+	readOnly             bool
+	profiles             user.Store
+	onActivity           func()
+	inbound              message.Inbound
 }
 
-func NewClient(conn io.ReadWriteCloser, u user.User, ch *channel.Channel) *Client {
-	return &Client{
+type Option func(*Client)
+
+// ReadOnly drops everything the client sends instead of broadcasting it.
+func ReadOnly() Option {
+	return func(c *Client) {
+		c.readOnly = true
+	}
+}
+
+// Profiles snapshots the sender's profile into every message.
+func Profiles(store user.Store) Option {
+	return func(c *Client) {
+		c.profiles = store
+	}
+}
+
+// OnActivity calls f whenever the client sends something, empty messages
+// included, so clients can signal activity without posting.
+func OnActivity(f func()) Option {
+	return func(c *Client) {
+		c.onActivity = f
+	}
+}
+
+// Inbound cleans up every message the client sends with in, which only
+// checks the encoding when it isn't set.
+func Inbound(in message.Inbound) Option {
+	return func(c *Client) {
+		c.inbound = in
+	}
+}
+
+func NewClient(conn io.ReadWriteCloser, u user.User, ch *channel.Channel, opts ...Option) *Client {
+	c := &Client{
 		connection:           conn,
 		user:                 u,
 		communicationChannel: ch,
-		receiverChannel:      make(chan []byte),
+		receiverChannel:      make(chan []byte, receiveBufferSize),
 		receiverTicker:       time.NewTicker(time.Second * 10),
 		stopChannel:          make(chan struct{}),
+		stopOnce:             new(sync.Once),
+		done:                 make(chan struct{}),
 	}
+
+	for _, opt := range opts {
+		opt(c)
+	}
+
+	return c
 }
 
 func (c *Client) Start() {
@@ -71,8 +135,16 @@ func (c *Client) Start() {
 	go c.connReaderRcvWriter()
 }
 
+// Stop closes the connection, it's safe to call more than once.
 func (c *Client) Stop() {
-	c.stopChannel <- struct{}{}
+	c.stopOnce.Do(func() {
+		close(c.stopChannel)
+	})
+}
+
+// Done is closed once the client left its channel.
+func (c *Client) Done() <-chan struct{} {
+	return c.done
 }
 
 func (c *Client) rcvReaderConnWriter() {
@@ -89,13 +161,13 @@ func (c *Client) rcvReaderConnWriter() {
 			}
 
 			if _, err := c.connection.Write(d); err != nil {
-				slog.Error("writing to connection:", err)
+				slog.Error("writing to connection", "err", err)
 				return
 			}
 
 		case <-c.receiverTicker.C:
 			if _, err := c.connection.Write([]byte{}); err != nil {
-				slog.Error("writing to connection:", err)
+				slog.Error("writing to connection", "err", err)
 				return
 
 			}
@@ -111,67 +183,236 @@ func (c *Client) connReaderRcvWriter() {
 	defer func() {
 		c.communicationChannel.UnregisterReceiver <- c
 		c.connection.Close()
+		close(c.done)
 	}()
 
 	for {
-		msg := message.Message{}
+		var raw json.RawMessage
 
-		if err := json.NewDecoder(c.connection).Decode(&msg); err != nil {
+		if err := json.NewDecoder(c.connection).Decode(&raw); err != nil {
 			if errors.Is(err, io.EOF) {
 				continue
 			}
 
+			if errors.Is(err, connection.ErrMessageTooLarge) {
+				c.refuse("", "content_too_long", "message is too large")
+				continue
+			}
+
 			if !websocket.IsCloseError(err, websocket.CloseAbnormalClosure) {
-				slog.Error("reading from connection:", err)
+				slog.Error("reading from connection", "err", err)
 			}
-      slog.Error("decoding error", err)
 			break
 		}
 
-		if len(msg.Content) == 0 {
-			slog.Error("empty message")
+		if c.onActivity != nil {
+			c.onActivity()
+		}
+
+		f := frame{}
+		if err := message.DecodeJSON(raw, &f); err != nil {
+			code := "bad_request"
+			var contentErr message.ContentError
+			var typeErr *json.UnmarshalTypeError
+			switch {
+			case errors.As(err, &contentErr):
+				code = contentErr.Code()
+			case errors.As(err, &typeErr):
+				field, _, _ := strings.Cut(typeErr.Field, ".")
+				code = field + "_invalid"
+			}
+			c.refuse(f.ClientID, code, err.Error())
+			continue
+		}
+
+		switch f.Type {
+		case SubscribeFrameType, UnsubscribeFrameType:
+			c.communicationChannel.Subscribe <- channel.Subscription{Receiver: c, Thread: f.ThreadID, Unsubscribe: f.Type == UnsubscribeFrameType}
+			continue
+		case "":
+		default:
+			c.refuse(f.ClientID, "type_invalid", "unknown frame type "+f.Type)
+			continue
+		}
+
+		// Empty messages only signal activity.
+		if f.Content == "" && f.ContentType == "" && f.Attachment == nil {
+			continue
+		}
+
+		if c.readOnly {
+			c.refuse(f.ClientID, "read_only", "this connection can't post messages")
+			continue
+		}
+
+		if len(f.ClientID) > message.MaxClientIDLength {
+			c.refuse("", "client_id_invalid", fmt.Sprintf("client_id must be at most %d bytes", message.MaxClientIDLength))
 			continue
 		}
 
-		msg.Sender = c.user
+		msg, err := c.inbound.Clean(message.Message{
+			ClientID:    f.ClientID,
+			ParentID:    f.ParentID,
+			ContentType: f.ContentType,
+			Content:     f.Content,
+			Language:    f.Language,
+			Attachment:  f.Attachment,
+		})
+		if err == nil {
+			msg, err = msg.CheckContent()
+		}
+		if err != nil {
+			code := "bad_request"
+			var contentErr message.ContentError
+			if errors.As(err, &contentErr) {
+				code = contentErr.Code()
+			}
+			c.refuse(f.ClientID, code, err.Error())
+			continue
+		}
+		msg = msg.From(c.user, c.profiles).WithMentions(c.profiles)
 
-		c.communicationChannel.BroadcastMessage <- msg
+		c.communicationChannel.PostMessage <- channel.Post{Message: msg, From: c}
 	}
 }
 
+// refuse tells the client why the channel won't get what it sent.
+func (c *Client) refuse(clientID, code, reason string) {
+	data, err := json.Marshal(channel.ErrorEvent{Type: channel.ErrorEventType, ClientID: clientID, Code: code, Message: reason})
+	if err != nil {
+		slog.Error("encoding error", "err", err)
+		return
+	}
+
+	c.Send(append(data, '\n'))
+}
+
 func (c *Client) Who() string {
 	return c.user.Name
 }
 
+func (c *Client) UserID() string {
+	return c.user.ID
+}
+
+// Session is the session the client connected under, if any.
+func (c *Client) Session() string {
+	return c.session
+}
+
 func (c *Client) Receive() chan<- []byte {
 	return c.receiverChannel
 }
 
+// Send writes data to the client outside of its channel, it returns false if
+// the client is gone.
+func (c *Client) Send(data []byte) bool {
+	select {
+	case c.receiverChannel <- data:
+		return true
+	case <-c.stopChannel:
+		return false
+	case <-c.done:
+		return false
+	}
+}
+
 type ClientStore interface {
-	Add(*Client)
+	Add(session string, c *Client)
+	Remove(session string, c *Client)
+	List(session string) []*Client
 }
 
-type InMemoryClientStore []*Client
+type InMemoryClientStore struct {
+	lock    *sync.RWMutex
+	clients map[string]map[*Client]bool
+}
 
 func NewInMemoryClientStore() InMemoryClientStore {
-	return make(InMemoryClientStore, 0)
+	return InMemoryClientStore{
+		lock:    new(sync.RWMutex),
+		clients: make(map[string]map[*Client]bool),
+	}
 }
 
-func (s InMemoryClientStore) Add(c *Client) {
-	s = append(s, c)
+func (s InMemoryClientStore) Add(session string, c *Client) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if s.clients[session] == nil {
+		s.clients[session] = make(map[*Client]bool)
+	}
+	s.clients[session][c] = true
+}
+
+func (s InMemoryClientStore) Remove(session string, c *Client) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	delete(s.clients[session], c)
+	if len(s.clients[session]) == 0 {
+		delete(s.clients, session)
+	}
+}
+
+func (s InMemoryClientStore) List(session string) []*Client {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	clients := make([]*Client, 0, len(s.clients[session]))
+	for c := range s.clients[session] {
+		clients = append(clients, c)
+	}
+
+	return clients
 }
 
 type ClientServer struct {
 	clientStore ClientStore
+	// userStore keeps the same clients under their user's ID.
+	userStore ClientStore
 }
 
 func NewClientServer() *ClientServer {
 	return &ClientServer{
 		clientStore: NewInMemoryClientStore(),
+		userStore:   NewInMemoryClientStore(),
 	}
 }
 
-func (cs *ClientServer) ServeClient(conn io.ReadWriteCloser, user user.User, ch *channel.Channel) {
-	c := NewClient(conn, user, ch)
+// ServeClient connects conn to ch and keeps track of it under session until
+// it disconnects.
+func (cs *ClientServer) ServeClient(conn io.ReadWriteCloser, user user.User, ch *channel.Channel, session string, opts ...Option) *Client {
+	c := NewClient(conn, user, ch, opts...)
+	c.session = session
+	cs.clientStore.Add(session, c)
+	cs.userStore.Add(user.ID, c)
+	go func() {
+		<-c.Done()
+		cs.clientStore.Remove(session, c)
+		cs.userStore.Remove(user.ID, c)
+	}()
+
 	go c.Start()
+	return c
+}
+
+// SendToUser writes data to every connection of the user with id and returns
+// how many got it.
+func (cs *ClientServer) SendToUser(id string, data []byte) int {
+	sent := 0
+	for _, c := range cs.userStore.List(id) {
+		if c.Send(data) {
+			sent++
+		}
+	}
+
+	return sent
+}
+
+// DisconnectSession stops every client connected under session.
+func (cs *ClientServer) DisconnectSession(session string) {
+	for _, c := range cs.clientStore.List(session) {
+		c.Stop()
+	}
 }
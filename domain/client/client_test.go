@@ -135,7 +135,8 @@ func (s *MockClientServer) clientServeHandler(w http.ResponseWriter, r *http.Req
 		return
 	}
 
-	s.ServeClient(conn, user.User{Name: "mock"}, s.channel)
+	u := user.User{ID: r.URL.Query().Get("user"), Name: "mock"}
+	s.ServeClient(conn, u, s.channel, r.URL.Query().Get("session"))
 }
 
 func TestSendReceive(t *testing.T) {
@@ -151,9 +152,11 @@ func TestSendReceive(t *testing.T) {
 		go chann.Start()
     defer chann.Stop()
 
-		u := user.User{Name: "usee"}
-    msg := message.Message{Sender: u, Content: "hello"}
-		client := NewClient(conn, u, chann)
+		u := user.User{ID: "usee-id", Name: "usee"}
+		profiles := user.NewInMemoryStore()
+		profiles.Add(user.Profile{User: u, DisplayName: "Usee"})
+    msg := message.Message{Content: "hello"}
+		client := NewClient(conn, u, chann, Profiles(profiles))
 
 		go client.Start()
     defer client.Stop()
@@ -165,11 +168,20 @@ func TestSendReceive(t *testing.T) {
 		json.NewEncoder(conn.input).Encode(msg)
 		waitBuf.Wait()
 
-		msg.Sender = u
-		want, _ := json.Marshal(msg)
+		var got message.Message
+		if err := json.Unmarshal(waitBuf.Bytes(), &got); err != nil {
+			t.Fatal(err)
+		}
+		if got.ID == "" || got.Seq != 1 || got.Time.IsZero() {
+			t.Errorf("Got unstamped message %v", got)
+		}
+		got.ID, got.Seq, got.Time = "", 0, time.Time{}
 
-		if !bytes.Equal(append(want, '\n'), waitBuf.Bytes()) {
-			t.Errorf("Wanted %v\ngot %v", append(want, '\n'), waitBuf.Bytes())
+		msg.SenderID = u.ID
+		msg.Sender = message.Sender{Name: u.Name, DisplayName: "Usee"}
+		msg.ContentType = message.PlainContent
+		if !reflect.DeepEqual(got, msg) {
+			t.Errorf("Wanted %v\ngot %v", msg, got)
 		}
 	})
 }
@@ -193,7 +205,7 @@ func TestClientServer(t *testing.T) {
 		defer conn.Close()
   
     u := user.User{Name: "mock"}
-		msg := message.Message{Sender: u, Content: "hello"}
+		msg := message.Message{Sender: message.Sender{Name: u.Name}, Content: "hello"}
     if err = json.NewEncoder(conn).Encode(msg); err != nil {
       t.Fatal(err)
     }
@@ -203,8 +215,162 @@ func TestClientServer(t *testing.T) {
       t.Fatal(err)
     }
 
+    if got.ID == "" || got.Seq == 0 {
+      t.Errorf("Got unstamped message %v", got)
+    }
+    got.ID, got.Seq, got.Time = "", 0, time.Time{}
+    msg.ContentType = message.PlainContent
+
     if !reflect.DeepEqual(msg, got) {
-      t.Errorf("Got %s, want %s", msg, got)
+      t.Errorf("Got %v, want %v", msg, got)
     }
 	})
 }
+
+func TestDisconnectSession(t *testing.T) {
+	t.Run("Disconnect every client of a session", func(t *testing.T) {
+		reg := make(channel.InMemoryRegistrar)
+		evi := channel.NewInMemoryEvictor(reg.Unregister, 10, 10*time.Second)
+		bro := channel.NewDefaultBroadcaster(reg.List, evi.Evict)
+		clientSrv := NewMockClientServer(reg, bro)
+		srv := httptest.NewServer(http.HandlerFunc(clientSrv.clientServeHandler))
+		defer srv.Close()
+
+		url := "ws" + strings.TrimPrefix(srv.URL, "http")
+		dial := func(session string) *websocket.Conn {
+			wsConn, _, err := websocket.DefaultDialer.Dial(url+"?session="+session, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			return wsConn
+		}
+
+		revoked := []*websocket.Conn{dial("revoked"), dial("revoked")}
+		kept := dial("kept")
+		defer kept.Close()
+
+		// Wait for the clients to be tracked before disconnecting them.
+		deadline := time.Now().Add(time.Second)
+		for len(clientSrv.clientStore.List("revoked")) != len(revoked) {
+			if time.Now().After(deadline) {
+				t.Fatal("clients weren't tracked")
+			}
+			time.Sleep(time.Millisecond)
+		}
+
+		clientSrv.DisconnectSession("revoked")
+
+		for _, wsConn := range revoked {
+			wsConn.SetReadDeadline(time.Now().Add(time.Second))
+			if _, _, err := wsConn.ReadMessage(); !websocket.IsUnexpectedCloseError(err) {
+				t.Errorf("Got %v, Want a closed connection", err)
+			}
+		}
+
+		if got := len(clientSrv.clientStore.List("kept")); got != 1 {
+			t.Errorf("Got %d clients for kept session, Want 1", got)
+		}
+	})
+}
+
+func TestSendToUser(t *testing.T) {
+	t.Run("Send to every connection of a user", func(t *testing.T) {
+		reg := make(channel.InMemoryRegistrar)
+		evi := channel.NewInMemoryEvictor(reg.Unregister, 10, 10*time.Second)
+		bro := channel.NewDefaultBroadcaster(reg.List, evi.Evict)
+		clientSrv := NewMockClientServer(reg, bro)
+		srv := httptest.NewServer(http.HandlerFunc(clientSrv.clientServeHandler))
+		defer srv.Close()
+
+		url := "ws" + strings.TrimPrefix(srv.URL, "http")
+		dial := func(id string) *websocket.Conn {
+			wsConn, _, err := websocket.DefaultDialer.Dial(url+"?user="+id, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			t.Cleanup(func() { wsConn.Close() })
+			return wsConn
+		}
+
+		recipients := []*websocket.Conn{dial("recipient"), dial("recipient")}
+		other := dial("other")
+
+		deadline := time.Now().Add(time.Second)
+		for len(clientSrv.userStore.List("recipient")) != len(recipients) {
+			if time.Now().After(deadline) {
+				t.Fatal("clients weren't tracked")
+			}
+			time.Sleep(time.Millisecond)
+		}
+
+		if sent := clientSrv.SendToUser("recipient", []byte("direct")); sent != len(recipients) {
+			t.Errorf("Sent to %d connections, Want %d", sent, len(recipients))
+		}
+
+		for _, wsConn := range recipients {
+			wsConn.SetReadDeadline(time.Now().Add(time.Second))
+			if _, data, err := wsConn.ReadMessage(); err != nil || string(data) != "direct" {
+				t.Errorf("Got %q, %v, Want direct", data, err)
+			}
+		}
+
+		other.SetReadDeadline(time.Now().Add(50 * time.Millisecond))
+		if _, data, err := other.ReadMessage(); err == nil {
+			t.Errorf("Other user got %q", data)
+		}
+	})
+}
+
+func TestReadOnlyClient(t *testing.T) {
+	t.Run("Read only client doesn't broadcast", func(t *testing.T) {
+		lockBuf := NewLockBuffer()
+		waitBuf := NewWaitBuffer()
+		conn := NewMockConnection(lockBuf, waitBuf)
+		reg := make(channel.InMemoryRegistrar)
+		evi := channel.NewInMemoryEvictor(reg.Unregister, 10, 10*time.Second)
+		bcast := channel.NewDefaultBroadcaster(reg.List, evi.Evict)
+		chann := channel.NewChannel(reg, bcast)
+
+		go chann.Start()
+		defer chann.Stop()
+
+		u := user.User{Name: "reader"}
+		client := NewClient(conn, u, chann, ReadOnly())
+
+		go client.Start()
+		defer client.Stop()
+
+		waitBuf.Add(1)
+		client.Receive() <- []byte{}
+		waitBuf.Wait()
+
+		// A broadcast would reach the client itself between the refusal and
+		// the marker.
+		waitBuf.Add(1)
+		json.NewEncoder(lockBuf).Encode(message.Message{Content: "hello"})
+		waitBuf.Wait()
+		time.Sleep(50 * time.Millisecond)
+
+		waitBuf.Add(1)
+		chann.BroadcastMessage <- message.Message{Sender: message.Sender{Name: u.Name}, Content: "marker"}
+		waitBuf.Wait()
+
+		dec := json.NewDecoder(&waitBuf.Buffer)
+		var refusal channel.ErrorEvent
+		if err := dec.Decode(&refusal); err != nil {
+			t.Fatal(err)
+		}
+		if refusal.Type != channel.ErrorEventType || refusal.Code != "read_only" {
+			t.Errorf("Got %+v, want the refusal", refusal)
+		}
+
+		var got message.Message
+		if err := dec.Decode(&got); err != nil {
+			t.Fatal(err)
+		}
+
+		if got.Content != "marker" {
+			t.Errorf("Got %v, want the marker message first", got)
+		}
+	})
+}
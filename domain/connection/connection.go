@@ -1,6 +1,7 @@
 package connection
 
 import (
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
@@ -15,33 +16,52 @@ var (
 	}
 )
 
+// ErrMessageTooLarge is returned by Read for a message over the read limit,
+// the next Read starts with the following message.
+var ErrMessageTooLarge = errors.New("message too large")
+
 type ConnectionUpgrader interface {
   Upgrade(w http.ResponseWriter, r *http.Request) (io.ReadWriteCloser, error)
 }
 
 type GorillaUpgrader struct {
-	upgrader websocket.Upgrader
+	upgrader  websocket.Upgrader
+	readLimit int64
 }
 
-func NewGorillaUpgrader() *GorillaUpgrader {
+// NewGorillaUpgrader echoes back the first of subprotocols the client offers.
+func NewGorillaUpgrader(subprotocols ...string) *GorillaUpgrader {
+	u := defaultUpgrader
+	u.Subprotocols = subprotocols
 	return &GorillaUpgrader{
-		upgrader: defaultUpgrader,
+		upgrader: u,
 	}
 }
 
+// WithReadLimit refuses messages over limit bytes, see ErrMessageTooLarge.
+// Zero lets connections send any.
+func (u GorillaUpgrader) WithReadLimit(limit int64) *GorillaUpgrader {
+	u.readLimit = limit
+	return &u
+}
+
 func (u GorillaUpgrader) Upgrade(w http.ResponseWriter, r *http.Request) (io.ReadWriteCloser, error) {
 	c, err := u.upgrader.Upgrade(w, r, nil)
 	if err != nil {
 		return nil, err
 	}
-
 	conn := NewConnection(c)
+	conn.limit = u.readLimit
 
 	return conn, err
 }
 
 type Connection struct {
-	conn *websocket.Conn
+	conn   *websocket.Conn
+	reader io.Reader
+	// limit bounds the bytes read of a message, length counts them.
+	limit  int64
+	length int64
 }
 
 func Dial(url string) (*Connection, error) {
@@ -59,22 +79,38 @@ func NewConnection(conn *websocket.Conn) *Connection {
 	}
 }
 
+// Read streams the text messages one after another, a message may take
+// several reads.
 func (c *Connection) Read(b []byte) (int, error) {
-	msgType, reader, err := c.conn.NextReader()
-	if err != nil {
-		return 0, err
-	}
-
-	if msgType != websocket.TextMessage {
-		return 0, fmt.Errorf("wrong message type!")
+	for {
+		if c.reader == nil {
+			msgType, reader, err := c.conn.NextReader()
+			if err != nil {
+				return 0, err
+			}
+
+			if msgType != websocket.TextMessage {
+				return 0, fmt.Errorf("wrong message type!")
+			}
+			c.reader, c.length = reader, 0
+		}
+
+		n, err := c.reader.Read(b)
+		if c.length += int64(n); c.limit > 0 && c.length > c.limit {
+			// The websocket skips what's left of it with the next message.
+			c.reader = nil
+			return 0, ErrMessageTooLarge
+		}
+		if errors.Is(err, io.EOF) {
+			c.reader = nil
+			if n == 0 {
+				continue
+			}
+			err = nil
+		}
+
+		return n, err
 	}
-
-	n, err := reader.Read(b)
-	if err != nil {
-		return 0, err
-	}
-
-	return n, err
 }
 
 func (c *Connection) Write(b []byte) (int, error) {
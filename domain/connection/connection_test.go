@@ -2,6 +2,7 @@ package connection
 
 import (
 	"encoding/gob"
+	"errors"
 	"net/http"
 	"net/http/httptest"
 	"strings"
@@ -62,3 +63,37 @@ func TestReadWriteClose(t *testing.T) {
 		}
 	}
 }
+
+func TestReadLimit(t *testing.T) {
+	read := make(chan error, 2)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.WithReadLimit(8).Upgrade(w, r)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		buf := make([]byte, 512)
+		for range 2 {
+			_, err := conn.Read(buf)
+			read <- err
+		}
+	}))
+	defer srv.Close()
+
+	conn, err := Dial("ws" + strings.TrimPrefix(srv.URL, "http"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	conn.Write([]byte("far too long"))
+	conn.Write([]byte("short"))
+
+	if err := <-read; !errors.Is(err, ErrMessageTooLarge) {
+		t.Errorf("Got %v, Want %v", err, ErrMessageTooLarge)
+	}
+	if err := <-read; err != nil {
+		t.Errorf("Got %v, Want the next message read", err)
+	}
+}
@@ -0,0 +1,159 @@
+package contact
+
+import (
+	"cmp"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/DanyPops/logues/domain/user"
+)
+
+const (
+	RequestEventType  = "contact_request"
+	AcceptedEventType = "contact_accepted"
+)
+
+var (
+	ErrSelf            = errors.New("can't add yourself as a contact")
+	ErrRequestExists   = errors.New("contact request already pending")
+	ErrAlreadyContacts = errors.New("already contacts")
+	ErrNoRequest       = errors.New("no pending contact request")
+)
+
+// Request asks the user To to become a contact of From, both are user IDs.
+type Request struct {
+	From    string    `json:"from"`
+	To      string    `json:"to"`
+	Created time.Time `json:"created_at"`
+}
+
+// Event tells a user about a request sent to them or one of theirs being
+// accepted, User is the other side.
+type Event struct {
+	Type string    `json:"type"`
+	User user.User `json:"user"`
+	Request
+}
+
+// Store keeps the contact graph, contacts are mutual and only made by
+// accepting a request.
+type Store interface {
+	Request(from, to string) (Request, error)
+	Accept(from, to string) error
+	Decline(from, to string) error
+	Contacts(id string) ([]string, error)
+	Pending(id string) ([]Request, error)
+}
+
+type pair struct {
+	from, to string
+}
+
+type InMemoryStore struct {
+	lock     *sync.RWMutex
+	requests map[pair]Request
+	contacts map[string]map[string]struct{}
+}
+
+func NewInMemoryStore() InMemoryStore {
+	return InMemoryStore{
+		lock:     new(sync.RWMutex),
+		requests: make(map[pair]Request),
+		contacts: make(map[string]map[string]struct{}),
+	}
+}
+
+// Request fails with ErrRequestExists when either side already asked the
+// other.
+func (s InMemoryStore) Request(from, to string) (Request, error) {
+	if from == to {
+		return Request{}, ErrSelf
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if _, ok := s.contacts[from][to]; ok {
+		return Request{}, ErrAlreadyContacts
+	}
+
+	if _, ok := s.requests[pair{from, to}]; ok {
+		return Request{}, ErrRequestExists
+	}
+	if _, ok := s.requests[pair{to, from}]; ok {
+		return Request{}, ErrRequestExists
+	}
+
+	r := Request{From: from, To: to, Created: time.Now()}
+	s.requests[pair{from, to}] = r
+	return r, nil
+}
+
+// Accept makes the sender of the request from and its recipient to
+// contacts.
+func (s InMemoryStore) Accept(from, to string) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if _, ok := s.requests[pair{from, to}]; !ok {
+		return fmt.Errorf("%w: from %s", ErrNoRequest, from)
+	}
+	delete(s.requests, pair{from, to})
+
+	for _, p := range []pair{{from, to}, {to, from}} {
+		if s.contacts[p.from] == nil {
+			s.contacts[p.from] = make(map[string]struct{})
+		}
+		s.contacts[p.from][p.to] = struct{}{}
+	}
+
+	return nil
+}
+
+func (s InMemoryStore) Decline(from, to string) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if _, ok := s.requests[pair{from, to}]; !ok {
+		return fmt.Errorf("%w: from %s", ErrNoRequest, from)
+	}
+	delete(s.requests, pair{from, to})
+
+	return nil
+}
+
+// Contacts lists the IDs of the contacts of the user with id, sorted.
+func (s InMemoryStore) Contacts(id string) ([]string, error) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	contacts := make([]string, 0, len(s.contacts[id]))
+	for contact := range s.contacts[id] {
+		contacts = append(contacts, contact)
+	}
+	slices.Sort(contacts)
+
+	return contacts, nil
+}
+
+// Pending lists the requests sent to the user with id, oldest first.
+func (s InMemoryStore) Pending(id string) ([]Request, error) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	pending := make([]Request, 0)
+	for p, r := range s.requests {
+		if p.to == id {
+			pending = append(pending, r)
+		}
+	}
+	slices.SortFunc(pending, func(a, b Request) int {
+		return cmp.Or(a.Created.Compare(b.Created), strings.Compare(a.From, b.From))
+	})
+
+	return pending, nil
+}
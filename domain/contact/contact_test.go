@@ -0,0 +1,61 @@
+package contact
+
+import (
+	"errors"
+	"slices"
+	"testing"
+)
+
+func TestInMemoryStore(t *testing.T) {
+	s := NewInMemoryStore()
+
+	if _, err := s.Request("alice", "alice"); !errors.Is(err, ErrSelf) {
+		t.Errorf("Got %v, Want %v", err, ErrSelf)
+	}
+
+	if _, err := s.Request("alice", "bob"); err != nil {
+		t.Fatal(err)
+	}
+	s.Request("carol", "bob")
+
+	for _, from := range []string{"alice", "bob"} {
+		to := map[string]string{"alice": "bob", "bob": "alice"}[from]
+		if _, err := s.Request(from, to); !errors.Is(err, ErrRequestExists) {
+			t.Errorf("%s got %v, Want %v", from, err, ErrRequestExists)
+		}
+	}
+
+	pending, _ := s.Pending("bob")
+	if len(pending) != 2 || pending[0].From != "alice" || pending[1].From != "carol" {
+		t.Errorf("Unexpected pending requests %+v", pending)
+	}
+
+	if err := s.Accept("bob", "alice"); !errors.Is(err, ErrNoRequest) {
+		t.Errorf("Recipient accepted own request, got %v", err)
+	}
+
+	if err := s.Accept("alice", "bob"); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Decline("carol", "bob"); err != nil {
+		t.Fatal(err)
+	}
+
+	if pending, _ := s.Pending("bob"); len(pending) != 0 {
+		t.Errorf("Got pending %+v after answering all", pending)
+	}
+
+	for id, want := range map[string][]string{"alice": {"bob"}, "bob": {"alice"}, "carol": {}} {
+		if got, _ := s.Contacts(id); !slices.Equal(got, want) {
+			t.Errorf("%s got contacts %v, Want %v", id, got, want)
+		}
+	}
+
+	if _, err := s.Request("bob", "alice"); !errors.Is(err, ErrAlreadyContacts) {
+		t.Errorf("Got %v, Want %v", err, ErrAlreadyContacts)
+	}
+
+	if err := s.Decline("carol", "bob"); !errors.Is(err, ErrNoRequest) {
+		t.Errorf("Got %v, Want %v", err, ErrNoRequest)
+	}
+}
@@ -0,0 +1,122 @@
+package message
+
+import (
+	"fmt"
+	"mime"
+	"net/url"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+)
+
+// ContentType tells clients how to render the content of a message.
+type ContentType string
+
+const (
+	PlainContent    ContentType = "plain"
+	MarkdownContent ContentType = "markdown"
+	// CodeContent is source code in Language.
+	CodeContent ContentType = "code"
+	// AttachmentContent references a file, the content is its caption.
+	AttachmentContent ContentType = "attachment"
+	// SystemContent comes from the server, users can't send it.
+	SystemContent ContentType = "system"
+)
+
+const (
+	maxAttachmentURLLength  = 2048
+	maxAttachmentNameLength = 255
+)
+
+// Languages are named like the info strings of Markdown code blocks.
+var languagePattern = regexp.MustCompile(`^[a-z0-9][a-z0-9+#._-]{0,31}$`)
+
+// Attachment references a file kept elsewhere, Size is in bytes.
+type Attachment struct {
+	URL      string `json:"url"`
+	Name     string `json:"name"`
+	MIMEType string `json:"mime_type"`
+	Size     int64  `json:"size"`
+}
+
+// ContentError reports why the content of a message was refused, Field is
+// the JSON field at fault.
+type ContentError struct {
+	Field  string
+	Reason string
+	// code is told to the sender instead of the <field>_invalid default.
+	code string
+}
+
+func (e ContentError) Error() string {
+	return fmt.Sprintf("%s %s", e.Field, e.Reason)
+}
+
+// Code tells the sender what was wrong.
+func (e ContentError) Code() string {
+	if e.code != "" {
+		return e.code
+	}
+
+	return e.Field + "_invalid"
+}
+
+// CheckContent validates the payload a user sent for its content type,
+// returning m with the type set as plain when it had none.
+func (m Message) CheckContent() (Message, error) {
+	if m.ContentType == "" {
+		m.ContentType = PlainContent
+	}
+
+	if m.ContentType != CodeContent && m.Language != "" {
+		return m, ContentError{Field: "language", Reason: "is only for code"}
+	}
+	if m.ContentType != AttachmentContent && m.Attachment != nil {
+		return m, ContentError{Field: "attachment", Reason: "is only for attachments"}
+	}
+
+	switch m.ContentType {
+	case PlainContent, MarkdownContent:
+	case CodeContent:
+		if !languagePattern.MatchString(m.Language) {
+			return m, ContentError{Field: "language", Reason: "must be a lowercase language name like go"}
+		}
+	case AttachmentContent:
+		return m, m.Attachment.check()
+	case SystemContent:
+		return m, ContentError{Field: "content_type", Reason: "system is only sent by the server"}
+	default:
+		return m, ContentError{Field: "content_type", Reason: "must be plain, markdown, code or attachment"}
+	}
+
+	if m.Content == "" {
+		return m, ContentError{Field: "content", Reason: "must not be empty"}
+	}
+
+	return m, nil
+}
+
+func (a *Attachment) check() error {
+	if a == nil {
+		return ContentError{Field: "attachment", Reason: "is required"}
+	}
+
+	u, err := url.Parse(a.URL)
+	if err != nil || len(a.URL) > maxAttachmentURLLength || (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" {
+		return ContentError{Field: "attachment", Reason: "url must be an absolute http(s) URL"}
+	}
+
+	if a.Name == "" || len(a.Name) > maxAttachmentNameLength || !utf8.ValidString(a.Name) || strings.ContainsAny(a.Name, `/\`) {
+		return ContentError{Field: "attachment", Reason: fmt.Sprintf("name must be a file name of at most %d bytes", maxAttachmentNameLength)}
+	}
+
+	if mediaType, _, err := mime.ParseMediaType(a.MIMEType); err != nil || !strings.Contains(mediaType, "/") {
+		return ContentError{Field: "attachment", Reason: "mime_type must be a media type like image/png"}
+	}
+
+	if a.Size < 0 {
+		return ContentError{Field: "attachment", Reason: "size can't be negative"}
+	}
+
+	return nil
+}
@@ -0,0 +1,51 @@
+package message
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestCheckContent(t *testing.T) {
+	file := func(f func(*Attachment)) *Attachment {
+		a := &Attachment{URL: "https://files.example.com/a.png", Name: "a.png", MIMEType: "image/png", Size: 42}
+		if f != nil {
+			f(a)
+		}
+		return a
+	}
+
+	for name, c := range map[string]struct {
+		msg   Message
+		field string
+	}{
+		"plain by default":       {Message{Content: "hi"}, ""},
+		"markdown":               {Message{ContentType: MarkdownContent, Content: "**hi**"}, ""},
+		"code":                   {Message{ContentType: CodeContent, Content: "x := 1", Language: "go"}, ""},
+		"attachment":             {Message{ContentType: AttachmentContent, Attachment: file(nil)}, ""},
+		"empty":                  {Message{}, "content"},
+		"unknown type":           {Message{ContentType: "video", Content: "hi"}, "content_type"},
+		"system":                 {Message{ContentType: SystemContent, Content: "hi"}, "content_type"},
+		"code without language":  {Message{ContentType: CodeContent, Content: "x"}, "language"},
+		"bad language":           {Message{ContentType: CodeContent, Content: "x", Language: "Go Lang"}, "language"},
+		"language of plain":      {Message{Content: "hi", Language: "go"}, "language"},
+		"attachment of markdown": {Message{ContentType: MarkdownContent, Content: "hi", Attachment: file(nil)}, "attachment"},
+		"missing attachment":     {Message{ContentType: AttachmentContent}, "attachment"},
+		"relative url":           {Message{ContentType: AttachmentContent, Attachment: file(func(a *Attachment) { a.URL = "/a.png" })}, "attachment"},
+		"script url":             {Message{ContentType: AttachmentContent, Attachment: file(func(a *Attachment) { a.URL = "javascript:alert(1)" })}, "attachment"},
+		"path as name":           {Message{ContentType: AttachmentContent, Attachment: file(func(a *Attachment) { a.Name = "../a.png" })}, "attachment"},
+		"bad mime type":          {Message{ContentType: AttachmentContent, Attachment: file(func(a *Attachment) { a.MIMEType = "png" })}, "attachment"},
+		"negative size":          {Message{ContentType: AttachmentContent, Attachment: file(func(a *Attachment) { a.Size = -1 })}, "attachment"},
+	} {
+		got, err := c.msg.CheckContent()
+
+		var contentErr ContentError
+		switch {
+		case c.field == "" && err != nil:
+			t.Errorf("%s: Got %v", name, err)
+		case c.field == "" && got.ContentType == "":
+			t.Errorf("%s: Got no content type", name)
+		case c.field != "" && (!errors.As(err, &contentErr) || contentErr.Field != c.field):
+			t.Errorf("%s: Got %v, Want an error of %s", name, err, c.field)
+		}
+	}
+}
@@ -0,0 +1,79 @@
+package message
+
+import (
+	"errors"
+	"time"
+)
+
+const (
+	EditedEventType  = "message_edited"
+	DeletedEventType = "message_deleted"
+)
+
+var ErrDeleted = errors.New("message deleted")
+
+// Version is content a message had before an edit, Time is when it was
+// written.
+type Version struct {
+	Content string    `json:"content"`
+	Time    time.Time `json:"time"`
+}
+
+// UpdateEvent announces an edit or deletion of the message with ID, ParentID
+// is set when it's a reply.
+type UpdateEvent struct {
+	Type     string    `json:"type"`
+	ID       string    `json:"id"`
+	ParentID string    `json:"parent_id,omitempty"`
+	SenderID string    `json:"sender_id"`
+	Content  string    `json:"content,omitempty"`
+	Mentions Mentions  `json:"mentions,omitzero"`
+	Edited   time.Time `json:"edited_at,omitzero"`
+}
+
+// SentBy lets receivers who blocked the sender skip the event like they
+// skipped the message.
+func (e UpdateEvent) SentBy() string {
+	return e.SenderID
+}
+
+// InThread keeps updates of replies to the subscribers of their thread.
+func (e UpdateEvent) InThread() string {
+	return e.ParentID
+}
+
+// Edit replaces the content of m, tombstones can't be edited.
+func (m Message) Edit(content string, now time.Time) (Message, error) {
+	if m.Deleted {
+		return m, ErrDeleted
+	}
+
+	m.Content, m.Edited = content, now
+	return m, nil
+}
+
+// Delete turns m into a tombstone, which keeps its place in the channel.
+func (m Message) Delete() Message {
+	m.Content, m.Deleted, m.Reactions, m.Mentions = "", true, nil, Mentions{}
+	m.ContentType, m.Language, m.Attachment = "", "", nil
+	return m
+}
+
+// version is the content of m as of its last edit.
+func (m Message) version() Version {
+	written := m.Edited
+	if written.IsZero() {
+		written = m.Time
+	}
+
+	return Version{Content: m.Content, Time: written}
+}
+
+// Event announces the current state of m.
+func (m Message) Event() UpdateEvent {
+	if m.Deleted {
+		return UpdateEvent{Type: DeletedEventType, ID: m.ID, ParentID: m.ParentID, SenderID: m.SenderID}
+	}
+
+	return UpdateEvent{Type: EditedEventType, ID: m.ID, ParentID: m.ParentID, SenderID: m.SenderID, Content: m.Content, Mentions: m.Mentions, Edited: m.Edited}
+}
@@ -0,0 +1,44 @@
+package message
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestEdit(t *testing.T) {
+	sent := time.Now()
+	m := Message{ID: "id", SenderID: "sender", Time: sent, Content: "teh"}
+
+	edited, err := m.Edit("the", sent.Add(time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	e := edited.Event()
+	if e.Type != EditedEventType || e.ID != "id" || e.Content != "the" || !e.Edited.Equal(sent.Add(time.Minute)) {
+		t.Errorf("Unexpected event %+v", e)
+	}
+
+	if v := m.version(); v.Content != "teh" || !v.Time.Equal(sent) {
+		t.Errorf("Unexpected version %+v", v)
+	}
+
+	if v := edited.version(); !v.Time.Equal(edited.Edited) {
+		t.Errorf("Got version of %v, Want the edit time", v.Time)
+	}
+
+	deleted := edited.Delete()
+	if deleted.Content != "" || !deleted.Deleted || deleted.Event().Type != DeletedEventType {
+		t.Errorf("Unexpected tombstone %+v", deleted)
+	}
+
+	if _, err := deleted.Edit("back", time.Now()); !errors.Is(err, ErrDeleted) {
+		t.Errorf("Got %v, Want %v", err, ErrDeleted)
+	}
+
+	file := Message{ContentType: AttachmentContent, Attachment: &Attachment{URL: "https://example.com/a.png", Name: "a.png"}}
+	if deleted := file.Delete(); deleted.Attachment != nil || deleted.ContentType != "" {
+		t.Errorf("Tombstone kept the attachment %+v", deleted)
+	}
+}
@@ -0,0 +1,47 @@
+package message
+
+import (
+	"crypto/rand"
+	"encoding/base32"
+	"encoding/binary"
+	"sync"
+	"time"
+)
+
+// MaxClientIDLength bounds the IDs senders pick for their messages.
+const MaxClientIDLength = 64
+
+// Crockford's alphabet is in ASCII order, so IDs sort like their bytes.
+var idEncoding = base32.NewEncoding("0123456789abcdefghjkmnpqrstvwxyz").WithPadding(base32.NoPadding)
+
+// ids makes IDs of 48 bits of milliseconds followed by 80 random bits. IDs
+// made within the same millisecond increment the random bits instead, which
+// keeps them in order.
+var ids = struct {
+	lock *sync.Mutex
+	last [16]byte
+}{lock: new(sync.Mutex)}
+
+// NewID returns a unique ID that sorts after every ID made before it.
+func NewID(now time.Time) string {
+	ids.lock.Lock()
+	defer ids.lock.Unlock()
+
+	var id [16]byte
+	binary.BigEndian.PutUint64(id[:8], uint64(now.UnixMilli())<<16)
+
+	if string(id[:6]) <= string(ids.last[:6]) {
+		id = ids.last
+		for i := len(id) - 1; i >= 6; i-- {
+			id[i]++
+			if id[i] != 0 {
+				break
+			}
+		}
+	} else {
+		rand.Read(id[6:])
+	}
+
+	ids.last = id
+	return idEncoding.EncodeToString(id[:])
+}
@@ -0,0 +1,28 @@
+package message
+
+import (
+	"testing"
+	"time"
+)
+
+func TestNewID(t *testing.T) {
+	now := time.Now()
+	earlier := NewID(now)
+
+	seen := map[string]bool{earlier: true}
+	for i := range 1000 {
+		// The clock going back doesn't break the order either.
+		id := NewID(now.Add(time.Duration(i%3-1) * time.Millisecond))
+		if id <= earlier || seen[id] {
+			t.Fatalf("Got %s after %s", id, earlier)
+		}
+		if len(id) != 26 {
+			t.Fatalf("Got %s of length %d", id, len(id))
+		}
+		seen[id], earlier = true, id
+	}
+
+	if later := NewID(now.Add(time.Hour)); later <= earlier {
+		t.Errorf("Got %s after %s", later, earlier)
+	}
+}
@@ -0,0 +1,161 @@
+package message
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+const (
+	// DefaultMaxLength bounds content in characters.
+	DefaultMaxLength = 4000
+	// Bytes a character can take in JSON, escaped as a surrogate pair.
+	maxEncodedCharSize = 12
+	// Room for the JSON around the content: the client ID, language and
+	// attachment.
+	frameOverhead = 4096
+)
+
+var forms = map[string]norm.Form{
+	"NFC":  norm.NFC,
+	"NFD":  norm.NFD,
+	"NFKC": norm.NFKC,
+	"NFKD": norm.NFKD,
+}
+
+// InboundConfig sets what the Inbound pipeline lets through.
+type InboundConfig struct {
+	// MaxLength bounds content in characters once normalized, zero doesn't.
+	MaxLength int
+	// Form is the Unicode normalization form of content: NFC, NFD, NFKC or
+	// NFKD. Empty keeps content as it was sent.
+	Form string
+}
+
+func NewInboundConfig() InboundConfig {
+	return InboundConfig{
+		MaxLength: DefaultMaxLength,
+		Form:      "NFC",
+	}
+}
+
+// Inbound cleans up what users send before the channel gets it: content
+// must be valid UTF-8, loses its control and bidi override characters and is
+// normalized before its length is checked. The zero Inbound neither
+// normalizes nor bounds content.
+type Inbound struct {
+	maxLength int
+	form      *norm.Form
+}
+
+func NewInbound(c InboundConfig) (Inbound, error) {
+	in := Inbound{maxLength: c.MaxLength}
+	if c.MaxLength < 0 {
+		return in, fmt.Errorf("negative max length %d", c.MaxLength)
+	}
+
+	if c.Form != "" {
+		form, ok := forms[strings.ToUpper(c.Form)]
+		if !ok {
+			return in, fmt.Errorf("unknown normalization form %q", c.Form)
+		}
+		in.form = &form
+	}
+
+	return in, nil
+}
+
+// DecodeJSON unmarshals data into v, or refuses it with a ContentError when
+// it isn't valid UTF-8. Decoding would replace invalid bytes instead of
+// refusing them.
+func DecodeJSON(data []byte, v any) error {
+	if !utf8.Valid(data) {
+		return ContentError{Field: "message", Reason: "must be valid UTF-8", code: "message_not_utf8"}
+	}
+
+	return json.Unmarshal(data, v)
+}
+
+// FrameLimit bounds the size of frames carrying content within the max
+// length, so nothing longer is read in the first place. Zero doesn't.
+func (in Inbound) FrameLimit() int64 {
+	if in.maxLength == 0 {
+		return 0
+	}
+
+	return int64(in.maxLength)*maxEncodedCharSize + frameOverhead
+}
+
+// Clean returns m with its content and attachment name cleaned up, or a
+// ContentError when either can't be.
+func (in Inbound) Clean(m Message) (Message, error) {
+	content, err := in.clean("content", m.Content)
+	if err != nil {
+		return m, err
+	}
+	m.Content = content
+
+	if m.Attachment != nil {
+		a := *m.Attachment
+		if a.Name, err = in.clean("attachment", a.Name); err != nil {
+			return m, err
+		}
+		m.Attachment = &a
+	}
+
+	return m, nil
+}
+
+// Text cleans up s like content without bounding its length, for other text
+// users show each other like their profiles.
+func (in Inbound) Text(field, s string) (string, error) {
+	if !utf8.ValidString(s) {
+		return s, ContentError{Field: field, Reason: "must be valid UTF-8", code: field + "_not_utf8"}
+	}
+
+	s = strings.Map(func(r rune) rune {
+		if unsafe(r) {
+			return -1
+		}
+		return r
+	}, s)
+
+	if in.form != nil {
+		s = in.form.String(s)
+	}
+
+	return s, nil
+}
+
+func (in Inbound) clean(field, s string) (string, error) {
+	s, err := in.Text(field, s)
+	if err != nil {
+		return s, err
+	}
+
+	if in.maxLength > 0 && utf8.RuneCountInString(s) > in.maxLength {
+		return s, ContentError{Field: field, Reason: fmt.Sprintf("must be at most %d characters", in.maxLength), code: field + "_too_long"}
+	}
+
+	return s, nil
+}
+
+// unsafe tells if r is stripped from content: control characters besides
+// newlines and tabs, and the bidi controls that reorder the text around
+// them, which can make content read other than it is.
+func unsafe(r rune) bool {
+	switch {
+	case r == '\n' || r == '\t':
+		return false
+	case unicode.IsControl(r):
+		return true
+	case r >= '\u202a' && r <= '\u202e', r >= '\u2066' && r <= '\u2069':
+		return true
+	}
+
+	return false
+}
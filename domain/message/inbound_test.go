@@ -0,0 +1,59 @@
+package message
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestInbound(t *testing.T) {
+	in, err := NewInbound(InboundConfig{MaxLength: 5, Form: "nfc"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for content, want := range map[string]string{
+		"hi":                            "hi",
+		"a\nb\tc":                       "a\nb\tc",
+		"a\x00b\x1bc\r":                 "abc",
+		"\u202eevil\u202c":              "evil",
+		"\u2066x\u2069":                 "x",
+		"cafe\u0301":                    "caf\u00e9",
+		"e\u0301e\u0301e\u0301e\u0301e": "\u00e9\u00e9\u00e9\u00e9e",
+	} {
+		got, err := in.Clean(Message{Content: content})
+		if err != nil || got.Content != want {
+			t.Errorf("%q: Got %q %v, Want %q", content, got.Content, err, want)
+		}
+	}
+
+	for content, code := range map[string]string{
+		"toolong":            "content_too_long",
+		string([]byte{0xff}): "content_not_utf8",
+		"ok\xc3":             "content_not_utf8",
+	} {
+		var contentErr ContentError
+		if _, err := in.Clean(Message{Content: content}); !errors.As(err, &contentErr) || contentErr.Code() != code {
+			t.Errorf("%q: Got %v, Want %s", content, err, code)
+		}
+	}
+
+	got, _ := in.Clean(Message{Attachment: &Attachment{Name: "\u202ea.png"}})
+	if got.Attachment.Name != "a.png" {
+		t.Errorf("Got attachment name %q", got.Attachment.Name)
+	}
+
+	if _, err := NewInbound(InboundConfig{Form: "NFX"}); err == nil {
+		t.Error("Accepted an unknown normalization form")
+	}
+
+	if got, _ := (Inbound{}).Clean(Message{Content: "cafe\u0301 toolong"}); got.Content != "cafe\u0301 toolong" {
+		t.Errorf("Zero Inbound changed content to %q", got.Content)
+	}
+
+	// Content within the max length fits however it's escaped.
+	frame := `{"content":"` + strings.Repeat(`\ud83d\ude00`, 5) + `"}`
+	if limit := in.FrameLimit(); limit < int64(len(frame)) || (Inbound{}).FrameLimit() != 0 {
+		t.Errorf("Got frame limit %d", limit)
+	}
+}
@@ -0,0 +1,86 @@
+package message
+
+import (
+	"regexp"
+	"slices"
+	"strings"
+
+	"github.com/DanyPops/logues/domain/user"
+)
+
+const MentionEventType = "mention"
+
+// Handles of the special mentions, @channel reaches everyone connected to
+// the channel and @here only those active in it.
+const (
+	MentionChannel = "channel"
+	MentionHere    = "here"
+)
+
+// A mention is an @ that doesn't follow a handle's character or another @,
+// which leaves out email addresses.
+var mentionPattern = regexp.MustCompile(`(?:^|[^\w@.-])@([\w.-]+)`)
+
+// Mentions holds who a message mentions, Users are the IDs of the users
+// whose handles it names.
+type Mentions struct {
+	Users   []string `json:"users,omitempty"`
+	Channel bool     `json:"channel,omitempty"`
+	Here    bool     `json:"here,omitempty"`
+}
+
+// MentionEvent tells a user they were mentioned in Message.
+type MentionEvent struct {
+	Type    string  `json:"type"`
+	Message Message `json:"message"`
+}
+
+// ParseMentions finds the mentions in content, handles of users profiles
+// doesn't have or who are deactivated stay plain text.
+func ParseMentions(content string, profiles user.Store) Mentions {
+	var m Mentions
+	for _, match := range mentionPattern.FindAllStringSubmatch(content, -1) {
+		switch handle := match[1]; handle {
+		case MentionChannel:
+			m.Channel = true
+		case MentionHere:
+			m.Here = true
+		default:
+			if id, ok := resolve(handle, profiles); ok && !slices.Contains(m.Users, id) {
+				m.Users = append(m.Users, id)
+			}
+		}
+	}
+
+	return m
+}
+
+// resolve looks handle up as is, then without the dots ending a sentence.
+func resolve(handle string, profiles user.Store) (string, bool) {
+	if profiles == nil {
+		return "", false
+	}
+
+	for _, name := range []string{handle, strings.TrimRight(handle, ".")} {
+		if p, err := profiles.GetByHandle(name); err == nil && !p.Deactivated {
+			return p.ID, true
+		}
+	}
+
+	return "", false
+}
+
+// WithMentions attaches the mentions in the content of m, code has none.
+func (m Message) WithMentions(profiles user.Store) Message {
+	m.Mentions = Mentions{}
+	if m.ContentType != CodeContent {
+		m.Mentions = ParseMentions(m.Content, profiles)
+	}
+
+	return m
+}
+
+// MentionEvent tells a mentioned user about m.
+func (m Message) MentionEvent() MentionEvent {
+	return MentionEvent{Type: MentionEventType, Message: m}
+}
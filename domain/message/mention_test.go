@@ -0,0 +1,36 @@
+package message
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/DanyPops/logues/domain/user"
+)
+
+func TestParseMentions(t *testing.T) {
+	profiles := user.NewInMemoryStore()
+	profiles.Add(user.Profile{User: user.User{ID: "1", Name: "alice"}})
+	profiles.Add(user.Profile{User: user.User{ID: "2", Name: "bob.smith"}})
+	profiles.Add(user.Profile{User: user.User{ID: "3", Name: "gone"}, Deactivated: true})
+	profiles.Add(user.Profile{User: user.User{ID: "4", Name: "oidc:https://idp.test/42"}, Handle: "jane"})
+
+	for content, want := range map[string]Mentions{
+		"hi @alice":                     {Users: []string{"1"}},
+		"@alice, @bob.smith.":           {Users: []string{"1", "2"}},
+		"@alice @alice":                 {Users: []string{"1"}},
+		"thanks @jane":                  {Users: []string{"4"}},
+		"@channel and (@here)":          {Channel: true, Here: true},
+		"@nobody @gone":                 {},
+		"mail alice@example.com @@bob":  {},
+		"":                              {},
+		"@bob.smith-ish and @ALICE now": {},
+	} {
+		if got := ParseMentions(content, profiles); !reflect.DeepEqual(got, want) {
+			t.Errorf("%q: Got %+v, Want %+v", content, got, want)
+		}
+	}
+
+	if m := (Message{ContentType: CodeContent, Content: "@alice"}).WithMentions(profiles); len(m.Mentions.Users) != 0 {
+		t.Errorf("Got mentions %+v in code", m.Mentions)
+	}
+}
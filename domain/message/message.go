@@ -1,10 +1,71 @@
 package message
 
 import (
+	"time"
+
 	"github.com/DanyPops/logues/domain/user"
 )
 
+// Sender is how the sender looked when the message was sent, their current
+// profile is found through the message's SenderID.
+type Sender struct {
+	Name        string `json:"name"`
+	DisplayName string `json:"display_name,omitempty"`
+	AvatarURL   string `json:"avatar_url,omitempty"`
+}
+
+func NewSender(p user.Profile) Sender {
+	return Sender{
+		Name:        p.Name,
+		DisplayName: p.DisplayName,
+		AvatarURL:   p.AvatarURL,
+	}
+}
+
+// Message is sent by a user, the server stamps it with an ID and the time it
+// got it. Messages of a channel also carry their place in it as Seq, replies
+// their place in their thread, the two are numbered apart.
 type Message struct {
-  Sender  user.User `json:"user"`
-	Content string `json:"content"`
+	ID   string    `json:"id,omitempty"`
+	Seq  uint64    `json:"seq,omitempty"`
+	Time time.Time `json:"time,omitzero"`
+	// ClientID is picked by the sender to recognize the message, resending
+	// it with the same ClientID doesn't post it twice.
+	ClientID    string      `json:"client_id,omitempty"`
+	SenderID    string      `json:"sender_id,omitempty"`
+	Sender      Sender      `json:"user"`
+	ContentType ContentType `json:"content_type,omitempty"`
+	Content     string      `json:"content"`
+	// Language is set for code, Attachment for attachments.
+	Language   string      `json:"language,omitempty"`
+	Attachment *Attachment `json:"attachment,omitempty"`
+	Mentions   Mentions    `json:"mentions,omitzero"`
+	// ParentID is the message a reply belongs to, the Seq of replies
+	// counts within their thread.
+	ParentID string  `json:"parent_id,omitempty"`
+	Thread   *Thread `json:"thread,omitempty"`
+	// To is the user ID a direct message was sent to.
+	To string `json:"to,omitempty"`
+	// Muted is set for receivers who muted the sender.
+	Muted bool `json:"muted,omitempty"`
+	// Edited is when the content was last changed.
+	Edited time.Time `json:"edited_at,omitzero"`
+	// Deleted messages are tombstones without content.
+	Deleted   bool       `json:"deleted,omitempty"`
+	Reactions []Reaction `json:"reactions,omitempty"`
+}
+
+// From sets the sender of m to u, snapshotting their profile when profiles
+// has one.
+func (m Message) From(u user.User, profiles user.Store) Message {
+	m.SenderID = u.ID
+	m.Sender = Sender{Name: u.Name}
+
+	if profiles != nil {
+		if p, err := profiles.Get(u.ID); err == nil {
+			m.Sender = NewSender(p)
+		}
+	}
+
+	return m
 }
@@ -0,0 +1,200 @@
+package message
+
+import (
+	"slices"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+const (
+	ReactionAddedEventType   = "reaction_added"
+	ReactionRemovedEventType = "reaction_removed"
+
+	// Longest emoji sequences, like families or flags, are about this long.
+	emojiMaxLength = 32
+)
+
+// Reaction is an emoji users put on a message, Users are their IDs in the
+// order they reacted.
+type Reaction struct {
+	Emoji string   `json:"emoji"`
+	Count int      `json:"count"`
+	Users []string `json:"users"`
+}
+
+// ReactionEvent is the change of a single reaction, Count is how many users
+// reacted with Emoji after it. ParentID is set when the message is a reply.
+type ReactionEvent struct {
+	Type      string `json:"type"`
+	MessageID string `json:"message_id"`
+	ParentID  string `json:"parent_id,omitempty"`
+	Emoji     string `json:"emoji"`
+	UserID    string `json:"user_id"`
+	Count     int    `json:"count"`
+}
+
+func (e ReactionEvent) SentBy() string {
+	return e.UserID
+}
+
+// InThread keeps reactions to replies to the subscribers of their thread.
+func (e ReactionEvent) InThread() string {
+	return e.ParentID
+}
+
+// Code points that join or modify the emoji around them.
+const (
+	zeroWidthJoiner   = '\u200d'
+	variationSelector = '\ufe0f'
+	keycap            = '\u20e3'
+	cancelTag         = '\U000e007f'
+)
+
+// pictographs are the code points that are emoji by themselves.
+var pictographs = &unicode.RangeTable{
+	R16: []unicode.Range16{
+		{Lo: 0x00a9, Hi: 0x00ae, Stride: 5},
+		{Lo: 0x203c, Hi: 0x2049, Stride: 13},
+		{Lo: 0x2122, Hi: 0x2139, Stride: 23},
+		{Lo: 0x2194, Hi: 0x2199, Stride: 1},
+		{Lo: 0x21a9, Hi: 0x21aa, Stride: 1},
+		{Lo: 0x231a, Hi: 0x231b, Stride: 1},
+		{Lo: 0x2328, Hi: 0x23cf, Stride: 167},
+		{Lo: 0x23e9, Hi: 0x23f3, Stride: 1},
+		{Lo: 0x23f8, Hi: 0x23fa, Stride: 1},
+		{Lo: 0x24c2, Hi: 0x24c2, Stride: 1},
+		{Lo: 0x25aa, Hi: 0x25ab, Stride: 1},
+		{Lo: 0x25b6, Hi: 0x25c0, Stride: 10},
+		{Lo: 0x25fb, Hi: 0x25fe, Stride: 1},
+		{Lo: 0x2600, Hi: 0x27bf, Stride: 1},
+		{Lo: 0x2934, Hi: 0x2935, Stride: 1},
+		{Lo: 0x2b05, Hi: 0x2b07, Stride: 1},
+		{Lo: 0x2b1b, Hi: 0x2b1c, Stride: 1},
+		{Lo: 0x2b50, Hi: 0x2b55, Stride: 5},
+		{Lo: 0x3030, Hi: 0x303d, Stride: 13},
+		{Lo: 0x3297, Hi: 0x3299, Stride: 2},
+	},
+	R32: []unicode.Range32{
+		{Lo: 0x1f000, Hi: 0x1f1e5, Stride: 1},
+		{Lo: 0x1f200, Hi: 0x1f3fa, Stride: 1},
+		{Lo: 0x1f400, Hi: 0x1faff, Stride: 1},
+	},
+	LatinOffset: 1,
+}
+
+func isRegionalIndicator(r rune) bool { return r >= 0x1f1e6 && r <= 0x1f1ff }
+func isSkinTone(r rune) bool          { return r >= 0x1f3fb && r <= 0x1f3ff }
+func isTag(r rune) bool               { return r >= 0xe0020 && r <= 0xe007e }
+
+// ValidEmoji accepts a single emoji: a keycap, a flag, or pictographs each
+// optionally styled, skin toned or tagged and joined by zero width joiners.
+func ValidEmoji(emoji string) bool {
+	if len(emoji) > emojiMaxLength || !utf8.ValidString(emoji) {
+		return false
+	}
+
+	rs := []rune(emoji)
+	switch {
+	case len(rs) == 0:
+		return false
+
+	case rs[len(rs)-1] == keycap:
+		keys := rs[:len(rs)-1]
+		if len(keys) == 2 && keys[1] == variationSelector {
+			keys = keys[:1]
+		}
+		return len(keys) == 1 && strings.ContainsRune("0123456789#*", keys[0])
+
+	case isRegionalIndicator(rs[0]):
+		return len(rs) == 2 && isRegionalIndicator(rs[1])
+	}
+
+	for i := 0; ; i++ {
+		if i == len(rs) || !unicode.Is(pictographs, rs[i]) {
+			return false
+		}
+		if i+1 < len(rs) && (rs[i+1] == variationSelector || isSkinTone(rs[i+1])) {
+			i++
+		}
+
+		// Tags, like those of subdivision flags, run up to a cancel tag.
+		tagged := false
+		for i+1 < len(rs) && isTag(rs[i+1]) {
+			i, tagged = i+1, true
+		}
+		if tagged {
+			if i+1 == len(rs) || rs[i+1] != cancelTag {
+				return false
+			}
+			i++
+		}
+
+		switch {
+		case i+1 == len(rs):
+			return true
+		case rs[i+1] != zeroWidthJoiner:
+			return false
+		}
+		i++
+	}
+}
+
+// React adds the reaction of userID, false tells they had reacted with emoji
+// already.
+func (m Message) React(emoji, userID string) (Message, bool, error) {
+	if m.Deleted {
+		return m, false, ErrDeleted
+	}
+
+	m.Reactions = slices.Clone(m.Reactions)
+	i := slices.IndexFunc(m.Reactions, func(r Reaction) bool { return r.Emoji == emoji })
+	if i < 0 {
+		m.Reactions = append(m.Reactions, Reaction{Emoji: emoji})
+		i = len(m.Reactions) - 1
+	}
+
+	r := m.Reactions[i]
+	if slices.Contains(r.Users, userID) {
+		return m, false, nil
+	}
+
+	r.Users = append(slices.Clone(r.Users), userID)
+	r.Count = len(r.Users)
+	m.Reactions[i] = r
+	return m, true, nil
+}
+
+// Unreact removes the reaction of userID, false tells there was none.
+func (m Message) Unreact(emoji, userID string) (Message, bool) {
+	i := slices.IndexFunc(m.Reactions, func(r Reaction) bool { return r.Emoji == emoji })
+	if i < 0 || !slices.Contains(m.Reactions[i].Users, userID) {
+		return m, false
+	}
+
+	m.Reactions = slices.Clone(m.Reactions)
+	r := m.Reactions[i]
+	r.Users = slices.DeleteFunc(slices.Clone(r.Users), func(u string) bool { return u == userID })
+	r.Count = len(r.Users)
+	if r.Count == 0 {
+		m.Reactions = slices.Delete(m.Reactions, i, i+1)
+	} else {
+		m.Reactions[i] = r
+	}
+
+	return m, true
+}
+
+// ReactionEvent announces the change of emoji on m by userID.
+func (m Message) ReactionEvent(emoji, userID string, added bool) ReactionEvent {
+	e := ReactionEvent{Type: ReactionRemovedEventType, MessageID: m.ID, ParentID: m.ParentID, Emoji: emoji, UserID: userID}
+	if added {
+		e.Type = ReactionAddedEventType
+	}
+
+	if i := slices.IndexFunc(m.Reactions, func(r Reaction) bool { return r.Emoji == emoji }); i >= 0 {
+		e.Count = m.Reactions[i].Count
+	}
+
+	return e
+}
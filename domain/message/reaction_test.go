@@ -0,0 +1,81 @@
+package message
+
+import (
+	"errors"
+	"slices"
+	"testing"
+)
+
+func TestValidEmoji(t *testing.T) {
+	for emoji, want := range map[string]bool{
+		"👍":                  true,
+		"👨‍👩‍👧":              true,
+		"🇮🇱":                 true,
+		"❤️":                 true,
+		"":                   false,
+		"lol":                false,
+		"👍 ":                 false,
+		"1":                  false,
+		string([]byte{0xff}): false,
+		"👍👍👍👍👍👍👍👍👍👍👍👍👍👍👍👍👍": false,
+		"\U0001f44d\U0001f3fd": true,
+		"1\ufe0f\u20e3":        true,
+		"\U0001f3f4\U000e0067\U000e0062\U000e0073\U000e0063\U000e0074\U000e007f": true,
+		"\U0001f3f4\U000e0067\U000e0062":                                         false,
+		"!":                                                                      false,
+		"<>":                                                                     false,
+		"👍👍":                                                                     false,
+		"\U0001f44d\u200d":                                                       false,
+		"\U0001f1ee":                                                             false,
+		"a\u20e3":                                                                false,
+	} {
+		if got := ValidEmoji(emoji); got != want {
+			t.Errorf("%q: Got %t, Want %t", emoji, got, want)
+		}
+	}
+}
+
+func TestReact(t *testing.T) {
+	m := Message{ID: "id"}
+
+	m, added, _ := m.React("👍", "alice")
+	if !added {
+		t.Fatal("First reaction wasn't added")
+	}
+	m, _, _ = m.React("👍", "bob")
+	m, _, _ = m.React("🎉", "alice")
+
+	if _, added, _ := m.React("👍", "alice"); added {
+		t.Error("Reacted twice with the same emoji")
+	}
+
+	if e := m.ReactionEvent("👍", "bob", true); e.Type != ReactionAddedEventType || e.Count != 2 || e.MessageID != "id" {
+		t.Errorf("Unexpected event %+v", e)
+	}
+
+	unreacted, removed := m.Unreact("👍", "alice")
+	if !removed {
+		t.Fatal("Reaction wasn't removed")
+	}
+	if len(m.Reactions[0].Users) != 2 {
+		t.Error("Unreact changed the original message")
+	}
+	m = unreacted
+
+	m, _ = m.Unreact("🎉", "alice")
+	if _, removed := m.Unreact("🎉", "alice"); removed {
+		t.Error("Removed a missing reaction")
+	}
+
+	if len(m.Reactions) != 1 || m.Reactions[0].Emoji != "👍" || m.Reactions[0].Count != 1 || !slices.Equal(m.Reactions[0].Users, []string{"bob"}) {
+		t.Errorf("Unexpected reactions %+v", m.Reactions)
+	}
+
+	if e := m.ReactionEvent("🎉", "alice", false); e.Type != ReactionRemovedEventType || e.Count != 0 {
+		t.Errorf("Unexpected event %+v", e)
+	}
+
+	if _, _, err := m.Delete().React("👍", "carol"); !errors.Is(err, ErrDeleted) {
+		t.Errorf("Got %v, Want %v", err, ErrDeleted)
+	}
+}
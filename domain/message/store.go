@@ -0,0 +1,227 @@
+package message
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+)
+
+const (
+	// Messages a channel keeps for replays by default.
+	DefaultHistorySize = 10000
+	DefaultReplayLimit = 100
+	MaxReplayLimit     = 1000
+)
+
+var ErrNotFound = errors.New("message not found")
+
+// Store keeps the messages of a channel in the order of their Seq, and the
+// replies to each of them in the order of theirs.
+type Store interface {
+	Add(Message) error
+	Get(id string) (Message, error)
+	// Update replaces the message with m's ID, keeping its previous content
+	// as a version unless m is a tombstone, which drops them all.
+	Update(m Message) error
+	// Change updates the message with id to what f makes of it in one go,
+	// nothing changes when f fails.
+	Change(id string, f func(Message) (Message, error)) (Message, error)
+	// Versions lists the previous contents of a message, oldest first.
+	Versions(id string) ([]Version, error)
+	// After returns up to limit messages following seq.
+	After(seq uint64, limit int) ([]Message, error)
+	// Replies returns up to limit replies to the message with parentID
+	// following seq.
+	Replies(parentID string, seq uint64, limit int) ([]Message, error)
+}
+
+type replyIndex struct {
+	parent string
+	i      int
+}
+
+// InMemoryStore keeps the latest messages up to its size, along with their
+// replies.
+type InMemoryStore struct {
+	lock     *sync.RWMutex
+	size     int
+	messages []Message
+	seqs     map[string]uint64
+	replies  map[string][]Message
+	replyOf  map[string]replyIndex
+	versions map[string][]Version
+}
+
+// NewInMemoryStore keeps size messages, or the default when it's zero.
+func NewInMemoryStore(size int) *InMemoryStore {
+	if size <= 0 {
+		size = DefaultHistorySize
+	}
+
+	return &InMemoryStore{
+		lock:     new(sync.RWMutex),
+		size:     size,
+		seqs:     make(map[string]uint64),
+		replies:  make(map[string][]Message),
+		replyOf:  make(map[string]replyIndex),
+		versions: make(map[string][]Version),
+	}
+}
+
+// Add appends m, which must follow the last message added without a gap.
+// Replies must follow the last reply of their thread instead, which they're
+// counted in.
+func (s *InMemoryStore) Add(m Message) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if m.IsReply() {
+		return s.addReply(m)
+	}
+
+	if n := len(s.messages); n > 0 && m.Seq != s.messages[n-1].Seq+1 {
+		return fmt.Errorf("message %d doesn't follow %d", m.Seq, s.messages[n-1].Seq)
+	}
+
+	if len(s.messages) == s.size {
+		s.drop(s.messages[0])
+		s.messages = s.messages[1:]
+	}
+	s.messages = append(s.messages, m)
+	s.seqs[m.ID] = m.Seq
+	return nil
+}
+
+// addReply must be called with the lock held.
+func (s *InMemoryStore) addReply(m Message) error {
+	parent := s.slot(m.ParentID)
+	switch {
+	case parent == nil:
+		return fmt.Errorf("%w: %s", ErrNotFound, m.ParentID)
+	case parent.IsReply():
+		return ErrNestedThread
+	case m.Seq != parent.NextReply():
+		return fmt.Errorf("reply %d doesn't follow %d", m.Seq, parent.NextReply()-1)
+	}
+
+	s.replyOf[m.ID] = replyIndex{m.ParentID, len(s.replies[m.ParentID])}
+	s.replies[m.ParentID] = append(s.replies[m.ParentID], m)
+	*parent = parent.withReply(m)
+	return nil
+}
+
+// drop forgets everything about m and its replies, it must be called with
+// the lock held.
+func (s *InMemoryStore) drop(m Message) {
+	for _, reply := range s.replies[m.ID] {
+		delete(s.replyOf, reply.ID)
+		delete(s.versions, reply.ID)
+	}
+	delete(s.replies, m.ID)
+	delete(s.seqs, m.ID)
+	delete(s.versions, m.ID)
+}
+
+// slot is where the message or reply with id is kept, nil when it isn't.
+// It must be called with the lock held.
+func (s *InMemoryStore) slot(id string) *Message {
+	if seq, ok := s.seqs[id]; ok {
+		return &s.messages[seq-s.messages[0].Seq]
+	}
+
+	if r, ok := s.replyOf[id]; ok {
+		return &s.replies[r.parent][r.i]
+	}
+
+	return nil
+}
+
+func (s *InMemoryStore) Get(id string) (Message, error) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	m := s.slot(id)
+	if m == nil {
+		return Message{}, fmt.Errorf("%w: %s", ErrNotFound, id)
+	}
+
+	return *m, nil
+}
+
+func (s *InMemoryStore) Update(m Message) error {
+	_, err := s.Change(m.ID, func(Message) (Message, error) {
+		return m, nil
+	})
+	return err
+}
+
+func (s *InMemoryStore) Change(id string, f func(Message) (Message, error)) (Message, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	slot := s.slot(id)
+	if slot == nil {
+		return Message{}, fmt.Errorf("%w: %s", ErrNotFound, id)
+	}
+
+	old := *slot
+	m, err := f(old)
+	if err != nil {
+		return old, err
+	}
+
+	switch {
+	case m.Deleted:
+		delete(s.versions, id)
+	case old.Content != m.Content:
+		s.versions[id] = append(s.versions[id], old.version())
+	}
+
+	*slot = m
+	return m, nil
+}
+
+func (s *InMemoryStore) Versions(id string) ([]Version, error) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	if s.slot(id) == nil {
+		return nil, fmt.Errorf("%w: %s", ErrNotFound, id)
+	}
+
+	return append([]Version{}, s.versions[id]...), nil
+}
+
+// After starts at the oldest message kept when seq is older than that, the
+// gap then tells clients they missed messages for good.
+func (s *InMemoryStore) After(seq uint64, limit int) ([]Message, error) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	if len(s.messages) == 0 {
+		return []Message{}, nil
+	}
+
+	start := 0
+	if first := s.messages[0].Seq; seq >= first {
+		start = int(min(seq-first+1, uint64(len(s.messages))))
+	}
+	end := min(start+limit, len(s.messages))
+
+	return append([]Message{}, s.messages[start:end]...), nil
+}
+
+func (s *InMemoryStore) Replies(parentID string, seq uint64, limit int) ([]Message, error) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	if _, ok := s.seqs[parentID]; !ok {
+		return nil, fmt.Errorf("%w: %s", ErrNotFound, parentID)
+	}
+
+	replies := s.replies[parentID]
+	start := int(min(seq, uint64(len(replies))))
+	end := min(start+limit, len(replies))
+
+	return append([]Message{}, replies[start:end]...), nil
+}
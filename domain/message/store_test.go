@@ -0,0 +1,162 @@
+package message
+
+import (
+	"errors"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestInMemoryStore(t *testing.T) {
+	s := NewInMemoryStore(3)
+
+	if got, _ := s.After(0, 10); len(got) != 0 {
+		t.Errorf("Got %v from an empty store", got)
+	}
+
+	for seq := range uint64(5) {
+		if err := s.Add(Message{ID: string(rune('a' + seq)), Seq: seq + 1}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := s.Add(Message{ID: "gap", Seq: 7}); err == nil {
+		t.Error("Added a message after a gap")
+	}
+
+	if _, err := s.Get("a"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Got %v for a dropped message, Want %v", err, ErrNotFound)
+	}
+
+	if got, err := s.Get("d"); err != nil || got.Seq != 4 {
+		t.Errorf("Got %v %v, Want message 4", got, err)
+	}
+
+	seqs := func(messages []Message) []uint64 {
+		var seqs []uint64
+		for _, m := range messages {
+			seqs = append(seqs, m.Seq)
+		}
+		return seqs
+	}
+
+	cases := map[string]struct {
+		after uint64
+		limit int
+		want  []uint64
+	}{
+		"from the start":    {0, 10, []uint64{3, 4, 5}},
+		"older than kept":   {1, 10, []uint64{3, 4, 5}},
+		"in the middle":     {3, 10, []uint64{4, 5}},
+		"limited":           {2, 2, []uint64{3, 4}},
+		"nothing newer":     {5, 10, nil},
+		"ahead of the rest": {9, 10, nil},
+	}
+
+	for name, c := range cases {
+		got, _ := s.After(c.after, c.limit)
+		if !slices.Equal(seqs(got), c.want) {
+			t.Errorf("%s: Got %v, Want %v", name, seqs(got), c.want)
+		}
+	}
+}
+
+func TestInMemoryStoreUpdate(t *testing.T) {
+	s := NewInMemoryStore(0)
+	m := Message{ID: "id", Seq: 1, Content: "one"}
+	s.Add(m)
+
+	if err := s.Update(Message{ID: "unknown"}); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Got %v, Want %v", err, ErrNotFound)
+	}
+
+	for _, content := range []string{"two", "three"} {
+		m, _ = m.Edit(content, time.Now())
+		if err := s.Update(m); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if got, _ := s.Get("id"); got.Content != "three" {
+		t.Errorf("Got %+v, Want the last edit", got)
+	}
+
+	versions, _ := s.Versions("id")
+	if len(versions) != 2 || versions[0].Content != "one" || versions[1].Content != "two" {
+		t.Errorf("Unexpected versions %+v", versions)
+	}
+
+	if _, err := s.Change("id", func(m Message) (Message, error) {
+		return m.Edit("lost", time.Now())
+	}); err != nil {
+		t.Fatal(err)
+	}
+	failed := errors.New("failed")
+	if _, err := s.Change("id", func(m Message) (Message, error) {
+		m.Content = "half done"
+		return m, failed
+	}); !errors.Is(err, failed) {
+		t.Errorf("Got %v, Want %v", err, failed)
+	}
+	if got, _ := s.Get("id"); got.Content != "lost" {
+		t.Errorf("Failed change left %+v", got)
+	}
+
+	s.Update(m.Delete())
+	if versions, _ := s.Versions("id"); len(versions) != 0 {
+		t.Errorf("Tombstone kept versions %+v", versions)
+	}
+}
+
+func TestInMemoryStoreReplies(t *testing.T) {
+	s := NewInMemoryStore(2)
+	s.Add(Message{ID: "a", Seq: 1})
+
+	for seq := range uint64(3) {
+		if err := s.Add(Message{ID: string(rune('x' + seq)), ParentID: "a", Seq: seq + 1, SenderID: "bob"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for name, c := range map[string]struct {
+		reply Message
+		want  error
+	}{
+		"unknown parent": {Message{ID: "r", ParentID: "unknown", Seq: 1}, ErrNotFound},
+		"nested":         {Message{ID: "r", ParentID: "x", Seq: 1}, ErrNestedThread},
+	} {
+		if err := s.Add(c.reply); !errors.Is(err, c.want) {
+			t.Errorf("%s: Got %v, Want %v", name, err, c.want)
+		}
+	}
+	if err := s.Add(Message{ID: "gap", ParentID: "a", Seq: 9}); err == nil {
+		t.Error("Added a reply after a gap")
+	}
+
+	if parent, _ := s.Get("a"); parent.Thread == nil || parent.Thread.Replies != 3 {
+		t.Errorf("Got thread %+v, Want 3 replies", parent.Thread)
+	}
+
+	if _, err := s.Change("y", func(m Message) (Message, error) {
+		return m.Edit("edited", time.Now())
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if versions, _ := s.Versions("y"); len(versions) != 1 {
+		t.Errorf("Got versions %v of an edited reply", versions)
+	}
+
+	got, _ := s.Replies("a", 1, 10)
+	if len(got) != 2 || got[0].Content != "edited" || got[1].ID != "z" {
+		t.Errorf("Got replies %v, Want y and z", got)
+	}
+	if _, err := s.Replies("x", 0, 10); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Got %v for replies of a reply, Want %v", err, ErrNotFound)
+	}
+
+	s.Add(Message{ID: "b", Seq: 2})
+	s.Add(Message{ID: "c", Seq: 3})
+	if _, err := s.Get("x"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Got %v for a reply of a dropped message, Want %v", err, ErrNotFound)
+	}
+}
@@ -0,0 +1,69 @@
+package message
+
+import (
+	"errors"
+	"slices"
+	"time"
+)
+
+const ThreadEventType = "thread_updated"
+
+var ErrNestedThread = errors.New("replies can't have replies")
+
+// Thread sums up the replies to a message, Participants are the IDs of
+// those who replied in the order they joined.
+type Thread struct {
+	Replies      int       `json:"replies"`
+	LastReply    time.Time `json:"last_reply_at"`
+	Participants []string  `json:"participants"`
+}
+
+// ThreadEvent announces the new summary of the thread of the message with
+// ID, the replies themselves only reach those subscribed to it.
+type ThreadEvent struct {
+	Type   string `json:"type"`
+	ID     string `json:"id"`
+	Thread Thread `json:"thread"`
+}
+
+// IsReply tells if m belongs to the thread of another message.
+func (m Message) IsReply() bool {
+	return m.ParentID != ""
+}
+
+// NextReply is the Seq of the next reply to m, replies are numbered within
+// their thread.
+func (m Message) NextReply() uint64 {
+	if m.Thread == nil {
+		return 1
+	}
+
+	return uint64(m.Thread.Replies) + 1
+}
+
+// withReply counts reply in the thread of m.
+func (m Message) withReply(reply Message) Message {
+	t := Thread{}
+	if m.Thread != nil {
+		t = *m.Thread
+	}
+
+	t.Replies++
+	t.LastReply = reply.Time
+	if !slices.Contains(t.Participants, reply.SenderID) {
+		t.Participants = append(slices.Clone(t.Participants), reply.SenderID)
+	}
+
+	m.Thread = &t
+	return m
+}
+
+// ThreadEvent announces the thread of m.
+func (m Message) ThreadEvent() ThreadEvent {
+	e := ThreadEvent{Type: ThreadEventType, ID: m.ID}
+	if m.Thread != nil {
+		e.Thread = *m.Thread
+	}
+
+	return e
+}
@@ -0,0 +1,33 @@
+package message
+
+import (
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestWithReply(t *testing.T) {
+	m := Message{ID: "parent"}
+	if m.NextReply() != 1 {
+		t.Errorf("Got next reply %d, Want 1", m.NextReply())
+	}
+
+	start := time.Now()
+	for i, sender := range []string{"bob", "carol", "bob"} {
+		m = m.withReply(Message{ParentID: "parent", SenderID: sender, Time: start.Add(time.Duration(i) * time.Second)})
+	}
+
+	if m.Thread.Replies != 3 || m.NextReply() != 4 {
+		t.Errorf("Got %d replies, next %d, Want 3 and 4", m.Thread.Replies, m.NextReply())
+	}
+	if !m.Thread.LastReply.Equal(start.Add(2 * time.Second)) {
+		t.Errorf("Got last reply at %v, Want the third", m.Thread.LastReply)
+	}
+	if want := []string{"bob", "carol"}; !slices.Equal(m.Thread.Participants, want) {
+		t.Errorf("Got participants %v, Want %v", m.Thread.Participants, want)
+	}
+
+	if e := m.ThreadEvent(); e.Type != ThreadEventType || e.ID != "parent" || e.Thread.Replies != 3 {
+		t.Errorf("Unexpected event %+v", e)
+	}
+}
@@ -0,0 +1,220 @@
+package presence
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/DanyPops/logues/domain/user"
+)
+
+const (
+	// Time without activity after which a connected user is away.
+	defaultIdleTimeout = 5 * time.Minute
+	EventType          = "presence"
+)
+
+type Status string
+
+const (
+	Online  Status = "online"
+	Away    Status = "away"
+	Offline Status = "offline"
+)
+
+type Presence struct {
+	User   user.User `json:"user"`
+	Status Status    `json:"status"`
+	Since  time.Time `json:"since"`
+}
+
+// Event announces a change of someone's presence.
+type Event struct {
+	Type string `json:"type"`
+	Presence
+}
+
+type state struct {
+	Presence
+	conns      map[any]struct{}
+	lastActive time.Time
+}
+
+// Tracker aggregates the presence of a user across all of their
+// connections: online while any of them was active within the idle timeout,
+// away while all of them are idle and offline once the last one is gone.
+type Tracker struct {
+	lock   *sync.Mutex
+	users  map[string]*state
+	idle   time.Duration
+	notify func(Event)
+	// pending are the events notify hasn't been handed yet, wake tells
+	// there are some.
+	pending []Event
+	wake    chan struct{}
+}
+
+// NewTracker hands every change to notify and marks idle users away until
+// ctx is done. A zero idle timeout uses the default. notify is called in
+// order from a goroutine of its own, so a slow one doesn't hold up the
+// connections reporting activity.
+func NewTracker(ctx context.Context, idle time.Duration, notify func(Event)) *Tracker {
+	if idle <= 0 {
+		idle = defaultIdleTimeout
+	}
+
+	t := &Tracker{
+		lock:   new(sync.Mutex),
+		users:  make(map[string]*state),
+		idle:   idle,
+		notify: notify,
+		wake:   make(chan struct{}, 1),
+	}
+
+	go t.Retention(ctx)
+	go t.deliver(ctx)
+	return t
+}
+
+func (t *Tracker) Retention(ctx context.Context) {
+	ticker := time.NewTicker(t.idle / 4)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			t.sweep(time.Now())
+
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// deliver hands the pending events to notify until ctx is done.
+func (t *Tracker) deliver(ctx context.Context) {
+	for {
+		select {
+		case <-t.wake:
+			t.lock.Lock()
+			events := t.pending
+			t.pending = nil
+			t.lock.Unlock()
+
+			for _, e := range events {
+				t.notify(e)
+			}
+
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+func (t *Tracker) sweep(now time.Time) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	for _, s := range t.users {
+		if s.Status == Online && now.Sub(s.lastActive) >= t.idle {
+			t.set(s, Away, now)
+		}
+	}
+}
+
+// Connect counts conn as one of u's connections and as activity.
+func (t *Tracker) Connect(u user.User, conn any) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	s, ok := t.users[u.ID]
+	if !ok {
+		s = &state{Presence: Presence{User: u, Status: Offline}, conns: make(map[any]struct{})}
+		t.users[u.ID] = s
+	}
+	s.conns[conn] = struct{}{}
+	t.active(s, time.Now())
+}
+
+// Activity brings u back online, conn must be connected.
+func (t *Tracker) Activity(u user.User, conn any) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	s, ok := t.users[u.ID]
+	if !ok {
+		return
+	}
+
+	if _, ok := s.conns[conn]; ok {
+		t.active(s, time.Now())
+	}
+}
+
+func (t *Tracker) Disconnect(u user.User, conn any) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	s, ok := t.users[u.ID]
+	if !ok {
+		return
+	}
+
+	delete(s.conns, conn)
+	if len(s.conns) == 0 {
+		delete(t.users, u.ID)
+		t.set(s, Offline, time.Now())
+	}
+}
+
+// Get returns the presence of the user with id, offline when they aren't
+// connected.
+func (t *Tracker) Get(id string) Presence {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	s, ok := t.users[id]
+	if !ok {
+		return Presence{User: user.User{ID: id}, Status: Offline}
+	}
+
+	return s.Presence
+}
+
+// Present lists everyone online or away, ordered by name.
+func (t *Tracker) Present() []Presence {
+	t.lock.Lock()
+	present := make([]Presence, 0, len(t.users))
+	for _, s := range t.users {
+		present = append(present, s.Presence)
+	}
+	t.lock.Unlock()
+
+	sort.Slice(present, func(i, j int) bool {
+		return present[i].User.Name < present[j].User.Name
+	})
+	return present
+}
+
+// active must be called with the lock held.
+func (t *Tracker) active(s *state, now time.Time) {
+	s.lastActive = now
+	if s.Status != Online {
+		t.set(s, Online, now)
+	}
+}
+
+// set must be called with the lock held, which keeps the events in order.
+func (t *Tracker) set(s *state, status Status, now time.Time) {
+	s.Status, s.Since = status, now
+	if t.notify == nil {
+		return
+	}
+
+	t.pending = append(t.pending, Event{Type: EventType, Presence: s.Presence})
+	select {
+	case t.wake <- struct{}{}:
+	default:
+	}
+}
@@ -0,0 +1,135 @@
+package presence
+
+import (
+	"context"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/DanyPops/logues/domain/user"
+)
+
+type eventLog struct {
+	lock   sync.Mutex
+	events []Event
+}
+
+func (l *eventLog) notify(e Event) {
+	l.lock.Lock()
+	l.events = append(l.events, e)
+	l.lock.Unlock()
+}
+
+// await waits for the events to catch up with want, as they're delivered in
+// the background.
+func (l *eventLog) await(want []Status) []Status {
+	deadline := time.Now().Add(time.Second)
+	for !slices.Equal(l.statuses(), want) && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+
+	return l.statuses()
+}
+
+func (l *eventLog) statuses() []Status {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	statuses := make([]Status, len(l.events))
+	for i, e := range l.events {
+		statuses[i] = e.Status
+	}
+	return statuses
+}
+
+func TestTracker(t *testing.T) {
+	u := user.User{ID: "1", Name: "dpop"}
+
+	newTracker := func(t *testing.T, idle time.Duration) (*Tracker, *eventLog) {
+		ctx, cancel := context.WithCancel(context.Background())
+		t.Cleanup(cancel)
+		log := new(eventLog)
+		return NewTracker(ctx, idle, log.notify), log
+	}
+
+	t.Run("Aggregated across connections", func(t *testing.T) {
+		tr, log := newTracker(t, time.Minute)
+		tr.Connect(u, "laptop")
+		tr.Connect(u, "phone")
+
+		if got := tr.Get(u.ID).Status; got != Online {
+			t.Errorf("Got %s, Want %s", got, Online)
+		}
+
+		tr.Disconnect(u, "laptop")
+		if got := tr.Get(u.ID).Status; got != Online {
+			t.Errorf("Got %s with a connection left, Want %s", got, Online)
+		}
+
+		tr.Disconnect(u, "phone")
+		if got := tr.Get(u.ID).Status; got != Offline {
+			t.Errorf("Got %s, Want %s", got, Offline)
+		}
+
+		if want := []Status{Online, Offline}; !slices.Equal(log.await(want), want) {
+			t.Errorf("Got events %v, Want %v", log.statuses(), want)
+		}
+	})
+
+	t.Run("Idle users are away until active", func(t *testing.T) {
+		tr, log := newTracker(t, 40*time.Millisecond)
+		tr.Connect(u, "laptop")
+
+		time.Sleep(100 * time.Millisecond)
+		if got := tr.Get(u.ID).Status; got != Away {
+			t.Errorf("Got %s, Want %s", got, Away)
+		}
+
+		tr.Activity(u, "laptop")
+		if got := tr.Get(u.ID).Status; got != Online {
+			t.Errorf("Got %s, Want %s", got, Online)
+		}
+
+		if want := []Status{Online, Away, Online}; !slices.Equal(log.await(want), want) {
+			t.Errorf("Got events %v, Want %v", log.statuses(), want)
+		}
+	})
+
+	t.Run("Stalled notify holds up nobody", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		stall := make(chan struct{})
+		defer close(stall)
+		tr := NewTracker(ctx, time.Minute, func(Event) { <-stall })
+
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			for i := range 10 {
+				tr.Connect(u, i)
+				tr.Disconnect(u, i)
+			}
+		}()
+
+		select {
+		case <-done:
+		case <-time.After(time.Second):
+			t.Fatal("Connect and Disconnect blocked on notify")
+		}
+	})
+
+	t.Run("Present lists connected users", func(t *testing.T) {
+		tr, _ := newTracker(t, time.Minute)
+		other := user.User{ID: "2", Name: "alice"}
+		tr.Connect(u, "laptop")
+		tr.Connect(other, "laptop")
+		tr.Disconnect(other, "laptop")
+		tr.Connect(other, "phone")
+
+		present := tr.Present()
+		if len(present) != 2 || present[0].User != other || present[1].User != u {
+			t.Errorf("Unexpected presence %v", present)
+		}
+	})
+}
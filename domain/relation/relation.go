@@ -0,0 +1,134 @@
+package relation
+
+import (
+	"errors"
+	"slices"
+	"sync"
+
+	"github.com/DanyPops/logues/domain/channel"
+)
+
+// Kind of list a user keeps about others.
+type Kind string
+
+const (
+	// Block hides the target's messages and keeps them from talking to
+	// the owner directly.
+	Block Kind = "block"
+	// Mute flags the target's messages, clients decide how to show them.
+	Mute Kind = "mute"
+)
+
+var (
+	ErrSelf    = errors.New("can't block or mute yourself")
+	ErrBlocked = errors.New("blocked")
+)
+
+// Store keeps the block and mute lists of every user, owners and targets are
+// user IDs.
+type Store interface {
+	Add(owner string, kind Kind, target string) error
+	Remove(owner string, kind Kind, target string) error
+	List(owner string, kind Kind) ([]string, error)
+	Has(owner string, kind Kind, target string) (bool, error)
+}
+
+type list struct {
+	owner string
+	kind  Kind
+}
+
+type InMemoryStore struct {
+	lock    *sync.RWMutex
+	targets map[list]map[string]struct{}
+}
+
+func NewInMemoryStore() InMemoryStore {
+	return InMemoryStore{
+		lock:    new(sync.RWMutex),
+		targets: make(map[list]map[string]struct{}),
+	}
+}
+
+// Add puts target on the owner's list, adding it twice is fine.
+func (s InMemoryStore) Add(owner string, kind Kind, target string) error {
+	if owner == target {
+		return ErrSelf
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	l := list{owner, kind}
+	if s.targets[l] == nil {
+		s.targets[l] = make(map[string]struct{})
+	}
+	s.targets[l][target] = struct{}{}
+	return nil
+}
+
+func (s InMemoryStore) Remove(owner string, kind Kind, target string) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	l := list{owner, kind}
+	delete(s.targets[l], target)
+	if len(s.targets[l]) == 0 {
+		delete(s.targets, l)
+	}
+	return nil
+}
+
+// List returns the owner's list sorted, so it reads the same every time.
+func (s InMemoryStore) List(owner string, kind Kind) ([]string, error) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	targets := make([]string, 0, len(s.targets[list{owner, kind}]))
+	for target := range s.targets[list{owner, kind}] {
+		targets = append(targets, target)
+	}
+	slices.Sort(targets)
+
+	return targets, nil
+}
+
+func (s InMemoryStore) Has(owner string, kind Kind, target string) (bool, error) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	_, ok := s.targets[list{owner, kind}][target]
+	return ok, nil
+}
+
+// Filter skips receivers who blocked the sender and flags messages of
+// senders they muted. Lookup errors fail open, a message is better than none.
+func Filter(s Store) channel.Filter {
+	return func(receiver, sender string) channel.Verdict {
+		if blocked, _ := s.Has(receiver, Block, sender); blocked {
+			return channel.Skip
+		}
+
+		if muted, _ := s.Has(receiver, Mute, sender); muted {
+			return channel.Flag
+		}
+
+		return channel.Deliver
+	}
+}
+
+// CheckDirect returns ErrBlocked when either of from and to blocked the
+// other, as neither of them may then talk to the other directly.
+func CheckDirect(s Store, from, to string) error {
+	for _, pair := range [][2]string{{from, to}, {to, from}} {
+		blocked, err := s.Has(pair[0], Block, pair[1])
+		if err != nil {
+			return err
+		}
+		if blocked {
+			return ErrBlocked
+		}
+	}
+
+	return nil
+}
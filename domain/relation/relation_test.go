@@ -0,0 +1,82 @@
+package relation
+
+import (
+	"errors"
+	"slices"
+	"testing"
+
+	"github.com/DanyPops/logues/domain/channel"
+)
+
+func TestInMemoryStore(t *testing.T) {
+	s := NewInMemoryStore()
+
+	if err := s.Add("alice", Block, "alice"); !errors.Is(err, ErrSelf) {
+		t.Errorf("Got %v, Want %v", err, ErrSelf)
+	}
+
+	s.Add("alice", Block, "carol")
+	s.Add("alice", Block, "bob")
+	s.Add("alice", Block, "bob")
+	s.Add("alice", Mute, "dave")
+
+	if got, _ := s.List("alice", Block); !slices.Equal(got, []string{"bob", "carol"}) {
+		t.Errorf("Got blocks %v", got)
+	}
+
+	if ok, _ := s.Has("alice", Mute, "bob"); ok {
+		t.Error("Blocking muted")
+	}
+
+	s.Remove("alice", Block, "bob")
+	if ok, _ := s.Has("alice", Block, "bob"); ok {
+		t.Error("Unblocked user still blocked")
+	}
+
+	if got, _ := s.List("bob", Block); len(got) != 0 {
+		t.Errorf("Got blocks %v of user without any", got)
+	}
+}
+
+func TestFilter(t *testing.T) {
+	s := NewInMemoryStore()
+	s.Add("alice", Block, "bob")
+	s.Add("alice", Mute, "carol")
+	s.Add("alice", Mute, "bob")
+	filter := Filter(s)
+
+	cases := map[string]struct {
+		receiver, sender string
+		want             channel.Verdict
+	}{
+		"blocked":          {"alice", "bob", channel.Skip},
+		"muted":            {"alice", "carol", channel.Flag},
+		"other":            {"alice", "dave", channel.Deliver},
+		"only one way":     {"bob", "alice", channel.Deliver},
+		"sender's own one": {"carol", "carol", channel.Deliver},
+	}
+
+	for name, c := range cases {
+		if got := filter(c.receiver, c.sender); got != c.want {
+			t.Errorf("%s: Got %v, Want %v", name, got, c.want)
+		}
+	}
+}
+
+func TestCheckDirect(t *testing.T) {
+	s := NewInMemoryStore()
+	s.Add("alice", Block, "bob")
+	s.Add("alice", Mute, "carol")
+
+	if err := CheckDirect(s, "bob", "alice"); !errors.Is(err, ErrBlocked) {
+		t.Errorf("Blocked user got %v", err)
+	}
+
+	if err := CheckDirect(s, "alice", "bob"); !errors.Is(err, ErrBlocked) {
+		t.Errorf("Blocker got %v", err)
+	}
+
+	if err := CheckDirect(s, "carol", "alice"); err != nil {
+		t.Errorf("Muted user got %v", err)
+	}
+}
@@ -0,0 +1,162 @@
+package user
+
+import (
+	"cmp"
+	"encoding/base64"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+const (
+	DefaultSearchLimit = 20
+	MaxSearchLimit     = 100
+)
+
+// Ranks of a search match, prefix matches come before the rest.
+const (
+	prefixMatch = iota
+	substringMatch
+)
+
+// SearchPage is one page of search results, Next is the cursor of the
+// following page and empty on the last one.
+type SearchPage struct {
+	Profiles []Profile `json:"profiles"`
+	Next     string    `json:"next,omitempty"`
+}
+
+type suffix struct {
+	text  string
+	id    string
+	start bool
+}
+
+// index finds users by any part of their lowercased name, handle and display
+// name. It keeps every suffix of them sorted, so the matches of a query are
+// the run of suffixes starting with it.
+type index struct {
+	suffixes []suffix
+}
+
+func (x *index) remove(id string) {
+	x.suffixes = slices.DeleteFunc(x.suffixes, func(s suffix) bool {
+		return s.id == id
+	})
+}
+
+// put indexes p in place of whatever was indexed for its ID, deactivated
+// profiles are only removed.
+func (x *index) put(p Profile) {
+	x.remove(p.ID)
+	if p.Deactivated {
+		return
+	}
+
+	for _, field := range []string{p.Name, p.Handle, p.DisplayName} {
+		text := strings.ToLower(field)
+		for i := range text {
+			s := suffix{text: text[i:], id: p.ID, start: i == 0}
+			at, _ := slices.BinarySearchFunc(x.suffixes, s, compareSuffixes)
+			x.suffixes = slices.Insert(x.suffixes, at, s)
+		}
+	}
+}
+
+func compareSuffixes(a, b suffix) int {
+	return cmp.Or(strings.Compare(a.text, b.text), strings.Compare(a.id, b.id))
+}
+
+// match returns the rank of every ID with a suffix starting with query.
+func (x *index) match(query string) map[string]int {
+	query = strings.ToLower(query)
+	at, _ := slices.BinarySearchFunc(x.suffixes, suffix{text: query}, compareSuffixes)
+
+	ranks := make(map[string]int)
+	for _, s := range x.suffixes[at:] {
+		if !strings.HasPrefix(s.text, query) {
+			break
+		}
+
+		rank := substringMatch
+		if s.start {
+			rank = prefixMatch
+		}
+		if r, ok := ranks[s.id]; !ok || rank < r {
+			ranks[s.id] = rank
+		}
+	}
+
+	return ranks
+}
+
+type searchCursor struct {
+	rank int
+	name string
+}
+
+// String encodes the cursor as "rank:name", opaque to clients.
+func (c searchCursor) String() string {
+	return base64.RawURLEncoding.EncodeToString([]byte(strconv.Itoa(c.rank) + ":" + c.name))
+}
+
+func parseSearchCursor(raw string) (searchCursor, error) {
+	malformed := FieldError{"cursor", "is malformed"}
+
+	b, err := base64.RawURLEncoding.DecodeString(raw)
+	if err != nil {
+		return searchCursor{}, malformed
+	}
+
+	rank, name, ok := strings.Cut(string(b), ":")
+	r, err := strconv.Atoi(rank)
+	if !ok || err != nil || r < prefixMatch || r > substringMatch {
+		return searchCursor{}, malformed
+	}
+
+	return searchCursor{r, name}, nil
+}
+
+func (c searchCursor) compare(o searchCursor) int {
+	return cmp.Or(cmp.Compare(c.rank, o.rank), strings.Compare(c.name, o.name))
+}
+
+// Search finds active users whose name or display name contains query,
+// those starting with it first and each by name. cursor continues after a
+// previous page, limit falls back to the default when it's out of range.
+func (s InMemoryStore) Search(query, cursor string, limit int) (SearchPage, error) {
+	if limit <= 0 || limit > MaxSearchLimit {
+		limit = DefaultSearchLimit
+	}
+
+	var after *searchCursor
+	if cursor != "" {
+		c, err := parseSearchCursor(cursor)
+		if err != nil {
+			return SearchPage{}, err
+		}
+		after = &c
+	}
+
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	var matches []searchCursor
+	for id, rank := range s.index.match(query) {
+		c := searchCursor{rank, s.profiles[id].Name}
+		if after == nil || c.compare(*after) > 0 {
+			matches = append(matches, c)
+		}
+	}
+	slices.SortFunc(matches, searchCursor.compare)
+
+	page := SearchPage{Profiles: make([]Profile, 0, min(limit, len(matches)))}
+	for _, c := range matches[:min(limit, len(matches))] {
+		page.Profiles = append(page.Profiles, s.profiles[s.names[c.name]])
+	}
+	if len(matches) > limit {
+		page.Next = matches[limit-1].String()
+	}
+
+	return page, nil
+}
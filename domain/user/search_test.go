@@ -0,0 +1,95 @@
+package user
+
+import (
+	"errors"
+	"slices"
+	"testing"
+)
+
+func TestSearch(t *testing.T) {
+	s := NewInMemoryStore()
+	for i, p := range []Profile{
+		{User: User{Name: "dpop"}, DisplayName: "Dany Popov"},
+		{User: User{Name: "danielle"}},
+		{User: User{Name: "adan"}, DisplayName: "Adam"},
+		{User: User{Name: "bob"}, DisplayName: "Bobby Tables"},
+		{User: User{Name: "dana"}, Deactivated: true},
+	} {
+		p.ID = string(rune('a' + i))
+		if err := s.Add(p); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	names := func(page SearchPage) []string {
+		var names []string
+		for _, p := range page.Profiles {
+			names = append(names, p.Name)
+		}
+		return names
+	}
+
+	cases := map[string][]string{
+		"dan":    {"danielle", "dpop", "adan"},
+		"DAN":    {"danielle", "dpop", "adan"},
+		"pop":    {"dpop"},
+		"tables": {"bob"},
+		"zed":    nil,
+	}
+
+	for query, want := range cases {
+		page, err := s.Search(query, "", 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if got := names(page); !slices.Equal(got, want) || page.Next != "" {
+			t.Errorf("%s: Got %v next %q, Want %v", query, got, page.Next, want)
+		}
+	}
+
+	t.Run("Pages", func(t *testing.T) {
+		var got []string
+		cursor := ""
+		for range 3 {
+			page, err := s.Search("dan", cursor, 1)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got = append(got, names(page)...)
+			if cursor = page.Next; cursor == "" {
+				break
+			}
+		}
+
+		if !slices.Equal(got, cases["dan"]) || cursor != "" {
+			t.Errorf("Got %v, Want %v", got, cases["dan"])
+		}
+	})
+
+	t.Run("Index follows updates", func(t *testing.T) {
+		p, _ := s.GetByName("bob")
+		p.DisplayName = "Robert"
+		s.Update(p)
+
+		if page, _ := s.Search("tables", "", 0); len(page.Profiles) != 0 {
+			t.Errorf("Found %v by old display name", names(page))
+		}
+		if page, _ := s.Search("rob", "", 0); !slices.Equal(names(page), []string{"bob"}) {
+			t.Errorf("Got %v, Want bob", names(page))
+		}
+
+		p.Deactivated = true
+		s.Update(p)
+		if page, _ := s.Search("bob", "", 0); len(page.Profiles) != 0 {
+			t.Errorf("Found deactivated %v", names(page))
+		}
+	})
+
+	t.Run("Malformed cursor", func(t *testing.T) {
+		var fieldErr FieldError
+		if _, err := s.Search("dan", "nope", 0); !errors.As(err, &fieldErr) || fieldErr.Field != "cursor" {
+			t.Errorf("Got %v, Want a cursor error", err)
+		}
+	})
+}
@@ -1,5 +1,325 @@
 package user
 
+import (
+	"crypto/rand"
+	"encoding/base32"
+	"errors"
+	"fmt"
+	"net/url"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+	_ "time/tzdata"
+	"unicode/utf8"
+)
+
+const (
+	displayNameMaxLength = 64
+	bioMaxLength         = 500
+	avatarURLMaxLength   = 2048
+)
+
+var (
+	ErrNotFound    = errors.New("user not found")
+	ErrNameTaken   = errors.New("user name already taken")
+	ErrHandleTaken = errors.New("user handle already taken")
+
+	// Handles are held to the rules of local user names.
+	handlePattern = regexp.MustCompile(`^[a-zA-Z0-9_.-]{3,32}$`)
+
+	idEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+)
+
+// User identifies someone by an ID that never changes and a unique Name, the
+// handle they log in and are mentioned with.
 type User struct {
-  Name string `json:"name"`
+	ID   string `json:"id"`
+	Name string `json:"name"`
+}
+
+func NewID() (string, error) {
+	b := make([]byte, 15)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("generating user id: %w", err)
+	}
+
+	return strings.ToLower(idEncoding.EncodeToString(b)), nil
+}
+
+type Profile struct {
+	User
+	// Handle is what others mention the user with when their Name isn't
+	// fit for it, like users of identity sources that name them by URL.
+	Handle      string    `json:"handle,omitempty"`
+	DisplayName string    `json:"display_name"`
+	AvatarURL   string    `json:"avatar_url"`
+	Bio         string    `json:"bio"`
+	Timezone    string    `json:"timezone"`
+	Created     time.Time `json:"created_at"`
+	// Deactivated profiles stay around for the messages they sent but
+	// aren't found by searches.
+	Deactivated bool `json:"deactivated,omitempty"`
+}
+
+// FieldError reports why a profile field was refused.
+type FieldError struct {
+	Field  string
+	Reason string
+}
+
+func (e FieldError) Error() string {
+	return fmt.Sprintf("%s %s", e.Field, e.Reason)
+}
+
+// ProfileUpdate changes the fields that aren't nil.
+type ProfileUpdate struct {
+	DisplayName *string `json:"display_name"`
+	AvatarURL   *string `json:"avatar_url"`
+	Bio         *string `json:"bio"`
+	Timezone    *string `json:"timezone"`
+}
+
+func (p Profile) Apply(u ProfileUpdate) (Profile, error) {
+	if u.DisplayName != nil {
+		name := strings.TrimSpace(*u.DisplayName)
+		if !utf8.ValidString(name) || utf8.RuneCountInString(name) > displayNameMaxLength {
+			return p, FieldError{"display_name", fmt.Sprintf("must be at most %d characters", displayNameMaxLength)}
+		}
+		p.DisplayName = name
+	}
+
+	if u.AvatarURL != nil {
+		if err := validateAvatarURL(*u.AvatarURL); err != nil {
+			return p, err
+		}
+		p.AvatarURL = *u.AvatarURL
+	}
+
+	if u.Bio != nil {
+		if !utf8.ValidString(*u.Bio) || utf8.RuneCountInString(*u.Bio) > bioMaxLength {
+			return p, FieldError{"bio", fmt.Sprintf("must be at most %d characters", bioMaxLength)}
+		}
+		p.Bio = *u.Bio
+	}
+
+	if u.Timezone != nil {
+		if _, err := time.LoadLocation(*u.Timezone); err != nil || *u.Timezone == "Local" {
+			return p, FieldError{"timezone", "must be an IANA time zone like Europe/Berlin"}
+		}
+		p.Timezone = *u.Timezone
+	}
+
+	return p, nil
+}
+
+// validateAvatarURL accepts empty URLs, which remove the avatar.
+func validateAvatarURL(raw string) error {
+	if raw == "" {
+		return nil
+	}
+
+	u, err := url.Parse(raw)
+	if err != nil || len(raw) > avatarURLMaxLength || (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" {
+		return FieldError{"avatar_url", "must be an absolute http(s) URL"}
+	}
+
+	return nil
+}
+
+type Store interface {
+	Get(id string) (Profile, error)
+	GetByName(name string) (Profile, error)
+	// GetByHandle returns who @handle mentions, names win over handles.
+	GetByHandle(handle string) (Profile, error)
+	Add(Profile) error
+	Update(Profile) error
+	Search(query, cursor string, limit int) (SearchPage, error)
+}
+
+type InMemoryStore struct {
+	lock     *sync.RWMutex
+	profiles map[string]Profile
+	names    map[string]string
+	handles  map[string]string
+	index    *index
+}
+
+func NewInMemoryStore() InMemoryStore {
+	return InMemoryStore{
+		lock:     new(sync.RWMutex),
+		profiles: make(map[string]Profile),
+		names:    make(map[string]string),
+		handles:  make(map[string]string),
+		index:    new(index),
+	}
+}
+
+func (s InMemoryStore) Get(id string) (Profile, error) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	p, ok := s.profiles[id]
+	if !ok {
+		return Profile{}, fmt.Errorf("%w: %s", ErrNotFound, id)
+	}
+
+	return p, nil
+}
+
+func (s InMemoryStore) GetByName(name string) (Profile, error) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	p, ok := s.profiles[s.names[name]]
+	if !ok {
+		return Profile{}, fmt.Errorf("%w: %s", ErrNotFound, name)
+	}
+
+	return p, nil
+}
+
+func (s InMemoryStore) GetByHandle(handle string) (Profile, error) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	id, ok := s.names[handle]
+	if !ok {
+		id = s.handles[handle]
+	}
+
+	p, ok := s.profiles[id]
+	if !ok {
+		return Profile{}, fmt.Errorf("%w: %s", ErrNotFound, handle)
+	}
+
+	return p, nil
+}
+
+func (s InMemoryStore) Add(p Profile) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if _, ok := s.names[p.Name]; ok {
+		return fmt.Errorf("%w: %s", ErrNameTaken, p.Name)
+	}
+	if s.handleTaken(p.Handle) {
+		return fmt.Errorf("%w: %s", ErrHandleTaken, p.Handle)
+	}
+
+	s.profiles[p.ID] = p
+	s.names[p.Name] = p.ID
+	if p.Handle != "" {
+		s.handles[p.Handle] = p.ID
+	}
+	s.index.put(p)
+	return nil
+}
+
+// handleTaken tells if handle already mentions someone. The caller must hold
+// the lock.
+func (s InMemoryStore) handleTaken(handle string) bool {
+	if handle == "" {
+		return false
+	}
+
+	_, name := s.names[handle]
+	_, taken := s.handles[handle]
+	return name || taken
+}
+
+// Update replaces the profile with p's ID, renaming it if the name changed.
+func (s InMemoryStore) Update(p Profile) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	old, ok := s.profiles[p.ID]
+	if !ok {
+		return fmt.Errorf("%w: %s", ErrNotFound, p.ID)
+	}
+
+	if old.Name != p.Name {
+		if _, ok := s.names[p.Name]; ok {
+			return fmt.Errorf("%w: %s", ErrNameTaken, p.Name)
+		}
+		delete(s.names, old.Name)
+		s.names[p.Name] = p.ID
+	}
+
+	if old.Handle != p.Handle {
+		if s.handleTaken(p.Handle) {
+			return fmt.Errorf("%w: %s", ErrHandleTaken, p.Handle)
+		}
+		delete(s.handles, old.Handle)
+		if p.Handle != "" {
+			s.handles[p.Handle] = p.ID
+		}
+	}
+
+	s.profiles[p.ID] = p
+	s.index.put(p)
+	return nil
+}
+
+// Provision returns the profile of u, creating it when u was never seen.
+// Users without an ID, like those of external identity sources, are matched
+// by name and given one.
+func Provision(s Store, u User) (Profile, error) {
+	return ProvisionProfile(s, Profile{User: u})
+}
+
+// ProvisionProfile is Provision for identity sources that know more about
+// their users, the details of p fill in the profile when it's created.
+// Details that aren't valid are left out, so is a handle that's taken.
+func ProvisionProfile(s Store, p Profile) (Profile, error) {
+	u := p.User
+	for {
+		var existing Profile
+		var err error
+		if u.ID != "" {
+			existing, err = s.Get(u.ID)
+		} else {
+			existing, err = s.GetByName(u.Name)
+		}
+		if !errors.Is(err, ErrNotFound) {
+			return existing, err
+		}
+
+		created := p.details(time.Now())
+		if created.ID == "" {
+			if created.ID, err = NewID(); err != nil {
+				return Profile{}, err
+			}
+		}
+
+		err = s.Add(created)
+		switch {
+		// Someone else provisioned the name meanwhile.
+		case errors.Is(err, ErrNameTaken) && u.ID == "":
+			continue
+		case errors.Is(err, ErrHandleTaken):
+			p.Handle = ""
+			continue
+		case err != nil:
+			return Profile{}, err
+		}
+
+		return created, nil
+	}
+}
+
+// details is a new profile of p's user with the valid details of p.
+func (p Profile) details(now time.Time) Profile {
+	created := Profile{User: p.User, Created: now}
+	if handlePattern.MatchString(p.Handle) {
+		created.Handle = p.Handle
+	}
+
+	for _, u := range []ProfileUpdate{{DisplayName: &p.DisplayName}, {AvatarURL: &p.AvatarURL}} {
+		if updated, err := created.Apply(u); err == nil {
+			created = updated
+		}
+	}
+
+	return created
 }
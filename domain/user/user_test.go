@@ -0,0 +1,123 @@
+package user
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestProfileApply(t *testing.T) {
+	ptr := func(s string) *string { return &s }
+	p := Profile{User: User{ID: "id", Name: "dpop"}}
+
+	got, err := p.Apply(ProfileUpdate{
+		DisplayName: ptr("  Dany  "),
+		AvatarURL:   ptr("https://example.com/a.png"),
+		Timezone:    ptr("Europe/Berlin"),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got.DisplayName != "Dany" || got.AvatarURL != "https://example.com/a.png" || got.Timezone != "Europe/Berlin" {
+		t.Errorf("Unexpected profile %+v", got)
+	}
+
+	cases := map[string]ProfileUpdate{
+		"display_name": {DisplayName: ptr(string(make([]rune, displayNameMaxLength+1)))},
+		"avatar_url":   {AvatarURL: ptr("file:///etc/passwd")},
+		"bio":          {Bio: ptr(string(make([]byte, bioMaxLength+1)))},
+		"timezone":     {Timezone: ptr("Local")},
+	}
+
+	for field, update := range cases {
+		var fieldErr FieldError
+		if _, err := p.Apply(update); !errors.As(err, &fieldErr) || fieldErr.Field != field {
+			t.Errorf("Got %v, Want a %s error", err, field)
+		}
+	}
+}
+
+func TestInMemoryStore(t *testing.T) {
+	s := NewInMemoryStore()
+	p := Profile{User: User{ID: "1", Name: "dpop"}}
+	if err := s.Add(p); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := s.Add(Profile{User: User{ID: "2", Name: "dpop"}}); !errors.Is(err, ErrNameTaken) {
+		t.Errorf("Got %v, Want %v", err, ErrNameTaken)
+	}
+
+	p.Name = "dany"
+	if err := s.Update(p); err != nil {
+		t.Fatal(err)
+	}
+
+	if got, err := s.GetByName("dany"); err != nil || got.ID != "1" {
+		t.Errorf("Got %v %v, Want the renamed profile", got, err)
+	}
+
+	if _, err := s.GetByName("dpop"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Got %v, Want %v", err, ErrNotFound)
+	}
+}
+
+func TestProvision(t *testing.T) {
+	s := NewInMemoryStore()
+
+	first, err := Provision(s, User{Name: "external"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if first.ID == "" || first.Created.IsZero() {
+		t.Errorf("Unexpected provisioned profile %+v", first)
+	}
+
+	again, err := Provision(s, User{Name: "external"})
+	if err != nil || again.ID != first.ID {
+		t.Errorf("Got %v %v, Want the same id %s", again, err, first.ID)
+	}
+
+	known, err := Provision(s, User{ID: "fixed", Name: "local"})
+	if err != nil || known.ID != "fixed" {
+		t.Errorf("Got %v %v, Want id fixed", known, err)
+	}
+
+	t.Run("Details fill in new profiles", func(t *testing.T) {
+		p, err := ProvisionProfile(s, Profile{
+			User:        User{Name: "oidc:https://idp.test/1"},
+			Handle:      "jane",
+			DisplayName: "Jane Doe",
+			AvatarURL:   "javascript:alert(1)",
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if p.Handle != "jane" || p.DisplayName != "Jane Doe" || p.AvatarURL != "" {
+			t.Errorf("Got %+v, Want the valid details only", p)
+		}
+
+		if got, err := s.GetByHandle("jane"); err != nil || got.ID != p.ID {
+			t.Errorf("Got %v %v, Want %s", got, err, p.ID)
+		}
+	})
+
+	t.Run("Taken handles are left out", func(t *testing.T) {
+		for _, handle := range []string{"local", "jane"} {
+			p, err := ProvisionProfile(s, Profile{User: User{Name: "oidc:https://idp.test/" + handle}, Handle: handle})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if p.Handle != "" {
+				t.Errorf("Got handle %q, Want none", p.Handle)
+			}
+		}
+
+		if got, _ := s.GetByHandle("local"); got.ID != "fixed" {
+			t.Errorf("Got %v, Want the user named local", got)
+		}
+	})
+}
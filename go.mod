@@ -1,8 +1,10 @@
 module github.com/DanyPops/logues
 
-go 1.22.0
+go 1.24.0
 
 require (
 	github.com/gorilla/websocket v1.5.1
+	golang.org/x/crypto v0.22.0
 	golang.org/x/net v0.24.0
+	golang.org/x/text v0.14.0
 )